@@ -0,0 +1,115 @@
+package controllers
+
+import (
+	"eCommerce/models"
+	"eCommerce/services"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type AccountController struct {
+	accountService *services.AccountService
+}
+
+func NewAccountController(accountService *services.AccountService) *AccountController {
+	return &AccountController{
+		accountService: accountService,
+	}
+}
+
+func (ac *AccountController) ExportData(c *gin.Context) {
+	userIdRaw, exists := c.Get("UserId")
+	userId, ok := userIdRaw.(string)
+	if !exists || !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID in context"})
+		return
+	}
+
+	archive, err := ac.accountService.ExportData(userId, c.GetString("SessionId"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	filename := fmt.Sprintf("account-export-%s.zip", time.Now().Format("2006-01-02"))
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	c.Data(http.StatusOK, "application/zip", archive)
+}
+
+func (ac *AccountController) RequestDeletion(c *gin.Context) {
+	userIdRaw, exists := c.Get("UserId")
+	userId, ok := userIdRaw.(string)
+	if !exists || !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID in context"})
+		return
+	}
+
+	var request models.DeleteAccountRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	deletion, err := ac.accountService.RequestDeletion(userId, c.GetString("SessionId"), request.Password)
+	if err != nil {
+		status := http.StatusBadRequest
+		switch {
+		case strings.Contains(err.Error(), "failed to"):
+			status = http.StatusInternalServerError
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"deletion": deletion,
+		"message":  "Your account will be deleted on the scheduled date. You can cancel until then.",
+	})
+}
+
+func (ac *AccountController) GetDeletion(c *gin.Context) {
+	userIdRaw, exists := c.Get("UserId")
+	userId, ok := userIdRaw.(string)
+	if !exists || !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID in context"})
+		return
+	}
+
+	deletion, err := ac.accountService.GetDeletion(userId)
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "no account deletion is scheduled"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"deletion": deletion})
+}
+
+func (ac *AccountController) CancelDeletion(c *gin.Context) {
+	userIdRaw, exists := c.Get("UserId")
+	userId, ok := userIdRaw.(string)
+	if !exists || !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID in context"})
+		return
+	}
+
+	err := ac.accountService.CancelDeletion(userId)
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "no account deletion is scheduled"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Account deletion cancelled"})
+}
@@ -0,0 +1,82 @@
+package controllers
+
+import (
+	"eCommerce/models"
+	"eCommerce/services"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+type AdminController struct {
+	adminService *services.AdminService
+}
+
+func NewAdminController(adminService *services.AdminService) *AdminController {
+	return &AdminController{
+		adminService: adminService,
+	}
+}
+
+func (ac *AdminController) GetUsers(c *gin.Context) {
+	filters := map[string]string{}
+	if value := c.Query("role"); value != "" {
+		filters["role"] = value
+	}
+	if value := c.Query("email"); value != "" {
+		filters["email"] = value
+	}
+	if value := c.Query("limit"); value != "" {
+		filters["limit"] = value
+	}
+	if value := c.Query("offset"); value != "" {
+		filters["offset"] = value
+	}
+
+	users, err := ac.adminService.GetUsers(filters)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"users": users})
+}
+
+func (ac *AdminController) UpdateUserRole(c *gin.Context) {
+	adminIdRaw, exists := c.Get("UserId")
+	adminId, ok := adminIdRaw.(string)
+	if !exists || !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID in context"})
+		return
+	}
+
+	var request models.RoleUpdate
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if request.UserId == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "userId is required"})
+		return
+	}
+
+	err := ac.adminService.UpdateUserRole(adminId, request.UserId, request.Role, c.ClientIP())
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+			return
+		}
+		status := http.StatusBadRequest
+		switch {
+		case strings.Contains(err.Error(), "error"), strings.Contains(err.Error(), "failed to"):
+			status = http.StatusInternalServerError
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Role updated"})
+}
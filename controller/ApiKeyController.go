@@ -0,0 +1,97 @@
+package controllers
+
+import (
+	"eCommerce/models"
+	"eCommerce/services"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ApiKeyController struct {
+	apiKeyService *services.ApiKeyService
+}
+
+func NewApiKeyController(apiKeyService *services.ApiKeyService) *ApiKeyController {
+	return &ApiKeyController{
+		apiKeyService: apiKeyService,
+	}
+}
+
+func (akc *ApiKeyController) CreateKey(c *gin.Context) {
+	userIdRaw, exists := c.Get("UserId")
+	userId, ok := userIdRaw.(string)
+	if !exists || !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID in context"})
+		return
+	}
+
+	var request models.ApiKeyRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	apiKey, key, err := akc.apiKeyService.CreateKey(userId, c.GetString("Role"), c.ClientIP(), &request)
+	if err != nil {
+		status := http.StatusBadRequest
+		switch {
+		case strings.Contains(err.Error(), "error"), strings.Contains(err.Error(), "failed to"):
+			status = http.StatusInternalServerError
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"apiKey":  apiKey,
+		"key":     key,
+		"message": "Store this key now, it won't be shown again.",
+	})
+}
+
+func (akc *ApiKeyController) GetKeys(c *gin.Context) {
+	userIdRaw, exists := c.Get("UserId")
+	userId, ok := userIdRaw.(string)
+	if !exists || !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID in context"})
+		return
+	}
+
+	keys, err := akc.apiKeyService.GetKeys(userId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"apiKeys": keys})
+}
+
+func (akc *ApiKeyController) RevokeKey(c *gin.Context) {
+	userIdRaw, exists := c.Get("UserId")
+	userId, ok := userIdRaw.(string)
+	if !exists || !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID in context"})
+		return
+	}
+
+	keyId := c.Query("keyId")
+	if keyId == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing keyId in query"})
+		return
+	}
+
+	err := akc.apiKeyService.RevokeKey(userId, keyId, c.ClientIP())
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "api key not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "API key revoked"})
+}
@@ -0,0 +1,67 @@
+package controllers
+
+import (
+	"eCommerce/services"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+type AuditController struct {
+	auditService *services.AuditService
+}
+
+func NewAuditController(auditService *services.AuditService) *AuditController {
+	return &AuditController{
+		auditService: auditService,
+	}
+}
+
+func (ac *AuditController) GetEvents(c *gin.Context) {
+	filters := map[string]string{}
+	for _, key := range []string{"action", "actorId", "targetType", "targetId", "ipAddress", "from", "to", "limit", "offset"} {
+		if value := c.Query(key); value != "" {
+			filters[key] = value
+		}
+	}
+
+	events, err := ac.auditService.GetEvents(filters)
+	if err != nil {
+		status := http.StatusBadRequest
+		switch {
+		case strings.Contains(err.Error(), "error"):
+			status = http.StatusInternalServerError
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"events": events})
+}
+
+// GetProductHistory returns the changes to the vendor's own products. productId is optional.
+func (ac *AuditController) GetProductHistory(c *gin.Context) {
+	vendorIdRaw, exists := c.Get("UserId")
+	vendorId, ok := vendorIdRaw.(string)
+	if !exists || !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID in context"})
+		return
+	}
+
+	filters := map[string]string{}
+	if value := c.Query("limit"); value != "" {
+		filters["limit"] = value
+	}
+	if value := c.Query("offset"); value != "" {
+		filters["offset"] = value
+	}
+
+	events, err := ac.auditService.GetProductHistory(vendorId, c.Query("productId"), filters)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"events": events})
+}
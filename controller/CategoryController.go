@@ -0,0 +1,128 @@
+package controllers
+
+import (
+	"eCommerce/models"
+	"eCommerce/services"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+type CategoryController struct {
+	categoryService *services.CategoryService
+}
+
+func NewCategoryController(categoryService *services.CategoryService) *CategoryController {
+	return &CategoryController{
+		categoryService: categoryService,
+	}
+}
+
+func categoryErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, models.ErrNotFound):
+		return http.StatusNotFound
+	case strings.Contains(err.Error(), "error"), strings.Contains(err.Error(), "failed to"):
+		return http.StatusInternalServerError
+	}
+	return http.StatusBadRequest
+}
+
+// GetTree is the public category tree for catalog navigation
+func (cc *CategoryController) GetTree(c *gin.Context) {
+	categories, err := cc.categoryService.GetTree()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"categories": categories})
+}
+
+func (cc *CategoryController) GetCategories(c *gin.Context) {
+	categories, err := cc.categoryService.GetCategories()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"categories": categories})
+}
+
+func (cc *CategoryController) CreateCategory(c *gin.Context) {
+	adminIdRaw, exists := c.Get("UserId")
+	adminId, ok := adminIdRaw.(string)
+	if !exists || !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID in context"})
+		return
+	}
+
+	var request models.CategoryRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	category, err := cc.categoryService.CreateCategory(adminId, c.ClientIP(), &request)
+	if err != nil {
+		c.JSON(categoryErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"category": category})
+}
+
+func (cc *CategoryController) UpdateCategory(c *gin.Context) {
+	adminIdRaw, exists := c.Get("UserId")
+	adminId, ok := adminIdRaw.(string)
+	if !exists || !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID in context"})
+		return
+	}
+
+	categoryId := c.Query("categoryId")
+	if categoryId == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing categoryId in query"})
+		return
+	}
+
+	var request models.CategoryRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	category, err := cc.categoryService.UpdateCategory(adminId, categoryId, c.ClientIP(), &request)
+	if err != nil {
+		c.JSON(categoryErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"category": category})
+}
+
+// DeleteCategory deletes the category in ?categoryId, moving its products to ?moveTo
+func (cc *CategoryController) DeleteCategory(c *gin.Context) {
+	adminIdRaw, exists := c.Get("UserId")
+	adminId, ok := adminIdRaw.(string)
+	if !exists || !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID in context"})
+		return
+	}
+
+	categoryId := c.Query("categoryId")
+	if categoryId == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing categoryId in query"})
+		return
+	}
+
+	err := cc.categoryService.DeleteCategory(adminId, categoryId, c.Query("moveTo"), c.ClientIP())
+	if err != nil {
+		c.JSON(categoryErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Category deleted successfully"})
+}
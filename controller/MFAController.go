@@ -0,0 +1,102 @@
+package controllers
+
+import (
+	"eCommerce/models"
+	"eCommerce/services"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+type MFAController struct {
+	mfaService *services.MFAService
+}
+
+func NewMFAController(mfaService *services.MFAService) *MFAController {
+	return &MFAController{
+		mfaService: mfaService,
+	}
+}
+
+func mfaErrorStatus(err error) int {
+	status := http.StatusBadRequest
+	switch {
+	case strings.Contains(err.Error(), "failed to"):
+		status = http.StatusInternalServerError
+	}
+	return status
+}
+
+func (mc *MFAController) Enroll(c *gin.Context) {
+	userIdRaw, exists := c.Get("UserId")
+	userId, ok := userIdRaw.(string)
+	if !exists || !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID in context"})
+		return
+	}
+
+	emailRaw, exists := c.Get("Email")
+	email, ok := emailRaw.(string)
+	if !exists || !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid email in context"})
+		return
+	}
+
+	enrollment, err := mc.mfaService.Enroll(userId, email)
+	if err != nil {
+		c.JSON(mfaErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"enrollment": enrollment})
+}
+
+func (mc *MFAController) ConfirmEnrollment(c *gin.Context) {
+	userIdRaw, exists := c.Get("UserId")
+	userId, ok := userIdRaw.(string)
+	if !exists || !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID in context"})
+		return
+	}
+
+	var request models.MFACode
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	recoveryCodes, err := mc.mfaService.ConfirmEnrollment(userId, request.Code, c.ClientIP())
+	if err != nil {
+		c.JSON(mfaErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":       "MFA enabled. Store these recovery codes somewhere safe, they won't be shown again.",
+		"recoveryCodes": recoveryCodes,
+	})
+}
+
+func (mc *MFAController) Disable(c *gin.Context) {
+	userIdRaw, exists := c.Get("UserId")
+	userId, ok := userIdRaw.(string)
+	if !exists || !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID in context"})
+		return
+	}
+
+	var request models.MFACode
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := mc.mfaService.Disable(userId, request.Code, c.ClientIP())
+	if err != nil {
+		c.JSON(mfaErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "MFA disabled"})
+}
@@ -0,0 +1,186 @@
+package controllers
+
+import (
+	"eCommerce/models"
+	"eCommerce/services"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// NotificationController serves one inbox. SetupRouter creates one for the customer
+// inbox under /protected and one for the vendor inbox under /vendor.
+type NotificationController struct {
+	notificationService *services.NotificationService
+	audience            string
+}
+
+func NewNotificationController(notificationService *services.NotificationService, audience string) *NotificationController {
+	return &NotificationController{
+		notificationService: notificationService,
+		audience:            audience,
+	}
+}
+
+func (nc *NotificationController) GetNotifications(c *gin.Context) {
+	userIdRaw, exists := c.Get("UserId")
+	userId, ok := userIdRaw.(string)
+	if !exists || !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID in context"})
+		return
+	}
+
+	filters := map[string]string{}
+	for _, key := range []string{"unread", "limit", "offset"} {
+		if value := c.Query(key); value != "" {
+			filters[key] = value
+		}
+	}
+
+	notifications, err := nc.notificationService.GetNotifications(userId, nc.audience, filters)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	unread, err := nc.notificationService.GetUnreadCount(userId, nc.audience)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"notifications": notifications, "unread": unread})
+}
+
+func (nc *NotificationController) GetUnreadCount(c *gin.Context) {
+	userIdRaw, exists := c.Get("UserId")
+	userId, ok := userIdRaw.(string)
+	if !exists || !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID in context"})
+		return
+	}
+
+	unread, err := nc.notificationService.GetUnreadCount(userId, nc.audience)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"unread": unread})
+}
+
+func (nc *NotificationController) MarkRead(c *gin.Context) {
+	userIdRaw, exists := c.Get("UserId")
+	userId, ok := userIdRaw.(string)
+	if !exists || !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID in context"})
+		return
+	}
+
+	notificationId, err := strconv.ParseInt(c.Query("notificationId"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid notificationId"})
+		return
+	}
+
+	err = nc.notificationService.MarkRead(userId, nc.audience, notificationId)
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Notification not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Notification marked as read"})
+}
+
+func (nc *NotificationController) MarkAllRead(c *gin.Context) {
+	userIdRaw, exists := c.Get("UserId")
+	userId, ok := userIdRaw.(string)
+	if !exists || !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID in context"})
+		return
+	}
+
+	marked, err := nc.notificationService.MarkAllRead(userId, nc.audience)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"marked": marked})
+}
+
+func (nc *NotificationController) GetPreferences(c *gin.Context) {
+	userIdRaw, exists := c.Get("UserId")
+	userId, ok := userIdRaw.(string)
+	if !exists || !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID in context"})
+		return
+	}
+
+	preferences, err := nc.notificationService.GetPreferences(userId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"preferences": preferences})
+}
+
+func (nc *NotificationController) UpdatePreferences(c *gin.Context) {
+	userIdRaw, exists := c.Get("UserId")
+	userId, ok := userIdRaw.(string)
+	if !exists || !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID in context"})
+		return
+	}
+
+	var update models.NotificationPreferences
+	if err := c.ShouldBindJSON(&update); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	preferences, err := nc.notificationService.UpdatePreferences(userId, update)
+	if err != nil {
+		status := http.StatusBadRequest
+		switch {
+		case strings.Contains(err.Error(), "error"), strings.Contains(err.Error(), "failed to"):
+			status = http.StatusInternalServerError
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"preferences": preferences})
+}
+
+// Unsubscribe handles the link in optional emails. It needs no login: mail clients POST
+// to it for one-click unsubscribe (RFC 8058), and the frontend's unsubscribe page posts
+// the token from the link in the email body.
+func (nc *NotificationController) Unsubscribe(c *gin.Context) {
+	token := c.Query("token")
+	if token == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing token in query"})
+		return
+	}
+
+	category, err := nc.notificationService.Unsubscribe(token)
+	if err != nil {
+		status := http.StatusBadRequest
+		switch {
+		case strings.Contains(err.Error(), "error"), strings.Contains(err.Error(), "failed to"), strings.Contains(err.Error(), "not set"):
+			status = http.StatusInternalServerError
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "You have been unsubscribed", "category": category})
+}
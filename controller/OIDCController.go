@@ -0,0 +1,106 @@
+package controllers
+
+import (
+	"crypto/subtle"
+	"eCommerce/services"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// oidcStateCookie holds the state of the login started in this browser
+const oidcStateCookie = "oidcState"
+
+type OIDCController struct {
+	oidcService *services.OIDCService
+	userService *services.UserService
+}
+
+func NewOIDCController(oidcService *services.OIDCService, userService *services.UserService) *OIDCController {
+	return &OIDCController{
+		oidcService: oidcService,
+		userService: userService,
+	}
+}
+
+// StartLogin redirects the browser to the provider's login page
+func (oc *OIDCController) StartLogin(c *gin.Context) {
+	provider := c.Query("provider")
+	if provider == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "provider is required"})
+		return
+	}
+
+	authURL, state, err := oc.oidcService.StartLogin(provider)
+	if err != nil {
+		status := http.StatusBadRequest
+		switch {
+		case strings.Contains(err.Error(), "failed to"):
+			status = http.StatusInternalServerError
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Ties the state to this browser, so a callback started by someone else (login
+	// CSRF) is refused. Lax, since the provider sends the browser back with a top level
+	// GET.
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(
+		oidcStateCookie,
+		state,
+		10*60,           // maxAge in seconds, as long as the stored state lives
+		"/account/oidc", // only sent to the callback
+		"",
+		false, // secure (set true in production with HTTPS)
+		true,  // httpOnly
+	)
+
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// Callback is the redirect URL registered with the provider. It responds like Login.
+func (oc *OIDCController) Callback(c *gin.Context) {
+	browserState, _ := c.Cookie(oidcStateCookie)
+	c.SetCookie(oidcStateCookie, "", -1, "/account/oidc", "", false, true)
+
+	if providerError := c.Query("error"); providerError != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "login was not completed: " + providerError})
+		return
+	}
+
+	provider := c.Query("provider")
+	code := c.Query("code")
+	state := c.Query("state")
+	if provider == "" || code == "" || state == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "provider, code and state are required"})
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(browserState), []byte(state)) != 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "login was not started in this browser"})
+		return
+	}
+
+	identity, err := oc.oidcService.CompleteLogin(provider, code, state)
+	if err != nil {
+		writeLoginError(c, err)
+		return
+	}
+
+	result, err := oc.userService.LoginWithIdentity(identity, newSessionInfo(c, ""))
+	if err != nil {
+		writeLoginError(c, err)
+		return
+	}
+
+	if result.MFARequired {
+		c.JSON(http.StatusOK, gin.H{
+			"mfaRequired": true,
+			"mfaToken":    result.MFAToken,
+		})
+		return
+	}
+
+	writeLoginResponse(c, result)
+}
@@ -0,0 +1,53 @@
+package controllers
+
+import (
+	"eCommerce/services"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestOIDCCallbackChecksStateCookie(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	// No providers: a callback that gets past the cookie check fails on the provider
+	controller := NewOIDCController(services.NewOIDCService(nil, nil), nil)
+	router := gin.New()
+	router.GET("/account/oidc/callback", controller.Callback)
+
+	tests := []struct {
+		name       string
+		cookie     string
+		wantStatus int
+	}{
+		{"no cookie", "", http.StatusBadRequest},
+		{"other login's state", "state-of-the-attacker", http.StatusBadRequest},
+		{"matching state", "state-1", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/account/oidc/callback?provider=mock&code=code&state=state-1", nil)
+			if tt.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: oidcStateCookie, Value: tt.cookie})
+			}
+			res := httptest.NewRecorder()
+			router.ServeHTTP(res, req)
+
+			if res.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", res.Code, tt.wantStatus, res.Body)
+			}
+
+			// The state cookie is single use whatever the outcome
+			cleared := false
+			for _, cookie := range res.Result().Cookies() {
+				if cookie.Name == oidcStateCookie && cookie.MaxAge < 0 {
+					cleared = true
+				}
+			}
+			if !cleared {
+				t.Fatalf("state cookie was not cleared")
+			}
+		})
+	}
+}
@@ -1,19 +1,34 @@
 package controllers
 
 import (
+	"eCommerce/models"
 	"eCommerce/services"
+	"errors"
+	"fmt"
+	"io"
+	"log"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/gin-contrib/sse"
 	"github.com/gin-gonic/gin"
 )
 
+// streamHeartbeat keeps idle streams from being closed by proxies. Each heartbeat also
+// re-reads the events, in case a notification was lost.
+const streamHeartbeat = 25 * time.Second
+
 type OrderController struct {
-	orderService *services.OrderService
+	orderService       *services.OrderService
+	orderStreamService *services.OrderStreamService
 }
 
-func NewOrderController(orderService *services.OrderService) *OrderController {
+func NewOrderController(orderService *services.OrderService, orderStreamService *services.OrderStreamService) *OrderController {
 	return &OrderController{
-		orderService: orderService,
+		orderService:       orderService,
+		orderStreamService: orderStreamService,
 	}
 }
 
@@ -56,3 +71,117 @@ func (oc *OrderController) ViewOrders(c *gin.Context) {
 
 	c.JSON(http.StatusOK, gin.H{"orders": orders})
 }
+
+func (oc *OrderController) UpdateOrderStatus(c *gin.Context) {
+	var update models.OrderStatusUpdate
+	if err := c.ShouldBindJSON(&update); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if update.OrderId == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing orderId"})
+		return
+	}
+
+	err := oc.orderService.UpdateOrderStatus(update.OrderId, update.Status)
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+			return
+		}
+		status := http.StatusBadRequest
+		switch {
+		case strings.Contains(err.Error(), "error"), strings.Contains(err.Error(), "failed to"):
+			status = http.StatusInternalServerError
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Order status updated successfully"})
+}
+
+// StreamOrders is a Server-Sent Events stream of status changes to the caller's orders.
+// A client that reconnects with Last-Event-ID (or ?lastEventId=) receives what it
+// missed; a new stream starts with the changes from now on.
+func (oc *OrderController) StreamOrders(c *gin.Context) {
+	userIdRaw, exists := c.Get("UserId")
+	userId, ok := userIdRaw.(string)
+	if !exists || !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID in context"})
+		return
+	}
+
+	// Subscribe before reading the starting point, so a change in between isn't missed
+	wake, unsubscribe := oc.orderStreamService.Subscribe(userId)
+	defer unsubscribe()
+
+	lastEventIdRaw := c.GetHeader("Last-Event-ID")
+	if lastEventIdRaw == "" {
+		lastEventIdRaw = c.Query("lastEventId")
+	}
+	var lastEventId int64
+	if lastEventIdRaw != "" {
+		var err error
+		lastEventId, err = strconv.ParseInt(lastEventIdRaw, 10, 64)
+		if err != nil || lastEventId < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid Last-Event-ID"})
+			return
+		}
+	} else {
+		var err error
+		lastEventId, err = oc.orderStreamService.GetLatestEventId(userId)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+
+	// sendEvents writes everything after lastEventId, batch by batch
+	sendEvents := func() bool {
+		for {
+			events, err := oc.orderStreamService.GetEventsSince(userId, lastEventId)
+			if err != nil {
+				log.Println("order stream:", err)
+				return false
+			}
+			for _, event := range events {
+				c.Render(-1, sse.Event{
+					Id:    strconv.FormatInt(event.EventId, 10),
+					Event: "order.status",
+					Data:  event,
+				})
+				lastEventId = event.EventId
+			}
+			if len(events) < services.OrderEventsBatchSize {
+				return true
+			}
+		}
+	}
+
+	// Tells EventSource to reconnect after 3 seconds, and sends what a resuming client missed
+	c.Render(-1, sse.Event{Event: "ready", Retry: 3000, Data: gin.H{"lastEventId": lastEventId}})
+	if !sendEvents() {
+		return
+	}
+	c.Writer.Flush()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case <-wake:
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+		}
+		return sendEvents()
+	})
+}
@@ -0,0 +1,59 @@
+package controllers
+
+import (
+	"eCommerce/models"
+	"eCommerce/services"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type OutboxController struct {
+	outboxService *services.OutboxService
+}
+
+func NewOutboxController(outboxService *services.OutboxService) *OutboxController {
+	return &OutboxController{
+		outboxService: outboxService,
+	}
+}
+
+func (oc *OutboxController) GetMessages(c *gin.Context) {
+	filters := map[string]string{}
+	for _, key := range []string{"status", "kind", "limit", "offset"} {
+		if value := c.Query(key); value != "" {
+			filters[key] = value
+		}
+	}
+
+	messages, err := oc.outboxService.GetMessages(filters)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"messages": messages})
+}
+
+// RetryMessage requeues a dead-lettered message
+func (oc *OutboxController) RetryMessage(c *gin.Context) {
+	messageId, err := strconv.ParseInt(c.Query("messageId"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid messageId"})
+		return
+	}
+
+	message, err := oc.outboxService.Retry(messageId)
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "no dead message with this id"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": message})
+}
@@ -1,7 +1,9 @@
 package controllers
 
 import (
+	"eCommerce/models"
 	"eCommerce/services"
+	"errors"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
@@ -17,7 +19,7 @@ func NewProductController(productService *services.ProductService) *ProductContr
 	}
 }
 
-func (pc *ProductController) GetProducts(c *gin.Context) {
+func productFilters(c *gin.Context) map[string]string {
 	filters := map[string]string{}
 	if value := c.Query("category"); value != "" {
 		filters["category"] = value
@@ -34,6 +36,9 @@ func (pc *ProductController) GetProducts(c *gin.Context) {
 	if value := c.Query("discount"); value != "" {
 		filters["discount"] = value
 	}
+	if value := c.Query("in_stock"); value != "" {
+		filters["in_stock"] = value
+	}
 	if value := c.Query("keyword"); value != "" {
 		filters["keyword"] = value
 	}
@@ -49,12 +54,73 @@ func (pc *ProductController) GetProducts(c *gin.Context) {
 	if value := c.Query("offset"); value != "" {
 		filters["offset"] = value
 	}
+	if value := c.Query("facets"); value != "" {
+		filters["facets"] = value
+	}
+	return filters
+}
+
+func (pc *ProductController) GetProducts(c *gin.Context) {
+	result, err := pc.productService.GetProducts(productFilters(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// GetCatalogProducts is the public product listing. It takes the same filters as
+// GetProducts.
+func (pc *ProductController) GetCatalogProducts(c *gin.Context) {
+	result, err := pc.productService.GetProducts(productFilters(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+func (pc *ProductController) GetCatalogProduct(c *gin.Context) {
+	product, err := pc.productService.GetCatalogProduct(c.Param("id"))
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
 
-	products, err := pc.productService.GetProducts(filters)
+	c.JSON(http.StatusOK, gin.H{"product": product})
+}
+
+// GetStorefront returns the vendor's public profile and their active products, filtered
+// like the catalog listing
+func (pc *ProductController) GetStorefront(c *gin.Context) {
+	vendorId := c.Param("id")
+	storefront, err := pc.productService.GetStorefront(vendorId)
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Vendor not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	filters := productFilters(c)
+	filters["vendor"] = vendorId
+	result, err := pc.productService.GetProducts(filters)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"products": products})
+	response := gin.H{"vendor": storefront, "products": result.Products, "total": result.Total}
+	if result.Facets != nil {
+		response["facets"] = result.Facets
+	}
+	c.JSON(http.StatusOK, response)
 }
@@ -0,0 +1,31 @@
+package controllers
+
+import (
+	"eCommerce/services"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type SigningKeyController struct {
+	signingKeyService *services.SigningKeyService
+}
+
+func NewSigningKeyController(signingKeyService *services.SigningKeyService) *SigningKeyController {
+	return &SigningKeyController{
+		signingKeyService: signingKeyService,
+	}
+}
+
+// GetJWKS publishes the public keys that verify access tokens
+func (skc *SigningKeyController) GetJWKS(c *gin.Context) {
+	jwks, err := skc.signingKeyService.JWKS()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Well below the time a new key is published before it is used
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, jwks)
+}
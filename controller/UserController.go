@@ -1,9 +1,14 @@
 package controllers
 
 import (
+	"eCommerce/helpers"
 	"eCommerce/models"
 	"eCommerce/services"
+	"errors"
+	"log"
+	"math"
 	"net/http"
+	"strconv"
 	"strings"
 
 	"github.com/gin-gonic/gin"
@@ -19,6 +24,18 @@ func NewUserController(userService *services.UserService) *UserController {
 	}
 }
 
+func newSessionInfo(c *gin.Context, device string) *models.SessionInfo {
+	if device == "" {
+		device = helpers.DeviceFromUserAgent(c.Request.UserAgent())
+	}
+
+	return &models.SessionInfo{
+		Device:    device,
+		IPAddress: c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+	}
+}
+
 func (uc *UserController) Login(c *gin.Context) {
 	var credentials models.Credentials
 	if err := c.ShouldBindJSON(&credentials); err != nil {
@@ -28,33 +45,80 @@ func (uc *UserController) Login(c *gin.Context) {
 		return
 	}
 
-	user, accessToken, refreshToken, err := uc.UserService.Login(&credentials)
+	result, err := uc.UserService.Login(&credentials, newSessionInfo(c, credentials.Device))
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{
-			"error": err.Error(),
+		writeLoginError(c, err)
+		return
+	}
+
+	if result.MFARequired {
+		c.JSON(http.StatusOK, gin.H{
+			"mfaRequired": true,
+			"mfaToken":    result.MFAToken,
 		})
 		return
 	}
 
+	writeLoginResponse(c, result)
+}
+
+func (uc *UserController) LoginMFA(c *gin.Context) {
+	var request models.MFALoginRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if request.MFAToken == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "mfaToken is required"})
+		return
+	}
+
+	result, err := uc.UserService.LoginMFA(&request, newSessionInfo(c, request.Device))
+	if err != nil {
+		writeLoginError(c, err)
+		return
+	}
+
+	writeLoginResponse(c, result)
+}
+
+func writeLoginError(c *gin.Context, err error) {
+	var throttled *services.LoginThrottledError
+	if errors.As(err, &throttled) {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(throttled.RetryAfter.Seconds()))))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+		return
+	}
+
+	status := http.StatusUnauthorized
+	switch {
+	case strings.Contains(err.Error(), "failed to"):
+		status = http.StatusInternalServerError
+	}
+	c.JSON(status, gin.H{"error": err.Error()})
+}
+
+func writeLoginResponse(c *gin.Context, result *models.LoginResult) {
 	c.SetCookie(
-		"refreshToken", // cookie name
-		refreshToken,   // value
-		7*24*60*60,     // maxAge in seconds (7 days)
-		"/",            // path
-		"",             // domain (empty = current domain)
-		false,          // secure (set true in production with HTTPS)
-		true,           // httpOnly (can't be accessed by JS)
+		"refreshToken",      // cookie name
+		result.RefreshToken, // value
+		7*24*60*60,          // maxAge in seconds (7 days)
+		"/",                 // path
+		"",                  // domain (empty = current domain)
+		false,               // secure (set true in production with HTTPS)
+		true,                // httpOnly (can't be accessed by JS)
 	)
 
 	c.JSON(http.StatusOK, gin.H{
 		"user": models.SanitizedUser{
-			UserId:      user.UserId,
-			Name:        user.Name,
-			Email:       user.Email,
-			PhoneNumber: user.PhoneNumber,
-			Verified:    user.Verified,
+			UserId:      result.User.UserId,
+			Name:        result.User.Name,
+			Email:       result.User.Email,
+			PhoneNumber: result.User.PhoneNumber,
+			Verified:    result.User.Verified,
 		},
-		"token": accessToken,
+		"token": result.AccessToken,
 	})
 }
 
@@ -87,13 +151,45 @@ func (uc *UserController) VerifyUser(c *gin.Context) {
 
 	err := uc.UserService.VerifyUser(verificationToken)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		status := http.StatusBadRequest
+		switch {
+		case strings.Contains(err.Error(), "failed to"), strings.Contains(err.Error(), "error"):
+			status = http.StatusInternalServerError
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "User Verified"})
 }
 
+func (uc *UserController) ResendVerification(c *gin.Context) {
+	var request models.ResendVerificationRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if request.Email == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "email is required"})
+		return
+	}
+
+	err := uc.UserService.ResendVerification(request.Email, c.ClientIP())
+	var limited *services.RateLimitedError
+	if errors.As(err, &limited) {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(limited.RetryAfter.Seconds()))))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+		return
+	}
+	// Like ForgotPassword, the response doesn't reveal whether the email exists
+	if err != nil {
+		log.Println("resend verification:", err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "If an unverified account with that email exists, a new verification link has been sent."})
+}
+
 func (uc *UserController) UpdateUser(c *gin.Context) {
 	userIdRaw, exists := c.Get("UserId")
 	userId, ok := userIdRaw.(string)
@@ -102,6 +198,13 @@ func (uc *UserController) UpdateUser(c *gin.Context) {
 		return
 	}
 
+	sessionIdRaw, exists := c.Get("SessionId")
+	sessionId, ok := sessionIdRaw.(string)
+	if !exists || !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid session ID in context"})
+		return
+	}
+
 	var user models.User
 	if err := c.ShouldBindJSON(&user); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
@@ -110,7 +213,7 @@ func (uc *UserController) UpdateUser(c *gin.Context) {
 		return
 	}
 
-	newUser, accessToken, refreshToken, err := uc.UserService.UpdateUser(&user, userId)
+	newUser, emailChangePending, err := uc.UserService.UpdateUser(&user, userId, sessionId, c.ClientIP())
 	if err != nil {
 		status := http.StatusBadRequest
 		switch {
@@ -121,36 +224,60 @@ func (uc *UserController) UpdateUser(c *gin.Context) {
 		return
 	}
 
-	if accessToken == "" && refreshToken == "" {
-		c.JSON(http.StatusOK, gin.H{"user": models.SanitizedUser{
-			UserId:      newUser.UserId,
-			Name:        newUser.Name,
-			Email:       newUser.Email,
-			PhoneNumber: newUser.PhoneNumber,
-			Verified:    newUser.Verified,
-		}})
+	response := gin.H{"user": models.SanitizedUser{
+		UserId:      newUser.UserId,
+		Name:        newUser.Name,
+		Email:       newUser.Email,
+		PhoneNumber: newUser.PhoneNumber,
+		Verified:    newUser.Verified,
+	}}
+	if emailChangePending {
+		response["message"] = "A confirmation link was sent to your new email. Your email will change once it is confirmed."
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+func (uc *UserController) ConfirmEmailChange(c *gin.Context) {
+	confirmToken := c.Query("confirmToken")
+	if confirmToken == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "confirmToken is needed"})
 		return
 	}
 
-	c.SetCookie(
-		"refreshToken", // cookie name
-		refreshToken,   // value
-		7*24*60*60,     // maxAge in seconds (7 days)
-		"/",            // path
-		"",             // domain (empty = current domain)
-		false,          // secure (set true in production with HTTPS)
-		true,           // httpOnly (can't be accessed by JS)
-	)
-	c.JSON(http.StatusOK, gin.H{
-		"user": models.SanitizedUser{
-			UserId:      newUser.UserId,
-			Name:        newUser.Name,
-			Email:       newUser.Email,
-			PhoneNumber: newUser.PhoneNumber,
-			Verified:    newUser.Verified,
-		},
-		"token": accessToken,
-	})
+	err := uc.UserService.ConfirmEmailChange(confirmToken, c.ClientIP())
+	if err != nil {
+		status := http.StatusBadRequest
+		switch {
+		case strings.Contains(err.Error(), "failed to"):
+			status = http.StatusInternalServerError
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Email updated"})
+}
+
+func (uc *UserController) CancelEmailChange(c *gin.Context) {
+	cancelToken := c.Query("cancelToken")
+	if cancelToken == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "cancelToken is needed"})
+		return
+	}
+
+	err := uc.UserService.CancelEmailChange(cancelToken, c.ClientIP())
+	if err != nil {
+		status := http.StatusBadRequest
+		switch {
+		case strings.Contains(err.Error(), "failed to"):
+			status = http.StatusInternalServerError
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Email change cancelled"})
 }
 
 func (uc *UserController) GetUserProfile(c *gin.Context) {
@@ -177,3 +304,110 @@ func (uc *UserController) GetUserProfile(c *gin.Context) {
 		},
 	})
 }
+
+func (uc *UserController) Refresh(c *gin.Context) {
+	refreshToken, err := c.Cookie("refreshToken")
+	if err != nil || refreshToken == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "refreshToken cookie is required"})
+		return
+	}
+
+	user, accessToken, newRefreshToken, err := uc.UserService.RefreshTokens(refreshToken)
+	if err != nil {
+		status := http.StatusUnauthorized
+		switch {
+		case strings.Contains(err.Error(), "failed to"):
+			status = http.StatusInternalServerError
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.SetCookie(
+		"refreshToken",  // cookie name
+		newRefreshToken, // value
+		7*24*60*60,      // maxAge in seconds (7 days)
+		"/",             // path
+		"",              // domain (empty = current domain)
+		false,           // secure (set true in production with HTTPS)
+		true,            // httpOnly (can't be accessed by JS)
+	)
+
+	c.JSON(http.StatusOK, gin.H{
+		"user": models.SanitizedUser{
+			UserId:      user.UserId,
+			Name:        user.Name,
+			Email:       user.Email,
+			PhoneNumber: user.PhoneNumber,
+			Verified:    user.Verified,
+		},
+		"token": accessToken,
+	})
+}
+
+func (uc *UserController) ForgotPassword(c *gin.Context) {
+	var request models.ForgotPasswordRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if request.Email == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "email is required"})
+		return
+	}
+
+	// The response never depends on the outcome so it can't reveal which emails exist
+	if err := uc.UserService.ForgotPassword(request.Email); err != nil {
+		log.Println("forgot password:", err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "If an account with that email exists, a password reset link has been sent."})
+}
+
+func (uc *UserController) UnlockAccount(c *gin.Context) {
+	unlockToken := c.Query("unlockToken")
+	if unlockToken == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unlockToken is needed"})
+		return
+	}
+
+	err := uc.UserService.UnlockAccount(unlockToken)
+	if err != nil {
+		status := http.StatusBadRequest
+		switch {
+		case strings.Contains(err.Error(), "failed to"):
+			status = http.StatusInternalServerError
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Account unlocked. You can log in again."})
+}
+
+func (uc *UserController) ResetPassword(c *gin.Context) {
+	var request models.ResetPasswordRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if request.ResetToken == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "resetToken is required"})
+		return
+	}
+
+	err := uc.UserService.ResetPassword(request.ResetToken, request.Password, c.ClientIP())
+	if err != nil {
+		status := http.StatusBadRequest
+		switch {
+		case strings.Contains(err.Error(), "failed to"):
+			status = http.StatusInternalServerError
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password has been reset. Please log in again."})
+}
@@ -0,0 +1,115 @@
+package controllers
+
+import (
+	"eCommerce/models"
+	"eCommerce/services"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+type VariantController struct {
+	variantService *services.VariantService
+}
+
+func NewVariantController(variantService *services.VariantService) *VariantController {
+	return &VariantController{
+		variantService: variantService,
+	}
+}
+
+func variantErrorStatus(err error) int {
+	switch {
+	case strings.HasPrefix(err.Error(), "unauthorized"):
+		return http.StatusForbidden
+	case errors.Is(err, models.ErrNotFound), err.Error() == "product not found":
+		return http.StatusNotFound
+	case strings.Contains(err.Error(), "error"), strings.Contains(err.Error(), "failed to"):
+		return http.StatusInternalServerError
+	}
+	return http.StatusBadRequest
+}
+
+func (vc *VariantController) GetVariants(c *gin.Context) {
+	vendorIdRaw, exists := c.Get("UserId")
+	vendorId, ok := vendorIdRaw.(string)
+	if !exists || !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID in context"})
+		return
+	}
+
+	productId := c.Query("productId")
+	if productId == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing productId in query"})
+		return
+	}
+
+	matrix, err := vc.variantService.GetVariants(vendorId, productId)
+	if err != nil {
+		c.JSON(variantErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, matrix)
+}
+
+// ReplaceOptions sets the whole option matrix of the product in ?productId
+func (vc *VariantController) ReplaceOptions(c *gin.Context) {
+	vendorIdRaw, exists := c.Get("UserId")
+	vendorId, ok := vendorIdRaw.(string)
+	if !exists || !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID in context"})
+		return
+	}
+
+	productId := c.Query("productId")
+	if productId == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing productId in query"})
+		return
+	}
+
+	var request models.ProductOptionsRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	matrix, err := vc.variantService.ReplaceOptions(vendorId, productId, c.ClientIP(), &request)
+	if err != nil {
+		c.JSON(variantErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, matrix)
+}
+
+func (vc *VariantController) UpdateVariants(c *gin.Context) {
+	vendorIdRaw, exists := c.Get("UserId")
+	vendorId, ok := vendorIdRaw.(string)
+	if !exists || !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID in context"})
+		return
+	}
+
+	productId := c.Query("productId")
+	if productId == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing productId in query"})
+		return
+	}
+
+	var request models.VariantsUpdateRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	matrix, err := vc.variantService.UpdateVariants(vendorId, productId, c.ClientIP(), &request)
+	if err != nil {
+		c.JSON(variantErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, matrix)
+}
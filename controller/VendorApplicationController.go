@@ -0,0 +1,153 @@
+package controllers
+
+import (
+	"eCommerce/helpers"
+	"eCommerce/models"
+	"eCommerce/services"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+type VendorApplicationController struct {
+	applicationService *services.VendorApplicationService
+}
+
+func NewVendorApplicationController(applicationService *services.VendorApplicationService) *VendorApplicationController {
+	return &VendorApplicationController{
+		applicationService: applicationService,
+	}
+}
+
+func (vac *VendorApplicationController) SubmitApplication(c *gin.Context) {
+	userIdRaw, exists := c.Get("UserId")
+	userId, ok := userIdRaw.(string)
+	if !exists || !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID in context"})
+		return
+	}
+
+	var application models.VendorApplication
+	if err := c.ShouldBindJSON(&application); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	submitted, err := vac.applicationService.SubmitApplication(userId, &application)
+	if err != nil {
+		status := http.StatusBadRequest
+		switch {
+		case strings.Contains(err.Error(), "error"), strings.Contains(err.Error(), "failed to"):
+			status = http.StatusInternalServerError
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"application": submitted})
+}
+
+// GetApplication returns the caller's latest application. refreshRequired tells the
+// client its token predates the approval and /account/refresh issues one with the
+// vendor role.
+func (vac *VendorApplicationController) GetApplication(c *gin.Context) {
+	userIdRaw, exists := c.Get("UserId")
+	userId, ok := userIdRaw.(string)
+	if !exists || !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID in context"})
+		return
+	}
+
+	application, err := vac.applicationService.GetApplication(userId)
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "no vendor application found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"application":     application,
+		"refreshRequired": application.Status == services.ApplicationApproved && c.GetString("Role") == helpers.RoleCustomer,
+	})
+}
+
+func (vac *VendorApplicationController) GetApplications(c *gin.Context) {
+	applications, err := vac.applicationService.GetApplications(c.Query("status"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"applications": applications})
+}
+
+func (vac *VendorApplicationController) GetApplicationEvents(c *gin.Context) {
+	applicationId := c.Query("applicationId")
+	if applicationId == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing applicationId in query"})
+		return
+	}
+
+	events, err := vac.applicationService.GetApplicationEvents(applicationId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"events": events})
+}
+
+func (vac *VendorApplicationController) ApproveApplication(c *gin.Context) {
+	vac.reviewApplication(c, services.ApplicationApproved)
+}
+
+func (vac *VendorApplicationController) RejectApplication(c *gin.Context) {
+	vac.reviewApplication(c, services.ApplicationRejected)
+}
+
+func (vac *VendorApplicationController) reviewApplication(c *gin.Context, status string) {
+	reviewerIdRaw, exists := c.Get("UserId")
+	reviewerId, ok := reviewerIdRaw.(string)
+	if !exists || !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID in context"})
+		return
+	}
+
+	applicationId := c.Query("applicationId")
+	if applicationId == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing applicationId in query"})
+		return
+	}
+
+	// approvals don't need a body
+	var decision models.ApplicationDecision
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&decision); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	decision.Status = status
+
+	application, err := vac.applicationService.ReviewApplication(reviewerId, applicationId, c.ClientIP(), &decision)
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "application not found"})
+			return
+		}
+		status := http.StatusBadRequest
+		switch {
+		case strings.Contains(err.Error(), "error"), strings.Contains(err.Error(), "failed to"):
+			status = http.StatusInternalServerError
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"application": application})
+}
@@ -33,7 +33,7 @@ func (vc *VendorController) AddProduct(c *gin.Context) {
 		return
 	}
 
-	newProduct, err := vc.vendorService.AddProduct(&product, vendorId)
+	newProduct, err := vc.vendorService.AddProduct(&product, vendorId, c.ClientIP())
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -95,7 +95,7 @@ func (vc *VendorController) DeleteProduct(c *gin.Context) {
 		return
 	}
 
-	err := vc.vendorService.DeleteProduct(productId, vendorId)
+	err := vc.vendorService.DeleteProduct(productId, vendorId, c.ClientIP())
 	if err != nil {
 		var status int
 		var message string
@@ -144,7 +144,7 @@ func (vc *VendorController) UpdateProduct(c *gin.Context) {
 		return
 	}
 
-	product, err := vc.vendorService.UpdateProduct(&updatedProduct, vendorId)
+	product, err := vc.vendorService.UpdateProduct(&updatedProduct, vendorId, c.ClientIP())
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -0,0 +1,183 @@
+package controllers
+
+import (
+	"eCommerce/models"
+	"eCommerce/services"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+type WebhookController struct {
+	webhookService *services.WebhookService
+}
+
+func NewWebhookController(webhookService *services.WebhookService) *WebhookController {
+	return &WebhookController{
+		webhookService: webhookService,
+	}
+}
+
+func webhookErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, models.ErrNotFound):
+		return http.StatusNotFound
+	case strings.Contains(err.Error(), "error"), strings.Contains(err.Error(), "failed to"):
+		return http.StatusInternalServerError
+	}
+	return http.StatusBadRequest
+}
+
+func (wc *WebhookController) CreateEndpoint(c *gin.Context) {
+	vendorIdRaw, exists := c.Get("UserId")
+	vendorId, ok := vendorIdRaw.(string)
+	if !exists || !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID in context"})
+		return
+	}
+
+	var request models.WebhookEndpointRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	endpoint, secret, err := wc.webhookService.CreateEndpoint(vendorId, &request)
+	if err != nil {
+		c.JSON(webhookErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"endpoint": endpoint,
+		"secret":   secret,
+		"message":  "Store this secret now, it won't be shown again.",
+	})
+}
+
+func (wc *WebhookController) GetEndpoints(c *gin.Context) {
+	vendorIdRaw, exists := c.Get("UserId")
+	vendorId, ok := vendorIdRaw.(string)
+	if !exists || !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID in context"})
+		return
+	}
+
+	endpoints, err := wc.webhookService.GetEndpoints(vendorId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"endpoints": endpoints})
+}
+
+func (wc *WebhookController) UpdateEndpoint(c *gin.Context) {
+	vendorIdRaw, exists := c.Get("UserId")
+	vendorId, ok := vendorIdRaw.(string)
+	if !exists || !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID in context"})
+		return
+	}
+
+	endpointId := c.Query("endpointId")
+	if endpointId == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing endpointId in query"})
+		return
+	}
+
+	var request models.WebhookEndpointRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	endpoint, err := wc.webhookService.UpdateEndpoint(vendorId, endpointId, &request)
+	if err != nil {
+		c.JSON(webhookErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"endpoint": endpoint})
+}
+
+func (wc *WebhookController) DeleteEndpoint(c *gin.Context) {
+	vendorIdRaw, exists := c.Get("UserId")
+	vendorId, ok := vendorIdRaw.(string)
+	if !exists || !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID in context"})
+		return
+	}
+
+	endpointId := c.Query("endpointId")
+	if endpointId == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing endpointId in query"})
+		return
+	}
+
+	err := wc.webhookService.DeleteEndpoint(vendorId, endpointId)
+	if err != nil {
+		c.JSON(webhookErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Webhook endpoint deleted successfully"})
+}
+
+// SendTestEvent delivers a webhook.test event right away and returns the attempt,
+// including the endpoint's response
+func (wc *WebhookController) SendTestEvent(c *gin.Context) {
+	vendorIdRaw, exists := c.Get("UserId")
+	vendorId, ok := vendorIdRaw.(string)
+	if !exists || !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID in context"})
+		return
+	}
+
+	endpointId := c.Query("endpointId")
+	if endpointId == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing endpointId in query"})
+		return
+	}
+
+	delivery, err := wc.webhookService.SendTestEvent(vendorId, endpointId)
+	if err != nil {
+		c.JSON(webhookErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"delivery": delivery})
+}
+
+func (wc *WebhookController) GetDeliveries(c *gin.Context) {
+	vendorIdRaw, exists := c.Get("UserId")
+	vendorId, ok := vendorIdRaw.(string)
+	if !exists || !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID in context"})
+		return
+	}
+
+	endpointId := c.Query("endpointId")
+	if endpointId == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing endpointId in query"})
+		return
+	}
+
+	filters := map[string]string{}
+	if value := c.Query("limit"); value != "" {
+		filters["limit"] = value
+	}
+	if value := c.Query("offset"); value != "" {
+		filters["offset"] = value
+	}
+
+	deliveries, err := wc.webhookService.GetDeliveries(vendorId, endpointId, filters)
+	if err != nil {
+		c.JSON(webhookErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"deliveries": deliveries})
+}
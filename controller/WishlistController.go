@@ -165,7 +165,10 @@ func (wc *WishlistController) AddToWishlist(c *gin.Context) {
 		return
 	}
 
-	wishlist, err := wc.WishlistService.AddToWishlist(userId, wishlistId, productId)
+	// Optional for products without options
+	variantId := c.Query("variantId")
+
+	wishlist, err := wc.WishlistService.AddToWishlist(userId, wishlistId, productId, variantId)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
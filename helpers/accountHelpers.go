@@ -1,11 +1,8 @@
 package helpers
 
 import (
-	"fmt"
-	"os"
 	"regexp"
 
-	"github.com/go-gomail/gomail"
 	"github.com/jmoiron/sqlx"
 )
 
@@ -34,27 +31,3 @@ func IsUserIdAvailable(db *sqlx.DB, userId string) (bool, error) {
 	}
 	return count == 0, nil // true = available
 }
-
-func SendVerificationEmail(token, userEmail string) error {
-	email := os.Getenv("APP_EMAIL")
-	password := os.Getenv("APP_PASSWORD")
-
-	m := gomail.NewMessage()
-
-	m.SetHeader("From", "adhamosman1589@gmail.com") // dev only
-	m.SetHeader("To", userEmail)
-	m.SetHeader("Subject", "Email Verification")
-
-	verificationLink := fmt.Sprintf("http://localhost:3000/verify?verificationToken=%s", token)
-
-	body := fmt.Sprintf("Please verify your account by clicking the following link:\n\n%s", verificationLink)
-	m.SetBody("text/plain", body)
-
-	d := gomail.NewDialer("smtp.gmail.com", 587, email, password)
-
-	if err := d.DialAndSend(m); err != nil {
-		return fmt.Errorf("failed to send email: %w", err)
-	}
-
-	return nil
-}
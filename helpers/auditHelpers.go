@@ -0,0 +1,45 @@
+package helpers
+
+import (
+	"eCommerce/models"
+	"encoding/json"
+	"reflect"
+	"slices"
+)
+
+// AuditDiff compares two values by their JSON fields and returns the ones that changed.
+// Fields in ignore (JSON names) are skipped, e.g. updatedAt.
+func AuditDiff(before, after any, ignore ...string) (map[string]models.AuditChange, error) {
+	beforeFields, err := jsonFields(before)
+	if err != nil {
+		return nil, err
+	}
+	afterFields, err := jsonFields(after)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := map[string]models.AuditChange{}
+	for field, to := range afterFields {
+		if slices.Contains(ignore, field) {
+			continue
+		}
+		if from := beforeFields[field]; !reflect.DeepEqual(from, to) {
+			changes[field] = models.AuditChange{From: from, To: to}
+		}
+	}
+
+	return changes, nil
+}
+
+func jsonFields(value any) (map[string]any, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	fields := map[string]any{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
@@ -0,0 +1,183 @@
+package helpers
+
+import (
+	"bytes"
+	"eCommerce/models"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	texttemplate "text/template"
+	"time"
+)
+
+// Email templates live in helpers/emails. Each email has a text and an HTML file named
+// <name>.v<version>, rendered inside layout.txt and layout.html. The text file also
+// defines the subject. Changing what an email says means adding the next version and
+// pointing its constructor below at it, so the X-Email-Template header tells which
+// wording a user received.
+//
+//go:embed emails
+var emailFiles embed.FS
+
+type emailTemplate struct {
+	text *texttemplate.Template
+	html *htmltemplate.Template
+}
+
+var (
+	emailTemplates   = map[string]*emailTemplate{}
+	emailTemplatesMu sync.Mutex
+)
+
+func loadEmailTemplate(name string) (*emailTemplate, error) {
+	emailTemplatesMu.Lock()
+	defer emailTemplatesMu.Unlock()
+
+	if tmpl, ok := emailTemplates[name]; ok {
+		return tmpl, nil
+	}
+
+	text, err := texttemplate.New(name).Option("missingkey=error").ParseFS(emailFiles, "emails/layout.txt", "emails/"+name+".txt")
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse email template %s: %w", name, err)
+	}
+	html, err := htmltemplate.New(name).Option("missingkey=error").ParseFS(emailFiles, "emails/layout.html", "emails/"+name+".html")
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse email template %s: %w", name, err)
+	}
+
+	tmpl := &emailTemplate{text: text, html: html}
+	emailTemplates[name] = tmpl
+	return tmpl, nil
+}
+
+// RenderEmail renders the template name (e.g. "verification.v1") for the recipient
+func RenderEmail(name, to string, data any) (*models.Email, error) {
+	tmpl, err := loadEmailTemplate(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var subject, text, html bytes.Buffer
+	if err := tmpl.text.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return nil, fmt.Errorf("failed to render email %s: %w", name, err)
+	}
+	if err := tmpl.text.ExecuteTemplate(&text, "layout", data); err != nil {
+		return nil, fmt.Errorf("failed to render email %s: %w", name, err)
+	}
+	if err := tmpl.html.ExecuteTemplate(&html, "layout", data); err != nil {
+		return nil, fmt.Errorf("failed to render email %s: %w", name, err)
+	}
+
+	return &models.Email{
+		To:       to,
+		Subject:  strings.TrimSpace(subject.String()),
+		TextBody: strings.TrimSpace(text.String()) + "\n",
+		HTMLBody: html.String(),
+		Template: name,
+		Category: CategoryTransactional,
+	}, nil
+}
+
+// renderOptionalEmail renders an email the user can opt out of. It carries the category
+// and user, which the outbox checks against the user's preferences before queueing it,
+// and a link that unsubscribes from the category without logging in.
+func renderOptionalEmail(name, to, userId, category string, data map[string]any) (*models.Email, error) {
+	token, err := GenerateUnsubscribeToken(userId, category)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign unsubscribe link: %w", err)
+	}
+	data["UnsubscribeLink"] = frontendLink("/unsubscribe", "token", token)
+
+	email, err := RenderEmail(name, to, data)
+	if err != nil {
+		return nil, err
+	}
+	email.Category = category
+	email.UserId = userId
+	email.UnsubscribeURL = apiLink("/account/unsubscribe", "token", token)
+	return email, nil
+}
+
+// frontendLink builds a link to the web app, FRONTEND_URL (http://localhost:3000 by
+// default) followed by path and the query parameter
+func frontendLink(path, param, value string) string {
+	base := os.Getenv("FRONTEND_URL")
+	if base == "" {
+		base = "http://localhost:3000"
+	}
+	return fmt.Sprintf("%s%s?%s=%s", strings.TrimSuffix(base, "/"), path, param, url.QueryEscape(value))
+}
+
+// apiLink builds a link to this API, API_URL (http://localhost:8000 by default) followed
+// by path and the query parameter. Mail clients POST to it for one-click unsubscribe.
+func apiLink(path, param, value string) string {
+	base := os.Getenv("API_URL")
+	if base == "" {
+		base = "http://localhost:8000"
+	}
+	return fmt.Sprintf("%s%s?%s=%s", strings.TrimSuffix(base, "/"), path, param, url.QueryEscape(value))
+}
+
+func VerificationEmail(token, userEmail string) (*models.Email, error) {
+	return RenderEmail("verification.v1", userEmail, map[string]any{
+		"Link": frontendLink("/verify", "verificationToken", token),
+	})
+}
+
+func PasswordResetEmail(token, userEmail string) (*models.Email, error) {
+	return RenderEmail("password_reset.v1", userEmail, map[string]any{
+		"Link": frontendLink("/reset-password", "resetToken", token),
+	})
+}
+
+func AccountUnlockEmail(token, userEmail string) (*models.Email, error) {
+	return RenderEmail("account_unlock.v1", userEmail, map[string]any{
+		"Link": frontendLink("/unlock-account", "unlockToken", token),
+	})
+}
+
+func EmailChangeVerificationEmail(token, newEmail string) (*models.Email, error) {
+	return RenderEmail("email_change_confirm.v1", newEmail, map[string]any{
+		"Link": frontendLink("/confirm-email", "confirmToken", token),
+	})
+}
+
+func EmailChangeNoticeEmail(token, oldEmail, newEmail string) (*models.Email, error) {
+	return RenderEmail("email_change_notice.v1", oldEmail, map[string]any{
+		"NewEmail": newEmail,
+		"Link":     frontendLink("/cancel-email-change", "cancelToken", token),
+	})
+}
+
+func OrderConfirmationEmail(orderId, userEmail string, totalPrice float64, orderDate time.Time) (*models.Email, error) {
+	return RenderEmail("order_confirmation.v1", userEmail, map[string]any{
+		"OrderId":   orderId,
+		"OrderDate": orderDate.Format("January 2, 2006 at 3:04pm"),
+		"Total":     fmt.Sprintf("$%.2f", totalPrice),
+	})
+}
+
+// OrderStatusDescriptions completes "Your order ..." for each order status
+var OrderStatusDescriptions = map[string]string{
+	"processing": "is being processed",
+	"shipping":   "has shipped",
+	"delivery":   "is out for delivery",
+	"delivered":  "was delivered",
+}
+
+func OrderStatusEmail(userId, userEmail, orderId, status string) (*models.Email, error) {
+	description, ok := OrderStatusDescriptions[status]
+	if !ok {
+		return nil, fmt.Errorf("no email for order status %q", status)
+	}
+	return renderOptionalEmail("order_status.v1", userEmail, userId, CategoryOrderUpdates, map[string]any{
+		"OrderId":     orderId,
+		"Status":      status,
+		"Description": description,
+	})
+}
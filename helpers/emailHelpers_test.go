@@ -0,0 +1,178 @@
+package helpers
+
+import (
+	"eCommerce/models"
+	"io/fs"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEmailTemplates(t *testing.T) {
+	t.Setenv("FRONTEND_URL", "https://shop.example.com/")
+	t.Setenv("API_URL", "https://api.example.com")
+	t.Setenv("UNSUBSCRIBE_SECRET", "test-secret")
+
+	orderDate := time.Date(2025, 3, 4, 15, 4, 0, 0, time.UTC)
+
+	tests := []struct {
+		template string
+		build    func() (*models.Email, error)
+		to       string
+		subject  string
+		category string
+		// in both bodies
+		contains []string
+		// only in one of them, e.g. because HTML escapes it
+		text, html []string
+	}{
+		{
+			template: "verification.v1",
+			build:    func() (*models.Email, error) { return VerificationEmail("verify123", "ann@example.com") },
+			to:       "ann@example.com",
+			subject:  "Email Verification",
+			contains: []string{"https://shop.example.com/verify?verificationToken=verify123"},
+		},
+		{
+			template: "password_reset.v1",
+			build:    func() (*models.Email, error) { return PasswordResetEmail("reset123", "ann@example.com") },
+			to:       "ann@example.com",
+			subject:  "Password Reset",
+			contains: []string{"https://shop.example.com/reset-password?resetToken=reset123", "30 minutes"},
+		},
+		{
+			template: "account_unlock.v1",
+			build:    func() (*models.Email, error) { return AccountUnlockEmail("unlock123", "ann@example.com") },
+			to:       "ann@example.com",
+			subject:  "Account Locked",
+			contains: []string{"https://shop.example.com/unlock-account?unlockToken=unlock123"},
+		},
+		{
+			template: "email_change_confirm.v1",
+			build:    func() (*models.Email, error) { return EmailChangeVerificationEmail("confirm123", "new@example.com") },
+			to:       "new@example.com",
+			subject:  "Confirm Your New Email",
+			contains: []string{"https://shop.example.com/confirm-email?confirmToken=confirm123"},
+		},
+		{
+			template: "email_change_notice.v1",
+			build: func() (*models.Email, error) {
+				return EmailChangeNoticeEmail("cancel123", "old@example.com", "<b>new</b>@example.com")
+			},
+			to:       "old@example.com",
+			subject:  "Email Change Requested",
+			contains: []string{"https://shop.example.com/cancel-email-change?cancelToken=cancel123"},
+			text:     []string{"<b>new</b>@example.com"},
+			html:     []string{"&lt;b&gt;new&lt;/b&gt;@example.com"},
+		},
+		{
+			template: "order_confirmation.v1",
+			build: func() (*models.Email, error) {
+				return OrderConfirmationEmail("order-42", "ann@example.com", 1234.5, orderDate)
+			},
+			to:       "ann@example.com",
+			subject:  "Order Confirmation #order-42",
+			contains: []string{"order-42", "$1234.50", "March 4, 2025 at 3:04pm"},
+		},
+		{
+			template: "order_status.v1",
+			build: func() (*models.Email, error) {
+				return OrderStatusEmail("user-1", "ann@example.com", "order-42", "shipping")
+			},
+			to:       "ann@example.com",
+			subject:  "Your order #order-42 has shipped",
+			category: CategoryOrderUpdates,
+			contains: []string{"order-42", "has shipped", "https://shop.example.com/unsubscribe?token="},
+			text:     []string{"Unsubscribe from these emails: https://shop.example.com/unsubscribe?token="},
+			html:     []string{"Unsubscribe from these emails</a>"},
+		},
+	}
+
+	tested := map[string]bool{}
+	for _, tt := range tests {
+		tested[tt.template] = true
+		t.Run(tt.template, func(t *testing.T) {
+			email, err := tt.build()
+			if err != nil {
+				t.Fatalf("rendering: %v", err)
+			}
+
+			if email.Template != tt.template || email.To != tt.to || email.Subject != tt.subject {
+				t.Fatalf("got template %q to %q subject %q, want %q to %q subject %q",
+					email.Template, email.To, email.Subject, tt.template, tt.to, tt.subject)
+			}
+			category := tt.category
+			if category == "" {
+				category = CategoryTransactional
+			}
+			if email.Category != category {
+				t.Errorf("category = %q, want %q", email.Category, category)
+			}
+
+			// Both bodies go through the shared layout
+			if !strings.Contains(email.TextBody, "\n--\neCommerce\nYou are receiving this email because of activity on your eCommerce account.\n") {
+				t.Errorf("text body is missing the layout footer:\n%s", email.TextBody)
+			}
+			if !strings.HasPrefix(email.HTMLBody, "<!DOCTYPE html>") || !strings.Contains(email.HTMLBody, "You are receiving this email") {
+				t.Errorf("html body is missing the layout:\n%s", email.HTMLBody)
+			}
+			if strings.Contains(email.TextBody, "<p>") || strings.Contains(email.TextBody, "{{") || strings.Contains(email.HTMLBody, "{{") {
+				t.Errorf("a body was rendered from the wrong template")
+			}
+
+			for _, want := range tt.contains {
+				if !strings.Contains(email.TextBody, want) {
+					t.Errorf("text body doesn't contain %q:\n%s", want, email.TextBody)
+				}
+				if !strings.Contains(email.HTMLBody, want) {
+					t.Errorf("html body doesn't contain %q:\n%s", want, email.HTMLBody)
+				}
+			}
+			for _, want := range tt.text {
+				if !strings.Contains(email.TextBody, want) {
+					t.Errorf("text body doesn't contain %q:\n%s", want, email.TextBody)
+				}
+			}
+			for _, want := range tt.html {
+				if !strings.Contains(email.HTMLBody, want) {
+					t.Errorf("html body doesn't contain %q:\n%s", want, email.HTMLBody)
+				}
+			}
+		})
+	}
+
+	// Every version of every email has to be in the table above
+	files, err := fs.Glob(emailFiles, "emails/*.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		name := strings.TrimSuffix(strings.TrimPrefix(file, "emails/"), ".txt")
+		if name != "layout" && !tested[name] {
+			t.Errorf("email template %s is not tested", name)
+		}
+	}
+}
+
+func TestOptionalEmailUnsubscribe(t *testing.T) {
+	t.Setenv("API_URL", "https://api.example.com/")
+	t.Setenv("UNSUBSCRIBE_SECRET", "test-secret")
+
+	email, err := OrderStatusEmail("user-1", "ann@example.com", "order-42", "delivered")
+	if err != nil {
+		t.Fatalf("rendering: %v", err)
+	}
+	if email.UserId != "user-1" || !strings.HasPrefix(email.UnsubscribeURL, "https://api.example.com/account/unsubscribe?token=") {
+		t.Fatalf("user %q, unsubscribe url %q", email.UserId, email.UnsubscribeURL)
+	}
+
+	token := strings.TrimPrefix(email.UnsubscribeURL, "https://api.example.com/account/unsubscribe?token=")
+	userId, category, err := ParseUnsubscribeToken(token)
+	if err != nil || userId != "user-1" || category != CategoryOrderUpdates {
+		t.Fatalf("unsubscribe token is for %q, %q (%v)", userId, category, err)
+	}
+
+	if _, err := OrderStatusEmail("user-1", "ann@example.com", "order-42", "lost"); err == nil {
+		t.Fatalf("rendered an email for an unknown status")
+	}
+}
@@ -0,0 +1,182 @@
+package helpers
+
+import (
+	"crypto/rand"
+	"eCommerce/models"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-gomail/gomail"
+)
+
+const (
+	MailBackendSMTP   = "smtp"
+	MailBackendFile   = "file"
+	MailBackendMemory = "memory"
+)
+
+// Mailer delivers rendered emails. The backend is picked by MAIL_BACKEND, see LoadMailer.
+type Mailer interface {
+	Send(email *models.Email) error
+}
+
+// LoadMailer builds the Mailer from the environment:
+//   - MAIL_BACKEND: smtp (default), file or memory
+//   - MAIL_FROM: the From address, APP_EMAIL when not set
+//   - SMTP_HOST, SMTP_PORT: smtp.gmail.com and 587 by default, logging in with APP_EMAIL
+//     and APP_PASSWORD
+//   - MAIL_DIR: where the file backend writes .eml files, ./mail by default
+func LoadMailer() (Mailer, error) {
+	from := os.Getenv("MAIL_FROM")
+	if from == "" {
+		from = os.Getenv("APP_EMAIL")
+	}
+	if from == "" {
+		return nil, fmt.Errorf("MAIL_FROM or APP_EMAIL is required")
+	}
+
+	switch backend := os.Getenv("MAIL_BACKEND"); backend {
+	case "", MailBackendSMTP:
+		host := os.Getenv("SMTP_HOST")
+		if host == "" {
+			host = "smtp.gmail.com"
+		}
+		port := 587
+		if value := os.Getenv("SMTP_PORT"); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid SMTP_PORT %q", value)
+			}
+			port = parsed
+		}
+		return NewSMTPMailer(host, port, os.Getenv("APP_EMAIL"), os.Getenv("APP_PASSWORD"), from), nil
+	case MailBackendFile:
+		dir := os.Getenv("MAIL_DIR")
+		if dir == "" {
+			dir = "mail"
+		}
+		return NewFileMailer(dir, from)
+	case MailBackendMemory:
+		return NewMemoryMailer(), nil
+	default:
+		return nil, fmt.Errorf("unsupported MAIL_BACKEND %q, use %s, %s or %s", backend, MailBackendSMTP, MailBackendFile, MailBackendMemory)
+	}
+}
+
+// newMessage builds a multipart message with the text body first and the HTML
+// alternative last, so clients that can render HTML prefer it
+func newMessage(from string, email *models.Email) *gomail.Message {
+	m := gomail.NewMessage()
+
+	m.SetHeader("From", from)
+	m.SetHeader("To", email.To)
+	m.SetHeader("Subject", email.Subject)
+	if email.Template != "" {
+		m.SetHeader("X-Email-Template", email.Template)
+	}
+	// RFC 8058 one-click unsubscribe, mail clients POST to the URL
+	if email.UnsubscribeURL != "" {
+		m.SetHeader("List-Unsubscribe", "<"+email.UnsubscribeURL+">")
+		m.SetHeader("List-Unsubscribe-Post", "List-Unsubscribe=One-Click")
+	}
+
+	m.SetBody("text/plain", email.TextBody)
+	if email.HTMLBody != "" {
+		m.AddAlternative("text/html", email.HTMLBody)
+	}
+
+	return m
+}
+
+// SMTPMailer sends through an SMTP server with STARTTLS
+type SMTPMailer struct {
+	dialer *gomail.Dialer
+	from   string
+}
+
+func NewSMTPMailer(host string, port int, username, password, from string) *SMTPMailer {
+	return &SMTPMailer{
+		dialer: gomail.NewDialer(host, port, username, password),
+		from:   from,
+	}
+}
+
+func (sm *SMTPMailer) Send(email *models.Email) error {
+	if err := sm.dialer.DialAndSend(newMessage(sm.from, email)); err != nil {
+		return fmt.Errorf("failed to send email: %w", err)
+	}
+	return nil
+}
+
+// FileMailer writes every email as an .eml file instead of sending it, so emails can be
+// opened in a mail client during local development
+type FileMailer struct {
+	dir  string
+	from string
+}
+
+func NewFileMailer(dir, from string) (*FileMailer, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create mail directory: %w", err)
+	}
+	return &FileMailer{
+		dir:  dir,
+		from: from,
+	}, nil
+}
+
+func (fm *FileMailer) Send(email *models.Email) error {
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return fmt.Errorf("failed to name email file: %w", err)
+	}
+	name := fmt.Sprintf("%s-%s.eml", time.Now().UTC().Format("20060102T150405.000"), hex.EncodeToString(suffix))
+
+	file, err := os.Create(filepath.Join(fm.dir, name))
+	if err != nil {
+		return fmt.Errorf("failed to write email: %w", err)
+	}
+	defer file.Close()
+
+	if _, err := newMessage(fm.from, email).WriteTo(file); err != nil {
+		return fmt.Errorf("failed to write email: %w", err)
+	}
+	return nil
+}
+
+// MemoryMailer keeps sent emails in memory. It is meant for tests, which can inspect
+// what would have been sent.
+type MemoryMailer struct {
+	mu   sync.Mutex
+	sent []*models.Email
+}
+
+func NewMemoryMailer() *MemoryMailer {
+	return &MemoryMailer{}
+}
+
+func (mm *MemoryMailer) Send(email *models.Email) error {
+	copied := *email
+	mm.mu.Lock()
+	mm.sent = append(mm.sent, &copied)
+	mm.mu.Unlock()
+	return nil
+}
+
+// Sent returns the emails sent so far, oldest first
+func (mm *MemoryMailer) Sent() []*models.Email {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+	return append([]*models.Email(nil), mm.sent...)
+}
+
+func (mm *MemoryMailer) Reset() {
+	mm.mu.Lock()
+	mm.sent = nil
+	mm.mu.Unlock()
+}
@@ -0,0 +1,163 @@
+package helpers
+
+import (
+	"eCommerce/models"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testEmail() *models.Email {
+	return &models.Email{
+		To:             "ann@example.com",
+		Subject:        "Your order #order-42 has shipped",
+		TextBody:       "Your order has shipped.\n",
+		HTMLBody:       "<p>Your order has shipped.</p>",
+		Template:       "order_status.v1",
+		Category:       CategoryOrderUpdates,
+		UserId:         "user-1",
+		UnsubscribeURL: "https://api.example.com/account/unsubscribe?token=abc",
+	}
+}
+
+func TestLoadMailerMemory(t *testing.T) {
+	t.Setenv("MAIL_BACKEND", MailBackendMemory)
+	t.Setenv("MAIL_FROM", "shop@example.com")
+
+	mailer, err := LoadMailer()
+	if err != nil {
+		t.Fatalf("LoadMailer: %v", err)
+	}
+	memory, ok := mailer.(*MemoryMailer)
+	if !ok {
+		t.Fatalf("LoadMailer returned %T, want *MemoryMailer", mailer)
+	}
+
+	email := testEmail()
+	if err := mailer.Send(email); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	// The mailer keeps a copy, not the caller's email
+	email.Subject = "changed"
+
+	sent := memory.Sent()
+	if len(sent) != 1 || *sent[0] != *testEmail() {
+		t.Fatalf("sent = %+v, want the test email", sent)
+	}
+
+	memory.Reset()
+	if sent := memory.Sent(); len(sent) != 0 {
+		t.Fatalf("%d emails left after Reset", len(sent))
+	}
+}
+
+func TestLoadMailerFile(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "mail")
+	t.Setenv("MAIL_BACKEND", MailBackendFile)
+	t.Setenv("MAIL_FROM", "")
+	t.Setenv("APP_EMAIL", "shop@example.com")
+	t.Setenv("MAIL_DIR", dir)
+
+	mailer, err := LoadMailer()
+	if err != nil {
+		t.Fatalf("LoadMailer: %v", err)
+	}
+	if _, ok := mailer.(*FileMailer); !ok {
+		t.Fatalf("LoadMailer returned %T, want *FileMailer", mailer)
+	}
+	if err := mailer.Send(testEmail()); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if err != nil || len(files) != 1 {
+		t.Fatalf("found %v (%v), want one .eml file", files, err)
+	}
+	file, err := os.Open(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	message, err := mail.ReadMessage(file)
+	if err != nil {
+		t.Fatalf("parsing the written email: %v", err)
+	}
+	headers := map[string]string{
+		"From":                  "shop@example.com",
+		"To":                    "ann@example.com",
+		"Subject":               "Your order #order-42 has shipped",
+		"X-Email-Template":      "order_status.v1",
+		"List-Unsubscribe":      "<https://api.example.com/account/unsubscribe?token=abc>",
+		"List-Unsubscribe-Post": "List-Unsubscribe=One-Click",
+	}
+	for name, want := range headers {
+		if got := message.Header.Get(name); got != want {
+			t.Errorf("%s header = %q, want %q", name, got, want)
+		}
+	}
+
+	mediaType, params, err := mime.ParseMediaType(message.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("content type %q (%v), want multipart/alternative", mediaType, err)
+	}
+	var parts []string
+	bodies := map[string]string{}
+	reader := multipart.NewReader(message.Body, params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("reading parts: %v", err)
+		}
+		var body io.Reader = part
+		if part.Header.Get("Content-Transfer-Encoding") == "quoted-printable" {
+			body = quotedprintable.NewReader(part)
+		}
+		content, err := io.ReadAll(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		partType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		parts = append(parts, partType)
+		// MIME lines end in CRLF
+		bodies[partType] = strings.ReplaceAll(string(content), "\r\n", "\n")
+	}
+
+	// Text first, so clients that can render HTML prefer the last part
+	if strings.Join(parts, ",") != "text/plain,text/html" {
+		t.Fatalf("parts = %v, want text/plain then text/html", parts)
+	}
+	if bodies["text/plain"] != testEmail().TextBody || bodies["text/html"] != testEmail().HTMLBody {
+		t.Fatalf("bodies = %q", bodies)
+	}
+}
+
+func TestLoadMailerErrors(t *testing.T) {
+	t.Setenv("MAIL_FROM", "")
+	t.Setenv("APP_EMAIL", "")
+	t.Setenv("MAIL_BACKEND", MailBackendMemory)
+	if _, err := LoadMailer(); err == nil {
+		t.Errorf("loaded a mailer without a From address")
+	}
+
+	t.Setenv("MAIL_FROM", "shop@example.com")
+	t.Setenv("MAIL_BACKEND", "pigeon")
+	if _, err := LoadMailer(); err == nil {
+		t.Errorf("loaded an unknown backend")
+	}
+
+	t.Setenv("MAIL_BACKEND", MailBackendSMTP)
+	t.Setenv("SMTP_PORT", "submission")
+	if _, err := LoadMailer(); err == nil {
+		t.Errorf("loaded smtp with an invalid port")
+	}
+}
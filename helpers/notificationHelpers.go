@@ -0,0 +1,119 @@
+package helpers
+
+import (
+	"eCommerce/models"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// Channels notifications are delivered through
+const (
+	ChannelEmail = "email"
+	ChannelInApp = "in_app"
+)
+
+// Notification categories. Transactional messages, e.g. verification, password reset and
+// order confirmation emails, can't be turned off and have no preference.
+const (
+	CategoryTransactional = "transactional"
+	CategoryOrderUpdates  = "order_updates"
+	CategoryPriceAlerts   = "price_alerts"
+	CategoryStoreActivity = "store_activity"
+	CategoryMarketing     = "marketing"
+)
+
+var NotificationChannels = []string{ChannelEmail, ChannelInApp}
+
+var NotificationCategories = []string{
+	CategoryOrderUpdates,
+	CategoryPriceAlerts,
+	CategoryStoreActivity,
+	CategoryMarketing,
+}
+
+// DefaultNotificationPreferences applies to every channel and category a user hasn't
+// set. Marketing is opt-in.
+func DefaultNotificationPreferences() models.NotificationPreferences {
+	preferences := models.NotificationPreferences{}
+	for _, channel := range NotificationChannels {
+		preferences[channel] = map[string]bool{}
+		for _, category := range NotificationCategories {
+			preferences[channel][category] = category != CategoryMarketing
+		}
+	}
+	return preferences
+}
+
+func ValidateNotificationPreference(channel, category string) error {
+	if !slices.Contains(NotificationChannels, channel) {
+		return fmt.Errorf("unknown channel %q, use one of %s", channel, strings.Join(NotificationChannels, ", "))
+	}
+	if !slices.Contains(NotificationCategories, category) {
+		return fmt.Errorf("unknown category %q, use one of %s", category, strings.Join(NotificationCategories, ", "))
+	}
+	return nil
+}
+
+// NotificationAllowed is the check every sender makes before sending. Transactional
+// messages, and messages without a category, are always allowed.
+func NotificationAllowed(preferences models.NotificationPreferences, channel, category string) bool {
+	if category == "" || category == CategoryTransactional {
+		return true
+	}
+	enabled, ok := preferences[channel][category]
+	if !ok {
+		return DefaultNotificationPreferences()[channel][category]
+	}
+	return enabled
+}
+
+// GenerateUnsubscribeToken signs the user and category an unsubscribe link turns off.
+// It doesn't expire, since emails are read long after they are sent, and it can only
+// ever disable emails of that one category.
+func GenerateUnsubscribeToken(userId, category string) (string, error) {
+	secret := os.Getenv("UNSUBSCRIBE_SECRET")
+	if secret == "" {
+		return "", fmt.Errorf("UNSUBSCRIBE_SECRET is not set")
+	}
+
+	claims := jwt.MapClaims{
+		"UserId":   userId,
+		"Category": category,
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString([]byte(secret))
+}
+
+func ParseUnsubscribeToken(tokenStr string) (string, string, error) {
+	secret := os.Getenv("UNSUBSCRIBE_SECRET")
+	if secret == "" {
+		return "", "", fmt.Errorf("UNSUBSCRIBE_SECRET is not set")
+	}
+
+	token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
+		if token.Method != jwt.SigningMethodHS256 {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return []byte(secret), nil
+	})
+	if err != nil || !token.Valid {
+		return "", "", fmt.Errorf("invalid unsubscribe token")
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return "", "", fmt.Errorf("invalid unsubscribe token claims")
+	}
+	userId, _ := claims["UserId"].(string)
+	category, _ := claims["Category"].(string)
+	if userId == "" || !slices.Contains(NotificationCategories, category) {
+		return "", "", fmt.Errorf("invalid unsubscribe token claims")
+	}
+
+	return userId, category, nil
+}
@@ -0,0 +1,54 @@
+package helpers
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"eCommerce/models"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// LoadOIDCProviders reads the providers named in OIDC_PROVIDERS (comma separated).
+// Each one is configured with OIDC_<NAME>_DISCOVERY_URL, _CLIENT_ID, _CLIENT_SECRET and
+// _REDIRECT_URL.
+func LoadOIDCProviders() ([]models.OIDCProviderConfig, error) {
+	var providers []models.OIDCProviderConfig
+	for _, name := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ",") {
+		name = strings.TrimSpace(strings.ToLower(name))
+		if name == "" {
+			continue
+		}
+
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+		provider := models.OIDCProviderConfig{
+			Name:         name,
+			DiscoveryURL: os.Getenv(prefix + "DISCOVERY_URL"),
+			ClientID:     os.Getenv(prefix + "CLIENT_ID"),
+			ClientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+			RedirectURL:  os.Getenv(prefix + "REDIRECT_URL"),
+		}
+		if provider.DiscoveryURL == "" || provider.ClientID == "" || provider.RedirectURL == "" {
+			return nil, fmt.Errorf("oidc provider %s needs %sDISCOVERY_URL, %sCLIENT_ID and %sREDIRECT_URL", name, prefix, prefix, prefix)
+		}
+		providers = append(providers, provider)
+	}
+
+	return providers, nil
+}
+
+// GeneratePKCEVerifier returns a random code_verifier (RFC 7636, 43 characters)
+func GeneratePKCEVerifier() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// PKCEChallenge is the S256 code_challenge for a verifier
+func PKCEChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
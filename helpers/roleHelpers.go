@@ -0,0 +1,86 @@
+package helpers
+
+import "slices"
+
+const (
+	RoleCustomer = "customer"
+	RoleVendor   = "vendor"
+	RoleAdmin    = "admin"
+	RoleSupport  = "support"
+)
+
+const (
+	PermOrdersWrite       = "orders:write"
+	PermReviewsWrite      = "reviews:write"
+	PermProductsWrite     = "products:write"
+	PermVendorReviewsRead = "vendor:reviews:read"
+	// reading the vendor inbox includes marking it read
+	PermVendorNotificationsRead = "vendor:notifications:read"
+	PermUsersRead               = "users:read"
+	PermRolesWrite              = "roles:write"
+	PermAdminAccess             = "admin:access"
+	PermVendorAccess            = "vendor:access"
+	PermApiKeysWrite            = "api-keys:write"
+	PermAuditRead               = "audit:read"
+	PermOutboxManage            = "outbox:manage"
+	PermOrdersWriteAny          = "orders:write:any"
+	PermWebhooksWrite           = "webhooks:write"
+	PermCategoriesWrite         = "categories:write"
+)
+
+// ApiKeyScopes are the permissions an API key can be limited to. vendor:access is
+// always granted so the key can reach the /vendor group at all.
+var ApiKeyScopes = []string{
+	PermProductsWrite,
+	PermVendorReviewsRead,
+	PermVendorNotificationsRead,
+	PermWebhooksWrite,
+}
+
+var rolePermissions = map[string][]string{
+	RoleCustomer: {
+		PermOrdersWrite,
+		PermReviewsWrite,
+	},
+	RoleVendor: {
+		PermOrdersWrite,
+		PermReviewsWrite,
+		PermVendorAccess,
+		PermProductsWrite,
+		PermVendorReviewsRead,
+		PermVendorNotificationsRead,
+		PermApiKeysWrite,
+		PermWebhooksWrite,
+	},
+	RoleSupport: {
+		PermAdminAccess,
+		PermUsersRead,
+		PermOrdersWriteAny,
+	},
+	RoleAdmin: {
+		PermAdminAccess,
+		PermUsersRead,
+		PermRolesWrite,
+		PermOrdersWriteAny,
+		PermAuditRead,
+		PermOutboxManage,
+		PermVendorAccess,
+		PermProductsWrite,
+		PermWebhooksWrite,
+		PermCategoriesWrite,
+	},
+}
+
+func IsValidRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// PermissionsForRole returns nil for unknown roles, which grants nothing
+func PermissionsForRole(role string) []string {
+	return rolePermissions[role]
+}
+
+func HasPermission(role, permission string) bool {
+	return slices.Contains(rolePermissions[role], permission)
+}
@@ -0,0 +1,133 @@
+package helpers
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+)
+
+const (
+	SigningAlgRS256 = "RS256"
+	SigningAlgEdDSA = "EdDSA"
+)
+
+// SigningKey is a private key from the keyset that signs access tokens. Kid is put in
+// the token header so verifiers can pick the matching public key from the JWKS.
+type SigningKey struct {
+	Kid string
+	Alg string
+	Key crypto.Signer
+}
+
+// LoadSigningAlgorithm reads JWT_SIGNING_ALG, RS256 by default. It only affects keys
+// created from now on; existing keys keep their algorithm until they are rotated out.
+func LoadSigningAlgorithm() (string, error) {
+	alg := os.Getenv("JWT_SIGNING_ALG")
+	switch alg {
+	case "":
+		return SigningAlgRS256, nil
+	case SigningAlgRS256, SigningAlgEdDSA:
+		return alg, nil
+	}
+	return "", fmt.Errorf("unsupported JWT_SIGNING_ALG %q, use %s or %s", alg, SigningAlgRS256, SigningAlgEdDSA)
+}
+
+func randomKid() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// GenerateSigningKey creates a new RSA 2048 or Ed25519 key with a random kid
+func GenerateSigningKey(alg string) (*SigningKey, error) {
+	kid, err := randomKid()
+	if err != nil {
+		return nil, err
+	}
+
+	var key crypto.Signer
+	switch alg {
+	case SigningAlgRS256:
+		key, err = rsa.GenerateKey(rand.Reader, 2048)
+	case SigningAlgEdDSA:
+		_, key, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm: %s", alg)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &SigningKey{Kid: kid, Alg: alg, Key: key}, nil
+}
+
+// EncodePrivateKey returns the key as a PKCS #8 PEM block for storage
+func (k *SigningKey) EncodePrivateKey() (string, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(k.Key)
+	if err != nil {
+		return "", err
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})), nil
+}
+
+// ParseSigningKey is the reverse of EncodePrivateKey
+func ParseSigningKey(kid, alg, privateKeyPEM string) (*SigningKey, error) {
+	block, _ := pem.Decode([]byte(privateKeyPEM))
+	if block == nil {
+		return nil, fmt.Errorf("invalid private key pem")
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	var key crypto.Signer
+	switch alg {
+	case SigningAlgRS256:
+		rsaKey, ok := parsed.(*rsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("key %s is not an rsa key", kid)
+		}
+		key = rsaKey
+	case SigningAlgEdDSA:
+		edKey, ok := parsed.(ed25519.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("key %s is not an ed25519 key", kid)
+		}
+		key = edKey
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm: %s", alg)
+	}
+
+	return &SigningKey{Kid: kid, Alg: alg, Key: key}, nil
+}
+
+// PublicJWK is the public half of the key as published at /.well-known/jwks.json
+func (k *SigningKey) PublicJWK() (JWK, error) {
+	jwk := JWK{Kid: k.Kid, Use: "sig", Alg: k.Alg}
+
+	switch publicKey := k.Key.Public().(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(publicKey.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(publicKey.E)).Bytes())
+	case ed25519.PublicKey:
+		jwk.Kty = "OKP"
+		jwk.Crv = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(publicKey)
+	default:
+		return JWK{}, fmt.Errorf("unsupported key type for key %s", k.Kid)
+	}
+
+	return jwk, nil
+}
@@ -1,32 +1,107 @@
 package helpers
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt"
+	"github.com/google/uuid"
 )
 
-func GenerateAccessToken(userid, email, role string) (string, error) {
+const RefreshTokenTTL = 7 * 24 * time.Hour
+
+// GenerateAccessToken signs with the current key of the keyset, so anyone holding the
+// JWKS can verify access tokens without sharing a secret
+func GenerateAccessToken(key *SigningKey, userid, email, role, sessionId string) (string, error) {
 	claims := jwt.MapClaims{
-		"UserId": userid,
-		"Email":  email,
-		"Role":   role,
-		"exp":    time.Now().Add(15 * time.Minute).Unix(),
+		"UserId":    userid,
+		"Email":     email,
+		"Role":      role,
+		"SessionId": sessionId,
+		"jti":       uuid.New().String(),
+		"exp":       time.Now().Add(15 * time.Minute).Unix(),
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(os.Getenv("ACCESS_SECRET")))
+	method := jwt.GetSigningMethod(key.Alg)
+	if method == nil {
+		return "", fmt.Errorf("unsupported signing algorithm: %s", key.Alg)
+	}
+
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = key.Kid
+	return token.SignedString(key.Key)
 }
 
-func GenerateRefreshToken(userid, email, role string) (string, error) {
+// tokenId identifies this refresh token in the token store, sessionId groups every
+// token rotated from the same login so a reused token can revoke all of them.
+func GenerateRefreshToken(userid, email, role, tokenId, sessionId string, expiresAt time.Time) (string, error) {
 	claims := jwt.MapClaims{
-		"UserId": userid,
-		"Email":  email,
-		"Role":   role,
-		"exp":    time.Now().Add(7 * 24 * time.Minute).Unix(),
+		"UserId":    userid,
+		"Email":     email,
+		"Role":      role,
+		"jti":       tokenId,
+		"SessionId": sessionId,
+		"exp":       expiresAt.Unix(),
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	return token.SignedString([]byte(os.Getenv("REFRESH_SECRET")))
 }
+
+func ParseRefreshToken(tokenStr string) (jwt.MapClaims, error) {
+	token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
+		if token.Method != jwt.SigningMethodHS256 {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return []byte(os.Getenv("REFRESH_SECRET")), nil
+	})
+	if err != nil || !token.Valid {
+		return nil, fmt.Errorf("invalid or expired refresh token")
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, fmt.Errorf("invalid refresh token claims")
+	}
+
+	return claims, nil
+}
+
+// DeviceFromUserAgent gives a session a readable label when the client doesn't name it
+func DeviceFromUserAgent(userAgent string) string {
+	ua := strings.ToLower(userAgent)
+	switch {
+	case strings.Contains(ua, "iphone"), strings.Contains(ua, "ipad"):
+		return "iOS"
+	case strings.Contains(ua, "android"):
+		return "Android"
+	case strings.Contains(ua, "windows"):
+		return "Windows"
+	case strings.Contains(ua, "mac os"):
+		return "macOS"
+	case strings.Contains(ua, "linux"):
+		return "Linux"
+	default:
+		return "Unknown device"
+	}
+}
+
+// GenerateSecureToken returns a random token for links sent by email. Only its
+// HashToken digest is stored, so a leaked table can't be used to take over accounts.
+func GenerateSecureToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,99 @@
+package helpers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+const WebhookSignatureHeader = "X-Webhook-Signature"
+
+var errWebhookAddressBlocked = errors.New("webhook address is not public")
+
+// WebhooksAllowInsecure reads WEBHOOK_ALLOW_INSECURE. When "true", endpoints may use
+// plain http and private addresses such as localhost, which is only meant for local
+// development.
+func WebhooksAllowInsecure() bool {
+	return os.Getenv("WEBHOOK_ALLOW_INSECURE") == "true"
+}
+
+func GenerateWebhookSecret() (string, error) {
+	token, err := GenerateSecureToken()
+	if err != nil {
+		return "", err
+	}
+	return "whsec_" + token, nil
+}
+
+// SignWebhook returns the X-Webhook-Signature value "t=<unix time>,v1=<hex>", where v1
+// is the HMAC-SHA256 of "<unix time>.<body>" keyed with the endpoint secret. Receivers
+// recompute it and reject old timestamps to stop replays.
+func SignWebhook(secret string, timestamp time.Time, body []byte) string {
+	t := strconv.FormatInt(timestamp.Unix(), 10)
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(t))
+	mac.Write([]byte("."))
+	mac.Write(body)
+
+	return fmt.Sprintf("t=%s,v1=%s", t, hex.EncodeToString(mac.Sum(nil)))
+}
+
+// ValidateWebhookURL requires an absolute https URL, or http when insecure endpoints
+// are allowed
+func ValidateWebhookURL(rawURL string, allowInsecure bool) error {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || parsed.Host == "" {
+		return fmt.Errorf("invalid webhook url")
+	}
+	if parsed.Scheme != "https" && !(allowInsecure && parsed.Scheme == "http") {
+		return fmt.Errorf("webhook url must use https")
+	}
+	if parsed.User != nil {
+		return fmt.Errorf("webhook url can't contain credentials")
+	}
+	return nil
+}
+
+// NewWebhookClient returns the client deliveries are sent with. Unless insecure endpoints
+// are allowed, it refuses to connect to loopback, private and link-local addresses, so a
+// vendor can't point a webhook at internal services. The check runs on the resolved
+// address, which also covers DNS names pointing inside. Redirects are not followed.
+func NewWebhookClient(allowInsecure bool) *http.Client {
+	dialer := &net.Dialer{Timeout: 5 * time.Second}
+	if !allowInsecure {
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() ||
+				ip.IsLinkLocalMulticast() || ip.IsUnspecified() || ip.IsMulticast() {
+				return errWebhookAddressBlocked
+			}
+			return nil
+		}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+	transport.Proxy = nil
+
+	return &http.Client{
+		Timeout:   10 * time.Second,
+		Transport: transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
@@ -1,8 +1,11 @@
 package middlewares
 
 import (
+	"eCommerce/helpers"
+	"eCommerce/services"
+	"fmt"
 	"net/http"
-	"os"
+	"slices"
 	"strings"
 	"time"
 
@@ -10,7 +13,8 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 )
 
-func Auth(c *gin.Context) (claims jwt.MapClaims, err error) {
+// Auth verifies the access token with the key named by its kid header
+func Auth(c *gin.Context, ss *services.SessionService, sks *services.SigningKeyService) (claims jwt.MapClaims, err error) {
 	authHeader := c.GetHeader("Authorization")
 	if authHeader == "" {
 		c.JSON(http.StatusUnauthorized, gin.H{"message": "Authorization header required"})
@@ -27,8 +31,12 @@ func Auth(c *gin.Context) (claims jwt.MapClaims, err error) {
 
 	tokenStr := parts[1]
 	token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
-		return []byte(os.Getenv("ACCESS_SECRET")), nil
-	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+		kid, ok := token.Header["kid"].(string)
+		if !ok {
+			return nil, fmt.Errorf("missing kid in token header")
+		}
+		return sks.VerificationKey(kid)
+	}, jwt.WithValidMethods([]string{helpers.SigningAlgRS256, helpers.SigningAlgEdDSA}))
 
 	if err != nil || !token.Valid {
 		c.JSON(http.StatusUnauthorized, gin.H{
@@ -60,64 +68,106 @@ func Auth(c *gin.Context) (claims jwt.MapClaims, err error) {
 		return nil, err
 	}
 
-	return claims, nil
-}
-
-func RequireAuth(c *gin.Context) {
-	claims, err := Auth(c)
-	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{
-			"message": "Authorization Error",
-			"error":   err.Error(),
-		})
+	// Tokens stay valid until exp, so logout and remote revocation are enforced here
+	sessionId, sessionIdOk := claims["SessionId"].(string)
+	userId, userIdOk := claims["UserId"].(string)
+	if !sessionIdOk || !userIdOk {
+		err = fmt.Errorf("missing session in token")
+		c.JSON(http.StatusUnauthorized, gin.H{"message": "Invalid token claims"})
 		c.Abort()
-		return
+		return nil, err
 	}
 
-	email, emailOk := claims["Email"].(string)
-	userId, userIdOk := claims["UserId"].(string)
-
-	if !emailOk || !userIdOk {
-		c.JSON(http.StatusUnauthorized, gin.H{"message": "Missing or invalid token claims"})
+	if err = ss.ValidateSession(sessionId, userId); err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"message": "Session is no longer valid"})
 		c.Abort()
-		return
+		return nil, err
 	}
 
-	c.Set("Email", email)
-	c.Set("UserId", userId)
-
-	c.Next()
+	return claims, nil
 }
 
-func VendorAuth(c *gin.Context) {
-	claims, err := Auth(c)
-	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{
-			"message": "Authorization Error",
-			"error":   err.Error(),
-		})
-		c.Abort()
-		return
+func RequireAuth(ss *services.SessionService, sks *services.SigningKeyService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims, err := Auth(c, ss, sks)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"message": "Authorization Error",
+				"error":   err.Error(),
+			})
+			c.Abort()
+			return
+		}
+
+		email, emailOk := claims["Email"].(string)
+		userId, userIdOk := claims["UserId"].(string)
+		role, roleOk := claims["Role"].(string)
+
+		if !emailOk || !userIdOk || !roleOk {
+			c.JSON(http.StatusUnauthorized, gin.H{"message": "Missing or invalid token claims"})
+			c.Abort()
+			return
+		}
+
+		c.Set("Email", email)
+		c.Set("UserId", userId)
+		c.Set("SessionId", claims["SessionId"])
+		c.Set("Role", role)
+		c.Set("Permissions", helpers.PermissionsForRole(role))
+
+		c.Next()
 	}
+}
 
-	role, ok := claims["Role"].(string)
-	if !ok || role != "vendor" {
-		c.JSON(http.StatusForbidden, gin.H{"message": "Admin access required"})
-		c.Abort()
-		return
+// RequireAuthOrApiKey accepts either a Bearer access token or "Authorization: ApiKey <key>".
+// API key requests get the same context values as RequireAuth except SessionId, and
+// their Permissions are limited to the key's scopes.
+func RequireAuthOrApiKey(ss *services.SessionService, sks *services.SigningKeyService, aks *services.ApiKeyService) gin.HandlerFunc {
+	requireAuth := RequireAuth(ss, sks)
+
+	return func(c *gin.Context) {
+		key, isApiKey := strings.CutPrefix(c.GetHeader("Authorization"), "ApiKey ")
+		if !isApiKey {
+			requireAuth(c)
+			return
+		}
+
+		apiKey, email, role, err := aks.Authenticate(strings.TrimSpace(key))
+		if err != nil {
+			status := http.StatusUnauthorized
+			if !strings.Contains(err.Error(), "invalid api key") {
+				status = http.StatusInternalServerError
+			}
+			c.JSON(status, gin.H{
+				"message": "Authorization Error",
+				"error":   err.Error(),
+			})
+			c.Abort()
+			return
+		}
+
+		c.Set("Email", email)
+		c.Set("UserId", apiKey.UserId.String())
+		c.Set("Role", role)
+		c.Set("ApiKeyId", apiKey.KeyId)
+		c.Set("Permissions", aks.KeyPermissions(apiKey, role))
+
+		c.Next()
 	}
+}
 
-	email, emailOk := claims["Email"].(string)
-	userId, userIdOk := claims["UserId"].(string)
-
-	if !emailOk || !userIdOk {
-		c.JSON(http.StatusUnauthorized, gin.H{"message": "Missing or invalid token claims"})
-		c.Abort()
-		return
+// RequirePermission must run after RequireAuth or RequireAuthOrApiKey, which put the
+// caller's permissions on the context
+func RequirePermission(permission string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		permissions, _ := c.Get("Permissions")
+		granted, _ := permissions.([]string)
+		if !slices.Contains(granted, permission) {
+			c.JSON(http.StatusForbidden, gin.H{"message": "Missing permission: " + permission})
+			c.Abort()
+			return
+		}
+
+		c.Next()
 	}
-
-	c.Set("Email", email)
-	c.Set("UserId", userId)
-
-	c.Next()
 }
@@ -1,15 +1,22 @@
 package models
 
 import (
+	"encoding/json"
 	"errors"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 )
 
 var (
 	ErrUnauthorized = errors.New("unauthorized")
 	ErrNotFound     = errors.New("not found")
+
+	// Login errors are deliberately vague so they don't reveal which emails are registered
+	ErrInvalidCredentials = errors.New("invalid email or password")
+	ErrTooManyAttempts    = errors.New("too many failed login attempts, please try again later")
+	ErrTooManyRequests    = errors.New("too many requests, please try again later")
 )
 
 // === === === === ===
@@ -33,6 +40,7 @@ type User struct {
 type Credentials struct {
 	Email    string `json:"email"`
 	Password string `json:"password"`
+	Device   string `json:"device"`
 }
 
 type SanitizedUser struct {
@@ -43,6 +51,448 @@ type SanitizedUser struct {
 	Verified    bool      `json:"verified"`
 }
 
+type RefreshToken struct {
+	TokenId   string     `json:"tokenId" db:"tokenid"`
+	FamilyId  string     `json:"familyId" db:"familyid"`
+	UserId    uuid.UUID  `json:"userId" db:"userid"`
+	UsedAt    *time.Time `json:"usedAt" db:"usedat"`
+	Revoked   bool       `json:"revoked" db:"revoked"`
+	ExpiresAt time.Time  `json:"expiresAt" db:"expiresat"`
+	CreatedAt time.Time  `json:"createdAt" db:"createdat"`
+}
+
+type LoginResult struct {
+	User         *User
+	AccessToken  string
+	RefreshToken string
+	MFARequired  bool
+	MFAToken     string
+}
+
+type PasswordReset struct {
+	ResetId   string     `json:"resetId" db:"resetid"`
+	UserId    uuid.UUID  `json:"userId" db:"userid"`
+	TokenHash string     `json:"-" db:"token_hash"`
+	ExpiresAt time.Time  `json:"expiresAt" db:"expiresat"`
+	UsedAt    *time.Time `json:"usedAt" db:"usedat"`
+	CreatedAt time.Time  `json:"createdAt" db:"createdat"`
+}
+
+type EmailVerification struct {
+	VerificationId string     `json:"verificationId" db:"verificationid"`
+	UserId         uuid.UUID  `json:"userId" db:"userid"`
+	TokenHash      string     `json:"-" db:"token_hash"`
+	ExpiresAt      time.Time  `json:"expiresAt" db:"expiresat"`
+	UsedAt         *time.Time `json:"usedAt" db:"usedat"`
+	CreatedAt      time.Time  `json:"createdAt" db:"createdat"`
+}
+
+type ResendVerificationRequest struct {
+	Email string `json:"email"`
+}
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email"`
+}
+
+type ResetPasswordRequest struct {
+	ResetToken string `json:"resetToken"`
+	Password   string `json:"password"`
+}
+
+type EmailChange struct {
+	ChangeId         string     `json:"changeId" db:"changeid"`
+	UserId           uuid.UUID  `json:"userId" db:"userid"`
+	OldEmail         string     `json:"oldEmail" db:"old_email"`
+	NewEmail         string     `json:"newEmail" db:"new_email"`
+	ConfirmTokenHash string     `json:"-" db:"confirm_token_hash"`
+	CancelTokenHash  string     `json:"-" db:"cancel_token_hash"`
+	ExpiresAt        time.Time  `json:"expiresAt" db:"expiresat"`
+	ConfirmedAt      *time.Time `json:"confirmedAt" db:"confirmedat"`
+	CancelledAt      *time.Time `json:"cancelledAt" db:"cancelledat"`
+	CreatedAt        time.Time  `json:"createdAt" db:"createdat"`
+}
+
+// LoginAttempt counts failed logins for one key, either an account ("account:<email>")
+// or a client IP ("ip:<address>")
+type LoginAttempt struct {
+	AttemptKey      string     `db:"attemptkey"`
+	Failures        int        `db:"failures"`
+	LastFailureAt   time.Time  `db:"lastfailureat"`
+	LockedUntil     *time.Time `db:"lockeduntil"`
+	UnlockTokenHash *string    `db:"unlock_token_hash"`
+}
+
+// === === === === ===
+//
+//	=== Audit Log ===
+//
+// === === === === ===
+type AuditEvent struct {
+	EventId    int64           `json:"eventId" db:"eventid"`
+	Action     string          `json:"action" db:"action"`
+	ActorId    *string         `json:"actorId" db:"actorid"`
+	IPAddress  string          `json:"ipAddress" db:"ip_address"`
+	TargetType string          `json:"targetType" db:"target_type"`
+	TargetId   string          `json:"targetId" db:"target_id"`
+	Changes    json.RawMessage `json:"changes,omitempty" db:"changes"`
+	Metadata   json.RawMessage `json:"metadata,omitempty" db:"metadata"`
+	CreatedAt  time.Time       `json:"createdAt" db:"createdat"`
+}
+
+// AuditEntry is what a service reports to AuditService.Record. ActorId is empty when
+// nobody is logged in, e.g. a failed login or a link from an email.
+type AuditEntry struct {
+	Action     string
+	ActorId    string
+	IPAddress  string
+	TargetType string
+	TargetId   string
+	Changes    map[string]AuditChange
+	Metadata   map[string]any
+}
+
+type AuditChange struct {
+	From any `json:"from"`
+	To   any `json:"to"`
+}
+
+// === === === === ===
+//
+//	=== Emails ===
+//
+// === === === === ===
+
+// Email is a rendered message, ready to be handed to a Mailer. Template is the name and
+// version it was rendered from, e.g. "verification.v1". Emails outside the transactional
+// category carry the recipient's UserId, so their preferences can be checked, and a
+// one-click UnsubscribeURL.
+type Email struct {
+	To             string `json:"to"`
+	Subject        string `json:"subject"`
+	TextBody       string `json:"textBody"`
+	HTMLBody       string `json:"htmlBody"`
+	Template       string `json:"template"`
+	Category       string `json:"category"`
+	UserId         string `json:"userId,omitempty"`
+	UnsubscribeURL string `json:"unsubscribeUrl,omitempty"`
+}
+
+// === === === === ===
+//
+//	=== Outbox ===
+//
+// === === === === ===
+
+// OutboxMessage is a side effect waiting to be delivered. The payload is not serialized
+// since it can hold links with tokens.
+type OutboxMessage struct {
+	MessageId   int64           `json:"messageId" db:"messageid"`
+	Kind        string          `json:"kind" db:"kind"`
+	Payload     json.RawMessage `json:"-" db:"payload"`
+	Status      string          `json:"status" db:"status"`
+	Attempts    int             `json:"attempts" db:"attempts"`
+	AvailableAt time.Time       `json:"availableAt" db:"availableat"`
+	LastError   *string         `json:"lastError" db:"last_error"`
+	CreatedAt   time.Time       `json:"createdAt" db:"createdat"`
+	UpdatedAt   time.Time       `json:"updatedAt" db:"updatedat"`
+}
+
+// === === === === ===
+//
+//	=== Token Signing Keys ===
+//
+// === === === === ===
+type SigningKeyRecord struct {
+	Kid           string    `db:"kid"`
+	Algorithm     string    `db:"algorithm"`
+	PrivateKeyPEM string    `db:"private_key"`
+	ActivatesAt   time.Time `db:"activatesat"`
+	CreatedAt     time.Time `db:"createdat"`
+}
+
+// === === === === ===
+//
+//	=== OIDC ===
+//
+// === === === === ===
+type OIDCProviderConfig struct {
+	Name         string
+	DiscoveryURL string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+}
+
+type OIDCLoginState struct {
+	StateHash    string    `db:"state_hash"`
+	Provider     string    `db:"provider"`
+	CodeVerifier string    `db:"code_verifier"`
+	Nonce        string    `db:"nonce"`
+	ExpiresAt    time.Time `db:"expiresat"`
+	CreatedAt    time.Time `db:"createdat"`
+}
+
+// OIDCIdentity is what a verified ID token says about the user
+type OIDCIdentity struct {
+	Provider      string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+type UserIdentity struct {
+	IdentityId string    `json:"identityId" db:"identityid"`
+	UserId     uuid.UUID `json:"userId" db:"userid"`
+	Provider   string    `json:"provider" db:"provider"`
+	Subject    string    `json:"subject" db:"subject"`
+	Email      string    `json:"email" db:"email"`
+	CreatedAt  time.Time `json:"createdAt" db:"createdat"`
+}
+
+// === === === === ===
+//
+//	=== Account ===
+//
+// === === === === ===
+type AccountDeletion struct {
+	UserId       uuid.UUID  `json:"userId" db:"userid"`
+	RequestedAt  time.Time  `json:"requestedAt" db:"requestedat"`
+	ScheduledFor time.Time  `json:"scheduledFor" db:"scheduledfor"`
+	CancelledAt  *time.Time `json:"cancelledAt" db:"cancelledat"`
+	CompletedAt  *time.Time `json:"completedAt" db:"completedat"`
+}
+
+type DeleteAccountRequest struct {
+	Password string `json:"password"`
+}
+
+type ExportedWishlist struct {
+	Wishlist *Wishlist   `json:"wishlist"`
+	Items    []*ItemData `json:"items"`
+}
+
+// === === === === ===
+//
+//	=== Admin ===
+//
+// === === === === ===
+type AdminUser struct {
+	UserId      uuid.UUID `json:"userId" db:"userid"`
+	Name        string    `json:"name" db:"name"`
+	Email       string    `json:"email" db:"email"`
+	PhoneNumber string    `json:"phoneNumber" db:"phone_number"`
+	Verified    bool      `json:"verified" db:"verified"`
+	Role        string    `json:"role" db:"role"`
+	CreatedAt   time.Time `json:"createdAt" db:"createdat"`
+}
+
+type RoleUpdate struct {
+	UserId string `json:"userId"`
+	Role   string `json:"role"`
+}
+
+// === === === === ===
+//
+//	=== MFA ===
+//
+// === === === === ===
+type UserMFA struct {
+	UserId       uuid.UUID  `json:"userId" db:"userid"`
+	Secret       string     `json:"-" db:"secret"`
+	Enabled      bool       `json:"enabled" db:"enabled"`
+	LastUsedStep int64      `json:"-" db:"last_used_step"`
+	ConfirmedAt  *time.Time `json:"confirmedAt" db:"confirmedat"`
+	CreatedAt    time.Time  `json:"createdAt" db:"createdat"`
+}
+
+type MFAEnrollment struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioningUri"`
+}
+
+type MFACode struct {
+	Code string `json:"code"`
+}
+
+type MFALoginRequest struct {
+	MFAToken string `json:"mfaToken"`
+	Code     string `json:"code"`
+	Device   string `json:"device"`
+}
+
+// === === === === ===
+//
+//	=== Sessions ===
+//
+// === === === === ===
+type Session struct {
+	SessionId  string    `json:"sessionId" db:"sessionid"`
+	UserId     uuid.UUID `json:"userId" db:"userid"`
+	Device     string    `json:"device" db:"device"`
+	IPAddress  string    `json:"ipAddress" db:"ip_address"`
+	UserAgent  string    `json:"userAgent" db:"user_agent"`
+	Revoked    bool      `json:"-" db:"revoked"`
+	Current    bool      `json:"current" db:"-"`
+	LastSeenAt time.Time `json:"lastSeenAt" db:"lastseenat"`
+	CreatedAt  time.Time `json:"createdAt" db:"createdat"`
+}
+
+type SessionInfo struct {
+	Device    string
+	IPAddress string
+	UserAgent string
+}
+
+// === === === === ===
+//
+//	=== API Keys ===
+//
+// === === === === ===
+type ApiKey struct {
+	KeyId      string         `json:"keyId" db:"keyid"`
+	UserId     uuid.UUID      `json:"userId" db:"userid"`
+	Name       string         `json:"name" db:"name"`
+	Prefix     string         `json:"prefix" db:"prefix"`
+	KeyHash    string         `json:"-" db:"key_hash"`
+	Scopes     pq.StringArray `json:"scopes" db:"scopes"`
+	LastUsedAt *time.Time     `json:"lastUsedAt" db:"lastusedat"`
+	RevokedAt  *time.Time     `json:"revokedAt" db:"revokedat"`
+	CreatedAt  time.Time      `json:"createdAt" db:"createdat"`
+}
+
+type ApiKeyRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+}
+
+// === === === === ===
+//
+//	=== Notifications ===
+//
+// === === === === ===
+
+// Notification is an entry in a user's inbox. Audience separates what a vendor gets
+// about their store from what they get as a customer. Data holds the ids the
+// notification is about, e.g. the orderId.
+type Notification struct {
+	NotificationId int64           `json:"notificationId" db:"notificationid"`
+	UserId         string          `json:"userId" db:"userid"`
+	Audience       string          `json:"audience" db:"audience"`
+	Type           string          `json:"type" db:"type"`
+	Title          string          `json:"title" db:"title"`
+	Body           string          `json:"body" db:"body"`
+	Data           json.RawMessage `json:"data,omitempty" db:"data"`
+	ReadAt         *time.Time      `json:"readAt" db:"readat"`
+	CreatedAt      time.Time       `json:"createdAt" db:"createdat"`
+}
+
+// NotificationEntry is what a service hands to NotificationService.Notify. Category is
+// the preference category that decides whether the user wants it.
+type NotificationEntry struct {
+	UserId   string
+	Audience string
+	Type     string
+	Category string
+	Title    string
+	Body     string
+	Data     map[string]any
+}
+
+// NotificationPreferences maps channel → category → enabled, e.g.
+// {"email": {"marketing": false}}. Updates only need the entries that change.
+type NotificationPreferences map[string]map[string]bool
+
+// === === === === ===
+//
+//	=== Webhooks ===
+//
+// === === === === ===
+type WebhookEndpoint struct {
+	EndpointId string         `json:"endpointId" db:"endpointid"`
+	VendorId   string         `json:"vendorId" db:"vendorid"`
+	URL        string         `json:"url" db:"url"`
+	Secret     string         `json:"-" db:"secret"`
+	EventTypes pq.StringArray `json:"eventTypes" db:"event_types"`
+	IsActive   bool           `json:"isActive" db:"is_active"`
+	CreatedAt  time.Time      `json:"createdAt" db:"createdat"`
+	UpdatedAt  time.Time      `json:"updatedAt" db:"updatedat"`
+}
+
+// WebhookEndpointRequest creates or updates an endpoint. On update, nil fields are left
+// unchanged.
+type WebhookEndpointRequest struct {
+	URL        *string  `json:"url"`
+	EventTypes []string `json:"eventTypes"`
+	IsActive   *bool    `json:"isActive"`
+}
+
+// WebhookEvent is the JSON body POSTed to an endpoint. Data is the models struct the
+// event is about, e.g. an Order or a Review.
+type WebhookEvent struct {
+	EventId   string    `json:"eventId"`
+	Type      string    `json:"type"`
+	CreatedAt time.Time `json:"createdAt"`
+	Data      any       `json:"data"`
+}
+
+type WebhookDelivery struct {
+	DeliveryId   int64     `json:"deliveryId" db:"deliveryid"`
+	EndpointId   string    `json:"endpointId" db:"endpointid"`
+	EventId      string    `json:"eventId" db:"eventid"`
+	EventType    string    `json:"eventType" db:"event_type"`
+	Attempt      int       `json:"attempt" db:"attempt"`
+	StatusCode   *int      `json:"statusCode" db:"status_code"`
+	ResponseBody string    `json:"responseBody" db:"response_body"`
+	Error        string    `json:"error" db:"error"`
+	DurationMs   int64     `json:"durationMs" db:"duration_ms"`
+	Succeeded    bool      `json:"succeeded" db:"succeeded"`
+	CreatedAt    time.Time `json:"createdAt" db:"createdat"`
+}
+
+// OrderStatusChange is the data of order.status_changed. Order only lists the
+// receiving vendor's items.
+type OrderStatusChange struct {
+	Order          *Order `json:"order"`
+	PreviousStatus string `json:"previousStatus"`
+	Status         string `json:"status"`
+}
+
+// === === === === ===
+//
+//	=== Vendor Applications ===
+//
+// === === === === ===
+type VendorApplication struct {
+	ApplicationId   string     `json:"applicationId" db:"applicationid"`
+	UserId          uuid.UUID  `json:"userId" db:"userid"`
+	StoreName       string     `json:"storeName" db:"store_name"`
+	TaxId           string     `json:"taxId" db:"tax_id"`
+	ContactEmail    string     `json:"contactEmail" db:"contact_email"`
+	ContactPhone    string     `json:"contactPhone" db:"contact_phone"`
+	Status          string     `json:"status" db:"status"`
+	ReviewerId      *string    `json:"reviewerId" db:"reviewerid"`
+	ReviewedAt      *time.Time `json:"reviewedAt" db:"reviewedat"`
+	RejectionReason string     `json:"rejectionReason" db:"rejection_reason"`
+	CreatedAt       time.Time  `json:"createdAt" db:"createdat"`
+	UpdatedAt       time.Time  `json:"updatedAt" db:"updatedat"`
+}
+
+type VendorApplicationEvent struct {
+	EventId       string    `json:"eventId" db:"eventid"`
+	ApplicationId string    `json:"applicationId" db:"applicationid"`
+	Status        string    `json:"status" db:"status"`
+	ActorId       string    `json:"actorId" db:"actorid"`
+	Note          string    `json:"note" db:"note"`
+	CreatedAt     time.Time `json:"createdAt" db:"createdat"`
+}
+
+type ApplicationDecision struct {
+	Status string `json:"-"`
+	Reason string `json:"reason"`
+}
+
 // === === === === ===
 //
 //	=== Billing ===
@@ -87,6 +537,8 @@ type ShippingAddress struct {
 //
 // === === === === ===
 
+// Product.Category is the path of the product's category, e.g. "Electronics > Phones".
+// It is derived from CategoryId and kept up to date when categories are renamed or moved.
 type Product struct {
 	ProductId   string    `json:"ProductId" db:"productid"`
 	VendorId    string    `json:"vendorId" db:"vendorid"`
@@ -97,12 +549,173 @@ type Product struct {
 	Discount    *float64  `json:"discount" db:"discount"`
 	Stock       *int      `json:"stock" db:"stock"`
 	Brand       string    `json:"brand" db:"brand"`
+	CategoryId  *string   `json:"categoryId" db:"categoryid"`
 	Category    string    `json:"category" db:"category"`
 	IsActive    *bool     `json:"isActive" db:"is_active"`
 	CreatedAt   time.Time `json:"createdAt" db:"createdat"`
 	UpdatedAt   time.Time `json:"updatedAt" db:"updatedat"`
 }
 
+// ProductSearchHit is a product in a listing. The search fields are only set when a
+// keyword was searched: highlights are HTML-escaped with matched words in <mark> tags.
+type ProductSearchHit struct {
+	Product
+	MatchType            string   `json:"matchType,omitempty" db:"-"`
+	Rank                 *float64 `json:"rank,omitempty" db:"rank"`
+	NameHighlight        *string  `json:"nameHighlight,omitempty" db:"name_highlight"`
+	DescriptionHighlight *string  `json:"descriptionHighlight,omitempty" db:"description_highlight"`
+}
+
+// ProductSearchResult is one page of a listing. Total counts every hit, not just the
+// page; Facets is only set when asked for.
+type ProductSearchResult struct {
+	Products []*ProductSearchHit `json:"products"`
+	Total    int                 `json:"total"`
+	Facets   *ProductFacets      `json:"facets,omitempty"`
+}
+
+// ProductFacets counts the hits per value a shopper could filter by next. Each facet
+// ignores its own filter, so choosing a brand still shows the counts of the other brands.
+type ProductFacets struct {
+	Brands     []*FacetCount       `json:"brands"`
+	Categories []*FacetCount       `json:"categories"`
+	Prices     []*PriceBucketCount `json:"prices"`
+	OnDiscount int                 `json:"onDiscount"`
+	InStock    int                 `json:"inStock"`
+}
+
+// FacetCount is one value of a facet. Categories are counted by slug, which the category
+// filter takes, and also carry their name.
+type FacetCount struct {
+	Value string `json:"value" db:"value"`
+	Name  string `json:"name,omitempty" db:"name"`
+	Count int    `json:"count" db:"count"`
+}
+
+// PriceBucketCount is a price band from Min up to, but not including, Max. The highest
+// band has no Max.
+type PriceBucketCount struct {
+	Min   float64  `json:"min"`
+	Max   *float64 `json:"max"`
+	Count int      `json:"count"`
+}
+
+// CatalogProduct is the public product page: the product with who sells it, how it
+// was rated and the variants it can be bought in. AverageRating is nil until the product
+// has a review.
+type CatalogProduct struct {
+	Product
+	VendorName    string            `json:"vendorName" db:"vendorname"`
+	AverageRating *float64          `json:"averageRating" db:"averagerating"`
+	ReviewCount   int               `json:"reviewCount" db:"reviewcount"`
+	Options       []*ProductOption  `json:"options" db:"-"`
+	Variants      []*ProductVariant `json:"variants" db:"-"`
+}
+
+type VendorStorefront struct {
+	VendorId      string    `json:"vendorId" db:"vendorid"`
+	Name          string    `json:"name" db:"name"`
+	ProductCount  int       `json:"productCount" db:"productcount"`
+	AverageRating *float64  `json:"averageRating" db:"averagerating"`
+	ReviewCount   int       `json:"reviewCount" db:"reviewcount"`
+	JoinedAt      time.Time `json:"joinedAt" db:"joinedat"`
+}
+
+// === === === === ===
+//
+//	=== Categories ===
+//
+// === === === === ===
+
+type Category struct {
+	CategoryId string    `json:"categoryId" db:"categoryid"`
+	ParentId   *string   `json:"parentId" db:"parentid"`
+	Name       string    `json:"name" db:"name"`
+	Slug       string    `json:"slug" db:"slug"`
+	CreatedAt  time.Time `json:"createdAt" db:"createdat"`
+	UpdatedAt  time.Time `json:"updatedAt" db:"updatedat"`
+}
+
+// CategoryRequest creates or changes a category. When updating, a parentId of "" moves
+// the category to the top level.
+type CategoryRequest struct {
+	ParentId *string `json:"parentId"`
+	Name     *string `json:"name"`
+	Slug     *string `json:"slug"`
+}
+
+// CategoryNode is a category in the catalog tree. ProductCount includes the active
+// products of all its subcategories.
+type CategoryNode struct {
+	Category
+	ProductCount int             `json:"productCount" db:"productcount"`
+	Children     []*CategoryNode `json:"children"`
+}
+
+// === === === === ===
+//
+//	=== Product Variants ===
+//
+// === === === === ===
+
+// ProductOption is something a product comes in several of, like Size, with its values
+// in the order shoppers see them.
+type ProductOption struct {
+	OptionId  string         `json:"optionId" db:"optionid"`
+	ProductId string         `json:"productId" db:"productid"`
+	Name      string         `json:"name" db:"name"`
+	Position  int            `json:"position" db:"position"`
+	Values    pq.StringArray `json:"values" db:"option_values"`
+}
+
+// ProductVariant is one combination of option values, e.g. {"Size": "M", "Color": "Red"}.
+// Price overrides the product's price when set, the product's discount applies to every
+// variant. A product without options has a single variant with {} as its options.
+type ProductVariant struct {
+	VariantId string          `json:"variantId" db:"variantid"`
+	ProductId string          `json:"productId" db:"productid"`
+	SKU       string          `json:"sku" db:"sku"`
+	Options   json.RawMessage `json:"options" db:"options"`
+	Price     *float64        `json:"price" db:"price"`
+	Stock     int             `json:"stock" db:"stock"`
+	IsActive  bool            `json:"isActive" db:"is_active"`
+	CreatedAt time.Time       `json:"createdAt" db:"createdat"`
+	UpdatedAt time.Time       `json:"updatedAt" db:"updatedat"`
+}
+
+// ProductVariants is a product's option matrix and the variants it expands to
+type ProductVariants struct {
+	Options  []*ProductOption  `json:"options"`
+	Variants []*ProductVariant `json:"variants"`
+}
+
+// ProductOptionsRequest replaces a product's options. An empty list turns the product
+// back into one without options.
+type ProductOptionsRequest struct {
+	Options []*ProductOptionInput `json:"options"`
+}
+
+type ProductOptionInput struct {
+	Name   string   `json:"name"`
+	Values []string `json:"values"`
+}
+
+// VariantsUpdateRequest changes several variants of a product at once
+type VariantsUpdateRequest struct {
+	Variants []*VariantUpdate `json:"variants"`
+}
+
+// VariantUpdate changes one variant. Only fields present change; clearPrice removes the
+// price override so the variant sells at the product's price again.
+type VariantUpdate struct {
+	VariantId  string   `json:"variantId"`
+	SKU        *string  `json:"sku"`
+	Price      *float64 `json:"price"`
+	ClearPrice bool     `json:"clearPrice"`
+	Stock      *int     `json:"stock"`
+	IsActive   *bool    `json:"isActive"`
+}
+
 // === === === === ===
 //
 //	=== Carts ===
@@ -119,20 +732,25 @@ type CartItem struct {
 	CartItemId string    `json:"cartItemId" db:"cartitemid"`
 	CartId     string    `json:"cartId" db:"cartid"`
 	ProductId  string    `json:"ProductId" db:"productid"`
+	VariantId  string    `json:"variantId" db:"variantid"`
 	Quantity   int       `json:"quantity" db:"quantity"`
 	AddedAt    time.Time `json:"addedAt" db:"addedat"`
 }
 
+// ItemData is a cart or wishlist item. SKU and Price are the variant's, Price being the
+// product's price when the variant doesn't override it.
 type ItemData struct {
-	ProductId   string   `json:"ProductId" db:"productid"`
-	Name        string   `json:"name" db:"name"`
-	Description string   `json:"description" db:"description"`
-	SKU         string   `json:"sku" db:"sku"`
-	Price       *float64 `json:"price" db:"price"`
-	Discount    *float64 `json:"discount" db:"discount"`
-	Brand       string   `json:"brand" db:"brand"`
-	Category    string   `json:"category" db:"category"`
-	Quantity    int      `json:"quantity" db:"quantity"`
+	ProductId   string          `json:"ProductId" db:"productid"`
+	VariantId   string          `json:"variantId" db:"variantid"`
+	Name        string          `json:"name" db:"name"`
+	Description string          `json:"description" db:"description"`
+	SKU         string          `json:"sku" db:"sku"`
+	Options     json.RawMessage `json:"options" db:"options"`
+	Price       *float64        `json:"price" db:"price"`
+	Discount    *float64        `json:"discount" db:"discount"`
+	Brand       string          `json:"brand" db:"brand"`
+	Category    string          `json:"category" db:"category"`
+	Quantity    int             `json:"quantity" db:"quantity"`
 }
 
 type ReturnedCart struct {
@@ -161,6 +779,9 @@ type Summary struct {
 
 type OrderItem struct {
 	ProductId   string
+	VariantId   string
+	SKU         string
+	Options     json.RawMessage
 	Name        string
 	Description string
 	Brand       string
@@ -175,6 +796,23 @@ type Order struct {
 	TotalPrice float64
 }
 
+type OrderStatusUpdate struct {
+	OrderId string `json:"orderId"`
+	Status  string `json:"status"`
+}
+
+// OrderStatusEvent is one entry of an order's status history and the payload of the
+// order stream. EventId increases monotonically and is the SSE event id clients resume
+// from. PreviousStatus is nil when the order was placed.
+type OrderStatusEvent struct {
+	EventId        int64     `json:"eventId" db:"eventid"`
+	OrderId        string    `json:"orderId" db:"orderid"`
+	UserId         string    `json:"-" db:"userid"`
+	PreviousStatus *string   `json:"previousStatus" db:"previous_status"`
+	Status         string    `json:"status" db:"status"`
+	CreatedAt      time.Time `json:"createdAt" db:"createdat"`
+}
+
 // === === === === ===
 //
 //	=== Reviews ===
@@ -2,8 +2,12 @@ package routes
 
 import (
 	controllers "eCommerce/controller"
+	"eCommerce/helpers"
 	"eCommerce/middlewares"
 	"eCommerce/services"
+	"log"
+	"os"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/jmoiron/sqlx"
@@ -11,44 +15,144 @@ import (
 
 func SetupRouter(router *gin.Engine, db *sqlx.DB) {
 	// Services
-	userService := services.NewUserService(db)
+	signingAlg, err := helpers.LoadSigningAlgorithm()
+	if err != nil {
+		log.Fatalf("Error loading signing algorithm: %v", err)
+	}
+	mailer, err := helpers.LoadMailer()
+	if err != nil {
+		log.Fatalf("Error loading mailer: %v", err)
+	}
+	signingKeyService := services.NewSigningKeyService(db, signingAlg)
+	tokenService := services.NewTokenService(db, signingKeyService)
+	sessionService := services.NewSessionService(db)
+	auditService := services.NewAuditService(db)
+	outboxService := services.NewOutboxService(db, mailer)
+	notificationService := services.NewNotificationService(db)
+	orderStreamService := services.NewOrderStreamService(db, os.Getenv("POSTGRES_DSN"))
+	webhookService := services.NewWebhookService(db, *outboxService, helpers.WebhooksAllowInsecure())
+	mfaService := services.NewMFAService(db, *auditService)
+	loginThrottleService := services.NewLoginThrottleService(services.NewPostgresLoginAttemptStore(db))
+	userService := services.NewUserService(db, *tokenService, *sessionService, *mfaService, *loginThrottleService, *auditService, *outboxService)
 	billingService := services.NewBillingService(db)
 	shippingService := services.NewShippingService(db)
-	vendorService := services.NewVendorService(db)
+	vendorService := services.NewVendorService(db, *auditService, *notificationService)
+	variantService := services.NewVariantService(db, *vendorService)
 	productService := services.NewProductService(db)
+	categoryService := services.NewCategoryService(db, *auditService)
 	cartService := services.NewCartService(db)
-	checkoutService := services.NewCheckoutService(db, *cartService, *shippingService)
-	orderService := services.NewOrderService(db)
-	reviewService := services.NewReviewService(db)
+	checkoutService := services.NewCheckoutService(db, *cartService, *shippingService, *outboxService, *webhookService, *notificationService)
+	orderService := services.NewOrderService(db, *webhookService, *notificationService, *outboxService)
+	reviewService := services.NewReviewService(db, *webhookService, *notificationService)
 	wishlistService := services.NewWishlistService(db)
+	adminService := services.NewAdminService(db, *sessionService, *auditService)
+	apiKeyService := services.NewApiKeyService(db, *auditService)
+	vendorApplicationService := services.NewVendorApplicationService(db, *auditService)
+	accountService := services.NewAccountService(db, *userService, *billingService, *shippingService, *orderService, *reviewService, *wishlistService, *sessionService)
+
+	oidcProviders, err := helpers.LoadOIDCProviders()
+	if err != nil {
+		log.Fatalf("Error loading OIDC providers: %v", err)
+	}
+	oidcService := services.NewOIDCService(db, oidcProviders)
+
+	// Anonymizes accounts whose deletion grace period has ended
+	go accountService.RunDeletionWorker(time.Hour)
+	// Publishes the next access token signing key ahead of time and retires old ones
+	go signingKeyService.RunRotationWorker(time.Hour)
+	// Delivers queued emails, webhooks and other side effects after their transaction committed
+	go outboxService.RunDispatcher(5 * time.Second)
+	// Wakes this instance's order streams when any instance changes an order status
+	go orderStreamService.RunListener()
 
 	// Controllers
 	userController := controllers.NewUserController(userService)
+	sessionController := controllers.NewSessionController(sessionService)
+	mfaController := controllers.NewMFAController(mfaService)
 	billingController := controllers.NewBillingController(billingService)
 	shippingController := controllers.NewShippingController(shippingService)
 	vendorController := controllers.NewVendorController(vendorService)
+	variantController := controllers.NewVariantController(variantService)
 	productController := controllers.NewProductController(productService)
+	categoryController := controllers.NewCategoryController(categoryService)
 	cartController := controllers.NewCartController(cartService)
 	checkoutController := controllers.NewCheckoutController(checkoutService)
-	orderController := controllers.NewOrderController(orderService)
+	orderController := controllers.NewOrderController(orderService, orderStreamService)
 	reviewController := controllers.NewReviewContoller(reviewService)
 	wishlistController := controllers.NewWishlistController(wishlistService)
+	adminController := controllers.NewAdminController(adminService)
+	vendorApplicationController := controllers.NewVendorApplicationController(vendorApplicationService)
+	accountController := controllers.NewAccountController(accountService)
+	apiKeyController := controllers.NewApiKeyController(apiKeyService)
+	oidcController := controllers.NewOIDCController(oidcService, userService)
+	signingKeyController := controllers.NewSigningKeyController(signingKeyService)
+	auditController := controllers.NewAuditController(auditService)
+	outboxController := controllers.NewOutboxController(outboxService)
+	webhookController := controllers.NewWebhookController(webhookService)
+	notificationController := controllers.NewNotificationController(notificationService, services.NotificationAudienceCustomer)
+	vendorNotificationController := controllers.NewNotificationController(notificationService, services.NotificationAudienceVendor)
+
+	// Public keys for services that verify our access tokens
+	router.GET("/.well-known/jwks.json", signingKeyController.GetJWKS)
 
 	// Authentication Routes
 	accountRoutes := router.Group("/account")
 	{
 		accountRoutes.POST("/login", userController.Login)
+		accountRoutes.POST("/login/mfa", userController.LoginMFA)
+		accountRoutes.GET("/oidc/login", oidcController.StartLogin)
+		accountRoutes.GET("/oidc/callback", oidcController.Callback)
 		accountRoutes.POST("/signup", userController.Signup)
 		accountRoutes.POST("/verify", userController.VerifyUser)
+		accountRoutes.POST("/verify/resend", userController.ResendVerification)
+		accountRoutes.POST("/unlock", userController.UnlockAccount)
+		accountRoutes.POST("/email/confirm", userController.ConfirmEmailChange)
+		accountRoutes.POST("/email/cancel", userController.CancelEmailChange)
+		accountRoutes.POST("/refresh", userController.Refresh)
+		accountRoutes.POST("/forgot-password", userController.ForgotPassword)
+		accountRoutes.POST("/reset-password", userController.ResetPassword)
+		accountRoutes.POST("/unsubscribe", notificationController.Unsubscribe)
+	}
+
+	// Public Catalog Routes, which only ever show active products
+	catalog := router.Group("/catalog")
+	{
+		catalog.GET("/products", productController.GetCatalogProducts)
+		catalog.GET("/products/:id", productController.GetCatalogProduct)
+		catalog.GET("/vendors/:id", productController.GetStorefront)
+		catalog.GET("/categories", categoryController.GetTree)
 	}
 
 	// Protected Routes
 	protected := router.Group("/protected")
-	protected.Use(middlewares.RequireAuth)
+	protected.Use(middlewares.RequireAuth(sessionService, signingKeyService))
 	{
 		// user routes
 		protected.GET("/profile", userController.GetUserProfile)
 		protected.PATCH("/profile", userController.UpdateUser)
+		protected.GET("/profile/notifications", notificationController.GetPreferences)
+		protected.PATCH("/profile/notifications", notificationController.UpdatePreferences)
+
+		// account data routes
+		protected.GET("/account/export", accountController.ExportData)
+		protected.GET("/account/delete", accountController.GetDeletion)
+		protected.POST("/account/delete", accountController.RequestDeletion)
+		protected.POST("/account/delete/cancel", accountController.CancelDeletion)
+
+		// session routes
+		protected.POST("/logout", sessionController.Logout)
+		protected.GET("/sessions", sessionController.GetSessions)
+		protected.DELETE("/sessions", sessionController.RevokeSession)
+		protected.DELETE("/sessions/all", sessionController.RevokeAllSessions)
+
+		// vendor application routes
+		protected.GET("/vendor-application", vendorApplicationController.GetApplication)
+		protected.POST("/vendor-application", vendorApplicationController.SubmitApplication)
+
+		// mfa routes
+		protected.POST("/mfa/enroll", mfaController.Enroll)
+		protected.POST("/mfa/confirm", mfaController.ConfirmEnrollment)
+		protected.POST("/mfa/disable", mfaController.Disable)
 
 		// Billing routes
 		protected.GET("/billing", billingController.GetBillingAddresses)
@@ -80,15 +184,16 @@ func SetupRouter(router *gin.Engine, db *sqlx.DB) {
 
 		// checkout routes
 		protected.GET("/summary", checkoutController.OrderSummary)
-		protected.POST("/confirm", checkoutController.ConfrimPurchase)
+		protected.POST("/confirm", middlewares.RequirePermission(helpers.PermOrdersWrite), checkoutController.ConfrimPurchase)
 
 		// order routes
 		protected.GET("/orders/status", orderController.TrackOrder)
+		protected.GET("/orders/stream", orderController.StreamOrders)
 		protected.GET("/orders", orderController.ViewOrders)
 
 		// review routes
 		protected.GET("/reviews", reviewController.GetReviews)
-		protected.POST("/reviews", reviewController.SubmitReview)
+		protected.POST("/reviews", middlewares.RequirePermission(helpers.PermReviewsWrite), reviewController.SubmitReview)
 		protected.PATCH("/reviews", reviewController.EditReview)
 		protected.DELETE("/reviews", reviewController.DeleteReview)
 
@@ -102,20 +207,88 @@ func SetupRouter(router *gin.Engine, db *sqlx.DB) {
 		protected.DELETE("/wishlists", wishlistController.DeleteWishlist)
 		protected.DELETE("/wishlist/item", wishlistController.DeleteWishlistItem)
 
+		// notification routes
+		protected.GET("/notifications", notificationController.GetNotifications)
+		protected.GET("/notifications/unread-count", notificationController.GetUnreadCount)
+		protected.POST("/notifications/read", notificationController.MarkRead)
+		protected.POST("/notifications/read-all", notificationController.MarkAllRead)
+
 	}
 
 	// Vendor Routes
 	vendor := router.Group("/vendor")
-	vendor.Use((middlewares.VendorAuth))
+	vendor.Use(middlewares.RequireAuthOrApiKey(sessionService, signingKeyService, apiKeyService), middlewares.RequirePermission(helpers.PermVendorAccess))
 	{
 		// review routes
-		vendor.GET("/reviews", reviewController.GetVendorReviews)
-		vendor.GET("/products", vendorController.GetVendorProducts)
+		vendor.GET("/reviews", middlewares.RequirePermission(helpers.PermVendorReviewsRead), reviewController.GetVendorReviews)
+
+		// notification routes
+		notifications := vendor.Group("/notifications", middlewares.RequirePermission(helpers.PermVendorNotificationsRead))
+		notifications.GET("", vendorNotificationController.GetNotifications)
+		notifications.GET("/unread-count", vendorNotificationController.GetUnreadCount)
+		notifications.POST("/read", vendorNotificationController.MarkRead)
+		notifications.POST("/read-all", vendorNotificationController.MarkAllRead)
 
 		// vendor routes
-		vendor.GET("/products/id", vendorController.GetProductById)
-		vendor.POST("/products", vendorController.AddProduct)
-		vendor.POST("/products/id", vendorController.DeleteProduct)
-		vendor.PATCH("/products", vendorController.UpdateProduct)
+		products := vendor.Group("/products", middlewares.RequirePermission(helpers.PermProductsWrite))
+		products.GET("", vendorController.GetVendorProducts)
+		products.GET("/id", vendorController.GetProductById)
+		products.POST("", vendorController.AddProduct)
+		products.POST("/id", vendorController.DeleteProduct)
+		products.PATCH("", vendorController.UpdateProduct)
+		products.GET("/history", auditController.GetProductHistory)
+		products.GET("/variants", variantController.GetVariants)
+		products.PUT("/options", variantController.ReplaceOptions)
+		products.PATCH("/variants", variantController.UpdateVariants)
+
+		// webhook routes
+		webhooks := vendor.Group("/webhooks", middlewares.RequirePermission(helpers.PermWebhooksWrite))
+		webhooks.GET("", webhookController.GetEndpoints)
+		webhooks.POST("", webhookController.CreateEndpoint)
+		webhooks.PATCH("", webhookController.UpdateEndpoint)
+		webhooks.DELETE("", webhookController.DeleteEndpoint)
+		webhooks.POST("/test", webhookController.SendTestEvent)
+		webhooks.GET("/deliveries", webhookController.GetDeliveries)
+	}
+
+	// API key management needs a logged-in session, a key can't create or revoke keys
+	apiKeys := router.Group("/vendor/api-keys")
+	apiKeys.Use(middlewares.RequireAuth(sessionService, signingKeyService), middlewares.RequirePermission(helpers.PermApiKeysWrite))
+	{
+		apiKeys.GET("", apiKeyController.GetKeys)
+		apiKeys.POST("", apiKeyController.CreateKey)
+		apiKeys.DELETE("", apiKeyController.RevokeKey)
+	}
+
+	// Admin Routes
+	admin := router.Group("/admin")
+	admin.Use(middlewares.RequireAuth(sessionService, signingKeyService), middlewares.RequirePermission(helpers.PermAdminAccess))
+	{
+		// user management routes
+		admin.GET("/users", middlewares.RequirePermission(helpers.PermUsersRead), adminController.GetUsers)
+		admin.PATCH("/users/role", middlewares.RequirePermission(helpers.PermRolesWrite), adminController.UpdateUserRole)
+
+		// order routes
+		admin.PATCH("/orders/status", middlewares.RequirePermission(helpers.PermOrdersWriteAny), orderController.UpdateOrderStatus)
+
+		// vendor application routes
+		admin.GET("/vendor-applications", middlewares.RequirePermission(helpers.PermUsersRead), vendorApplicationController.GetApplications)
+		admin.GET("/vendor-applications/events", middlewares.RequirePermission(helpers.PermUsersRead), vendorApplicationController.GetApplicationEvents)
+		admin.POST("/vendor-applications/approve", middlewares.RequirePermission(helpers.PermRolesWrite), vendorApplicationController.ApproveApplication)
+		admin.POST("/vendor-applications/reject", middlewares.RequirePermission(helpers.PermRolesWrite), vendorApplicationController.RejectApplication)
+
+		// audit log routes
+		admin.GET("/audit-events", middlewares.RequirePermission(helpers.PermAuditRead), auditController.GetEvents)
+
+		// category routes
+		categories := admin.Group("/categories", middlewares.RequirePermission(helpers.PermCategoriesWrite))
+		categories.GET("", categoryController.GetCategories)
+		categories.POST("", categoryController.CreateCategory)
+		categories.PATCH("", categoryController.UpdateCategory)
+		categories.DELETE("", categoryController.DeleteCategory)
+
+		// outbox routes
+		admin.GET("/outbox", middlewares.RequirePermission(helpers.PermOutboxManage), outboxController.GetMessages)
+		admin.POST("/outbox/retry", middlewares.RequirePermission(helpers.PermOutboxManage), outboxController.RetryMessage)
 	}
 }
@@ -0,0 +1,350 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"database/sql"
+	"eCommerce/helpers"
+	"eCommerce/models"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	// accountDeletionGracePeriod is how long a deletion request can still be cancelled
+	accountDeletionGracePeriod = 14 * 24 * time.Hour
+	// recentLoginWindow is how long after logging in a user without a password can
+	// confirm a deletion with that login instead
+	recentLoginWindow = 10 * time.Minute
+)
+
+type AccountService struct {
+	DB   *sqlx.DB
+	us   UserService
+	bs   BillingService
+	shs  ShippingService
+	os   OrderService
+	rs   ReviewService
+	ws   WishlistService
+	sess SessionService
+}
+
+func NewAccountService(db *sqlx.DB, us UserService, bs BillingService, shs ShippingService, os OrderService, rs ReviewService, ws WishlistService, sess SessionService) *AccountService {
+	return &AccountService{
+		DB:   db,
+		us:   us,
+		bs:   bs,
+		shs:  shs,
+		os:   os,
+		rs:   rs,
+		ws:   ws,
+		sess: sess,
+	}
+}
+
+// ExportData returns a ZIP archive with one JSON file per kind of personal data
+func (as *AccountService) ExportData(userId, sessionId string) ([]byte, error) {
+	user, err := as.us.GetUserProfile(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	billingAddresses, err := as.bs.GetBillingAddresses(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	shippingAddresses, err := as.shs.GetShippingAddresses(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	orders, err := as.os.ViewOrders(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	reviews, err := as.rs.GetReviews(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	wishlists, err := as.ws.GetWishlists(userId)
+	if err != nil {
+		return nil, err
+	}
+	exportedWishlists := make([]*models.ExportedWishlist, 0, len(wishlists))
+	for _, wishlist := range wishlists {
+		returned, err := as.ws.GetWishlistItems(userId, wishlist.WishlistId)
+		if err != nil {
+			return nil, err
+		}
+		exportedWishlists = append(exportedWishlists, &models.ExportedWishlist{Wishlist: wishlist, Items: returned.Items})
+	}
+
+	sessions, err := as.sess.GetSessions(userId, sessionId)
+	if err != nil {
+		return nil, err
+	}
+
+	// the password hash and verification token are left out
+	profile := map[string]any{
+		"userId":      user.UserId,
+		"name":        user.Name,
+		"email":       user.Email,
+		"phoneNumber": user.PhoneNumber,
+		"verified":    user.Verified,
+		"role":        user.Role,
+		"createdAt":   user.CreatedAt,
+		"updatedAt":   user.UpdatedAt,
+	}
+
+	files := []struct {
+		name string
+		data any
+	}{
+		{"profile.json", profile},
+		{"billing_addresses.json", billingAddresses},
+		{"shipping_addresses.json", shippingAddresses},
+		{"orders.json", orders},
+		{"reviews.json", reviews},
+		{"wishlists.json", exportedWishlists},
+		{"sessions.json", sessions},
+	}
+
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	for _, file := range files {
+		content, err := json.MarshalIndent(file.data, "", "  ")
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode %s: %w", file.name, err)
+		}
+
+		w, err := archive.Create(file.name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create %s: %w", file.name, err)
+		}
+		if _, err := w.Write(content); err != nil {
+			return nil, fmt.Errorf("failed to write %s: %w", file.name, err)
+		}
+	}
+	if err := archive.Close(); err != nil {
+		return nil, fmt.Errorf("failed to build export archive: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// RequestDeletion schedules the account for deletion after the grace period. The password
+// is required again so a stolen access token can't delete the account. Accounts created
+// through a login provider have no password; they need a session that was just started
+// instead.
+func (as *AccountService) RequestDeletion(userId, sessionId, password string) (*models.AccountDeletion, error) {
+	var hashedPassword string
+	err := as.DB.Get(&hashedPassword, `SELECT password FROM users WHERE userid = $1`, userId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch user: %w", err)
+	}
+	if hashedPassword == "" {
+		if err := as.requireRecentLogin(userId, sessionId); err != nil {
+			return nil, err
+		}
+	} else if !helpers.CheckPasswords(password, hashedPassword) {
+		return nil, fmt.Errorf("incorrect password")
+	}
+
+	var deletion models.AccountDeletion
+	upsertQuery := `
+	INSERT INTO account_deletions (userid, requestedat, scheduledfor)
+	VALUES ($1, CURRENT_TIMESTAMP, $2)
+	ON CONFLICT (userid) DO UPDATE
+	SET requestedat = CURRENT_TIMESTAMP, scheduledfor = $2, cancelledat = NULL
+	WHERE account_deletions.cancelledat IS NOT NULL AND account_deletions.completedat IS NULL
+	RETURNING *
+	`
+	err = as.DB.Get(&deletion, upsertQuery, userId, time.Now().Add(accountDeletionGracePeriod))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			// the conflicting row is still pending
+			return nil, fmt.Errorf("account deletion is already scheduled")
+		}
+		return nil, fmt.Errorf("failed to schedule account deletion: %w", err)
+	}
+
+	return &deletion, nil
+}
+
+// requireRecentLogin checks that the session was started within recentLoginWindow, which
+// proves the user just logged in, e.g. with their login provider
+func (as *AccountService) requireRecentLogin(userId, sessionId string) error {
+	var createdAt time.Time
+	query := `SELECT createdat FROM sessions WHERE sessionid = $1 AND userid = $2 AND revoked = FALSE`
+	err := as.DB.Get(&createdAt, query, sessionId, userId)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("failed to fetch session: %w", err)
+	}
+	if err != nil || time.Since(createdAt) > recentLoginWindow {
+		return fmt.Errorf("this account has no password: log in again with your login provider and delete it within %d minutes, or set a password with forgot password", int(recentLoginWindow.Minutes()))
+	}
+	return nil
+}
+
+func (as *AccountService) GetDeletion(userId string) (*models.AccountDeletion, error) {
+	var deletion models.AccountDeletion
+	query := `SELECT * FROM account_deletions WHERE userid = $1 AND cancelledat IS NULL AND completedat IS NULL`
+	err := as.DB.Get(&deletion, query, userId)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, models.ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to fetch account deletion: %w", err)
+	}
+
+	return &deletion, nil
+}
+
+func (as *AccountService) CancelDeletion(userId string) error {
+	res, err := as.DB.Exec(`
+		UPDATE account_deletions SET cancelledat = CURRENT_TIMESTAMP
+		WHERE userid = $1 AND cancelledat IS NULL AND completedat IS NULL
+	`, userId)
+	if err != nil {
+		return fmt.Errorf("failed to cancel account deletion: %w", err)
+	}
+
+	affectedRows, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to check updated rows: %w", err)
+	}
+	if affectedRows == 0 {
+		return models.ErrNotFound
+	}
+
+	return nil
+}
+
+// PurgeDueDeletions anonymizes every account whose grace period is over
+func (as *AccountService) PurgeDueDeletions() error {
+	var userIds []string
+	query := `
+	SELECT userid FROM account_deletions
+	WHERE scheduledfor <= CURRENT_TIMESTAMP AND cancelledat IS NULL AND completedat IS NULL
+	`
+	err := as.DB.Select(&userIds, query)
+	if err != nil {
+		return fmt.Errorf("failed to fetch due account deletions: %w", err)
+	}
+
+	for _, userId := range userIds {
+		if err := as.anonymizeUser(userId); err != nil {
+			log.Printf("error deleting account %s: %v", userId, err)
+		}
+	}
+
+	return nil
+}
+
+// RunDeletionWorker calls PurgeDueDeletions every interval. It never returns.
+func (as *AccountService) RunDeletionWorker(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := as.PurgeDueDeletions(); err != nil {
+			log.Println("account deletion worker:", err)
+		}
+		<-ticker.C
+	}
+}
+
+// anonymizeUser keeps the users row so orders and reviews stay valid for vendors, but
+// scrubs everything that identifies the person and removes data only they need.
+func (as *AccountService) anonymizeUser(userId string) (err error) {
+	tx, err := as.DB.Beginx()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		} else if err != nil {
+			tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
+
+	// An empty password hash never matches, so the account can't be logged into
+	_, err = tx.Exec(`
+		UPDATE users
+		SET name = 'Deleted User',
+			email = replace(userid::text, '-', '') || '@deleted.invalid',
+			password = '',
+			phone_number = '',
+			verified = FALSE,
+			verification_token = '',
+			updatedat = CURRENT_TIMESTAMP
+		WHERE userid = $1
+	`, userId)
+	if err != nil {
+		return fmt.Errorf("failed to anonymize user: %w", err)
+	}
+
+	deletes := []string{
+		`DELETE FROM billing_addresses WHERE userid = $1`,
+		`DELETE FROM shipping_addresses WHERE userid = $1`,
+		`DELETE FROM carts WHERE userid = $1`,
+		`DELETE FROM wishlists WHERE userid = $1`,
+		`DELETE FROM user_mfa WHERE userid = $1`,
+		`DELETE FROM mfa_recovery_codes WHERE userid = $1`,
+		`DELETE FROM password_resets WHERE userid = $1`,
+		`DELETE FROM email_verifications WHERE userid = $1`,
+		`DELETE FROM email_changes WHERE userid = $1`,
+		`DELETE FROM user_identities WHERE userid = $1`,
+		`UPDATE api_keys SET revokedat = CURRENT_TIMESTAMP WHERE userid = $1 AND revokedat IS NULL`,
+		`DELETE FROM webhook_endpoints WHERE vendorid = $1`,
+		`DELETE FROM notifications WHERE userid = $1`,
+		`DELETE FROM notification_preferences WHERE userid = $1`,
+	}
+	for _, query := range deletes {
+		_, err = tx.Exec(query, userId)
+		if err != nil {
+			return fmt.Errorf("failed to delete user data: %w", err)
+		}
+	}
+
+	_, err = tx.Exec(`UPDATE vendor_applications SET tax_id = '', contact_email = '', contact_phone = '' WHERE userid = $1`, userId)
+	if err != nil {
+		return fmt.Errorf("failed to scrub vendor applications: %w", err)
+	}
+
+	// A deleted vendor's products stay for order history but can't be bought
+	_, err = tx.Exec(`UPDATE products SET is_active = FALSE, updatedat = CURRENT_TIMESTAMP WHERE vendorid = $1`, userId)
+	if err != nil {
+		return fmt.Errorf("failed to deactivate products: %w", err)
+	}
+
+	err = as.sess.RevokeOtherSessions(tx, userId, "")
+	if err != nil {
+		return fmt.Errorf("failed to revoke sessions: %w", err)
+	}
+
+	_, err = tx.Exec(`UPDATE sessions SET ip_address = '', user_agent = '' WHERE userid = $1`, userId)
+	if err != nil {
+		return fmt.Errorf("failed to scrub sessions: %w", err)
+	}
+
+	_, err = tx.Exec(`UPDATE account_deletions SET completedat = CURRENT_TIMESTAMP WHERE userid = $1`, userId)
+	if err != nil {
+		return fmt.Errorf("failed to complete account deletion: %w", err)
+	}
+
+	return nil
+}
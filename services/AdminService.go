@@ -0,0 +1,134 @@
+package services
+
+import (
+	"database/sql"
+	"eCommerce/helpers"
+	"eCommerce/models"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type AdminService struct {
+	DB  *sqlx.DB
+	ss  SessionService
+	aus AuditService
+}
+
+func NewAdminService(db *sqlx.DB, ss SessionService, aus AuditService) *AdminService {
+	return &AdminService{
+		db,
+		ss,
+		aus,
+	}
+}
+
+func (as *AdminService) GetUsers(filters map[string]string) ([]*models.AdminUser, error) {
+	var users []*models.AdminUser
+	baseQuery := `SELECT userid, name, email, phone_number, verified, role, createdat FROM users`
+	var args []any
+	var conditions []string
+	argsIndex := 1
+
+	for key, value := range filters {
+		switch key {
+		case "role":
+			conditions = append(conditions, fmt.Sprintf("role = $%d", argsIndex))
+			args = append(args, value)
+			argsIndex++
+		case "email":
+			conditions = append(conditions, fmt.Sprintf("email ILIKE $%d", argsIndex))
+			args = append(args, "%"+value+"%")
+			argsIndex++
+		}
+	}
+	if len(conditions) > 0 {
+		baseQuery += " WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	baseQuery += " ORDER BY createdat DESC"
+
+	// pagination
+	if limitStr := filters["limit"]; limitStr != "" {
+		if limit, err := strconv.Atoi(limitStr); err == nil && limit > 0 {
+			baseQuery += fmt.Sprintf(" LIMIT %d", limit)
+		}
+	}
+	if offsetStr := filters["offset"]; offsetStr != "" {
+		if offset, err := strconv.Atoi(offsetStr); err == nil && offset > 0 {
+			baseQuery += fmt.Sprintf(" OFFSET %d", offset)
+		}
+	}
+
+	err := as.DB.Select(&users, baseQuery, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching users: %w", err)
+	}
+
+	return users, nil
+}
+
+// UpdateUserRole changes a user's role and revokes their sessions, since the old role
+// is baked into tokens that would otherwise stay valid until they expire.
+func (as *AdminService) UpdateUserRole(adminId, userId, role, ip string) (err error) {
+	if !helpers.IsValidRole(role) {
+		return fmt.Errorf("invalid role: %s", role)
+	}
+
+	if adminId == userId {
+		return fmt.Errorf("you can't change your own role")
+	}
+
+	tx, err := as.DB.Beginx()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		} else if err != nil {
+			tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
+
+	var oldRole string
+	err = tx.Get(&oldRole, `SELECT role FROM users WHERE userid = $1 FOR UPDATE`, userId)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			err = models.ErrNotFound
+			return err
+		}
+		return fmt.Errorf("error fetching user: %w", err)
+	}
+
+	_, err = tx.Exec(`UPDATE users SET role = $1, updatedat = CURRENT_TIMESTAMP WHERE userid = $2`, role, userId)
+	if err != nil {
+		return fmt.Errorf("error updating role: %w", err)
+	}
+
+	err = as.ss.RevokeOtherSessions(tx, userId, "")
+	if err != nil {
+		return err
+	}
+
+	err = as.aus.Record(tx, &models.AuditEntry{
+		Action:     AuditRoleChanged,
+		ActorId:    adminId,
+		IPAddress:  ip,
+		TargetType: auditTargetUser,
+		TargetId:   userId,
+		Changes:    map[string]models.AuditChange{"role": {From: oldRole, To: role}},
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
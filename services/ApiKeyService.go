@@ -0,0 +1,246 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"database/sql"
+	"eCommerce/helpers"
+	"eCommerce/models"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+const (
+	// keys look like ek_<8 hex chars>_<64 hex chars>. The prefix is stored in plain text
+	// so a key can be found and recognised without storing the key itself.
+	apiKeyPrefix  = "ek_"
+	maxApiKeys    = 20
+	apiKeyNameMax = 100
+)
+
+type ApiKeyService struct {
+	DB  *sqlx.DB
+	aus AuditService
+}
+
+func NewApiKeyService(db *sqlx.DB, aus AuditService) *ApiKeyService {
+	return &ApiKeyService{
+		db,
+		aus,
+	}
+}
+
+func generateApiKey() (string, string, error) {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	prefix := apiKeyPrefix + hex.EncodeToString(b)
+
+	secret, err := helpers.GenerateSecureToken()
+	if err != nil {
+		return "", "", err
+	}
+
+	return prefix, prefix + "_" + secret, nil
+}
+
+// CreateKey returns the stored key and the plain key, which is not kept and can't be
+// shown again
+func (aks *ApiKeyService) CreateKey(userId, role, ip string, request *models.ApiKeyRequest) (_ *models.ApiKey, _ string, err error) {
+	name := strings.TrimSpace(request.Name)
+	if name == "" || len(name) > apiKeyNameMax {
+		return nil, "", fmt.Errorf("name is required and must be at most %d characters", apiKeyNameMax)
+	}
+
+	if len(request.Scopes) == 0 {
+		return nil, "", fmt.Errorf("at least one scope is required")
+	}
+	for _, scope := range request.Scopes {
+		if !slices.Contains(helpers.ApiKeyScopes, scope) {
+			return nil, "", fmt.Errorf("invalid scope: %s", scope)
+		}
+		if !helpers.HasPermission(role, scope) {
+			return nil, "", fmt.Errorf("you don't have the %s permission", scope)
+		}
+	}
+
+	var count int
+	err = aks.DB.Get(&count, `SELECT COUNT(*) FROM api_keys WHERE userid = $1 AND revokedat IS NULL`, userId)
+	if err != nil {
+		return nil, "", fmt.Errorf("error counting api keys: %w", err)
+	}
+	if count >= maxApiKeys {
+		return nil, "", fmt.Errorf("you can have at most %d active api keys", maxApiKeys)
+	}
+
+	prefix, key, err := generateApiKey()
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to generate api key: %w", err)
+	}
+
+	tx, err := aks.DB.Beginx()
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		} else if err != nil {
+			tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
+
+	var apiKey models.ApiKey
+	insertQuery := `
+	INSERT INTO api_keys (userid, name, prefix, key_hash, scopes)
+	VALUES ($1, $2, $3, $4, $5)
+	RETURNING *
+	`
+	err = tx.Get(&apiKey, insertQuery, userId, name, prefix, helpers.HashToken(key), pq.StringArray(request.Scopes))
+	if err != nil {
+		return nil, "", fmt.Errorf("error creating api key: %w", err)
+	}
+
+	err = aks.aus.Record(tx, &models.AuditEntry{
+		Action:     AuditApiKeyCreated,
+		ActorId:    userId,
+		IPAddress:  ip,
+		TargetType: auditTargetApiKey,
+		TargetId:   apiKey.KeyId,
+		Metadata:   map[string]any{"name": apiKey.Name, "prefix": apiKey.Prefix, "scopes": apiKey.Scopes},
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	return &apiKey, key, nil
+}
+
+func (aks *ApiKeyService) GetKeys(userId string) ([]*models.ApiKey, error) {
+	var keys []*models.ApiKey
+	err := aks.DB.Select(&keys, `SELECT * FROM api_keys WHERE userid = $1 ORDER BY createdat DESC`, userId)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching api keys: %w", err)
+	}
+
+	return keys, nil
+}
+
+func (aks *ApiKeyService) RevokeKey(userId, keyId, ip string) (err error) {
+	tx, err := aks.DB.Beginx()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		} else if err != nil {
+			tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
+
+	res, err := tx.Exec(`
+		UPDATE api_keys SET revokedat = CURRENT_TIMESTAMP
+		WHERE keyid = $1 AND userid = $2 AND revokedat IS NULL
+	`, keyId, userId)
+	if err != nil {
+		return fmt.Errorf("error revoking api key: %w", err)
+	}
+
+	affectedRows, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error checking updated rows: %w", err)
+	}
+	if affectedRows == 0 {
+		err = models.ErrNotFound
+		return err
+	}
+
+	return aks.aus.Record(tx, &models.AuditEntry{
+		Action:     AuditApiKeyRevoked,
+		ActorId:    userId,
+		IPAddress:  ip,
+		TargetType: auditTargetApiKey,
+		TargetId:   keyId,
+	})
+}
+
+// Authenticate is called by the auth middleware for "Authorization: ApiKey ..." requests.
+// It returns the key with its owner's email and current role, so a vendor who loses the
+// role also loses their keys.
+func (aks *ApiKeyService) Authenticate(key string) (*models.ApiKey, string, string, error) {
+	invalid := fmt.Errorf("invalid api key")
+
+	if !strings.HasPrefix(key, apiKeyPrefix) {
+		return nil, "", "", invalid
+	}
+	prefix, _, found := strings.Cut(strings.TrimPrefix(key, apiKeyPrefix), "_")
+	if !found {
+		return nil, "", "", invalid
+	}
+	prefix = apiKeyPrefix + prefix
+
+	var apiKey models.ApiKey
+	err := aks.DB.Get(&apiKey, `SELECT * FROM api_keys WHERE prefix = $1 AND revokedat IS NULL`, prefix)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, "", "", invalid
+		}
+		return nil, "", "", fmt.Errorf("error fetching api key: %w", err)
+	}
+
+	if subtle.ConstantTimeCompare([]byte(helpers.HashToken(key)), []byte(apiKey.KeyHash)) != 1 {
+		return nil, "", "", invalid
+	}
+
+	var owner struct {
+		Email string `db:"email"`
+		Role  string `db:"role"`
+	}
+	err = aks.DB.Get(&owner, `SELECT email, role FROM users WHERE userid = $1`, apiKey.UserId)
+	if err != nil {
+		return nil, "", "", fmt.Errorf("error fetching api key owner: %w", err)
+	}
+
+	// Like sessions, only write when the stored value is more than a minute old
+	if apiKey.LastUsedAt == nil || time.Since(*apiKey.LastUsedAt) > lastSeenInterval {
+		_, err = aks.DB.Exec(`UPDATE api_keys SET lastusedat = CURRENT_TIMESTAMP WHERE keyid = $1`, apiKey.KeyId)
+		if err != nil {
+			return nil, "", "", fmt.Errorf("error updating api key usage: %w", err)
+		}
+	}
+
+	return &apiKey, owner.Email, owner.Role, nil
+}
+
+// KeyPermissions is what a request made with the key may do: vendor access plus the
+// key's scopes, limited to what the owner's role still grants
+func (aks *ApiKeyService) KeyPermissions(apiKey *models.ApiKey, role string) []string {
+	if !helpers.HasPermission(role, helpers.PermVendorAccess) {
+		return nil
+	}
+
+	permissions := []string{helpers.PermVendorAccess}
+	for _, scope := range apiKey.Scopes {
+		if helpers.HasPermission(role, scope) {
+			permissions = append(permissions, scope)
+		}
+	}
+	return permissions
+}
@@ -0,0 +1,196 @@
+package services
+
+import (
+	"eCommerce/models"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Audit actions. The prefix is the kind of target.
+const (
+	AuditLogin                  = "auth.login"
+	AuditLoginFailed            = "auth.login_failed"
+	AuditAccountLocked          = "auth.account_locked"
+	AuditUserUpdated            = "user.updated"
+	AuditPasswordChanged        = "user.password_changed"
+	AuditPasswordReset          = "user.password_reset"
+	AuditEmailChangeRequested   = "user.email_change_requested"
+	AuditEmailChanged           = "user.email_changed"
+	AuditEmailChangeCancelled   = "user.email_change_cancelled"
+	AuditRoleChanged            = "user.role_changed"
+	AuditMFAEnabled             = "user.mfa_enabled"
+	AuditMFADisabled            = "user.mfa_disabled"
+	AuditApiKeyCreated          = "api_key.created"
+	AuditApiKeyRevoked          = "api_key.revoked"
+	AuditProductCreated         = "product.created"
+	AuditProductUpdated         = "product.updated"
+	AuditProductDeleted         = "product.deleted"
+	AuditProductVariantsUpdated = "product.variants_updated"
+	AuditCategoryCreated        = "category.created"
+	AuditCategoryUpdated        = "category.updated"
+	AuditCategoryDeleted        = "category.deleted"
+)
+
+const (
+	auditTargetUser     = "user"
+	auditTargetApiKey   = "api_key"
+	auditTargetProduct  = "product"
+	auditTargetCategory = "category"
+
+	defaultPageSize = 50
+	maxPageSize     = 200
+)
+
+// AuditService writes to audit_events, which is append-only: rows are never updated or
+// deleted. Services record events with the same db handle as the change itself, so an
+// event exists exactly when the change was committed.
+type AuditService struct {
+	DB *sqlx.DB
+}
+
+func NewAuditService(db *sqlx.DB) *AuditService {
+	return &AuditService{
+		db,
+	}
+}
+
+func (aus *AuditService) Record(db sqlx.Ext, entry *models.AuditEntry) error {
+	var actorId *string
+	if entry.ActorId != "" {
+		actorId = &entry.ActorId
+	}
+
+	// nil is stored as NULL rather than a JSON null
+	var changes, metadata *string
+	if len(entry.Changes) > 0 {
+		data, err := json.Marshal(entry.Changes)
+		if err != nil {
+			return fmt.Errorf("failed to encode audit changes: %w", err)
+		}
+		encoded := string(data)
+		changes = &encoded
+	}
+	if len(entry.Metadata) > 0 {
+		data, err := json.Marshal(entry.Metadata)
+		if err != nil {
+			return fmt.Errorf("failed to encode audit metadata: %w", err)
+		}
+		encoded := string(data)
+		metadata = &encoded
+	}
+
+	insertQuery := `
+	INSERT INTO audit_events (action, actorid, ip_address, target_type, target_id, changes, metadata)
+	VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`
+	_, err := db.Exec(insertQuery, entry.Action, actorId, entry.IPAddress, entry.TargetType, entry.TargetId, changes, metadata)
+	if err != nil {
+		return fmt.Errorf("failed to record audit event: %w", err)
+	}
+
+	return nil
+}
+
+// pageFilters reads limit and offset from list filters. limit defaults to 50 and is at
+// most 200.
+func pageFilters(filters map[string]string) (int, int) {
+	limit := defaultPageSize
+	if value, err := strconv.Atoi(filters["limit"]); err == nil && value > 0 {
+		limit = min(value, maxPageSize)
+	}
+	offset := 0
+	if value, err := strconv.Atoi(filters["offset"]); err == nil && value > 0 {
+		offset = value
+	}
+	return limit, offset
+}
+
+// GetEvents returns events newest first. Filters: action (exact, or a prefix ending in
+// ".", e.g. "product."), actorId, targetType, targetId, ipAddress, from and to (RFC 3339),
+// limit (default 50, at most 200) and offset.
+func (aus *AuditService) GetEvents(filters map[string]string) ([]*models.AuditEvent, error) {
+	baseQuery := `SELECT * FROM audit_events`
+	var args []any
+	var conditions []string
+	argsIndex := 1
+
+	for key, value := range filters {
+		switch key {
+		case "action":
+			if strings.HasSuffix(value, ".") {
+				conditions = append(conditions, fmt.Sprintf("action LIKE $%d", argsIndex))
+				args = append(args, value+"%")
+			} else {
+				conditions = append(conditions, fmt.Sprintf("action = $%d", argsIndex))
+				args = append(args, value)
+			}
+			argsIndex++
+		case "actorId":
+			conditions = append(conditions, fmt.Sprintf("actorid = $%d", argsIndex))
+			args = append(args, value)
+			argsIndex++
+		case "targetType":
+			conditions = append(conditions, fmt.Sprintf("target_type = $%d", argsIndex))
+			args = append(args, value)
+			argsIndex++
+		case "targetId":
+			conditions = append(conditions, fmt.Sprintf("target_id = $%d", argsIndex))
+			args = append(args, value)
+			argsIndex++
+		case "ipAddress":
+			conditions = append(conditions, fmt.Sprintf("ip_address = $%d", argsIndex))
+			args = append(args, value)
+			argsIndex++
+		case "from", "to":
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s time, use RFC 3339", key)
+			}
+			operator := ">="
+			if key == "to" {
+				operator = "<"
+			}
+			conditions = append(conditions, fmt.Sprintf("createdat %s $%d", operator, argsIndex))
+			args = append(args, t)
+			argsIndex++
+		}
+	}
+	if len(conditions) > 0 {
+		baseQuery += " WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	limit, offset := pageFilters(filters)
+	baseQuery += fmt.Sprintf(" ORDER BY createdat DESC, eventid DESC LIMIT %d OFFSET %d", limit, offset)
+
+	events := []*models.AuditEvent{}
+	err := aus.DB.Select(&events, baseQuery, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching audit events: %w", err)
+	}
+
+	return events, nil
+}
+
+// GetProductHistory returns the changes to a vendor's products, including deleted ones.
+// productId is optional.
+func (aus *AuditService) GetProductHistory(vendorId, productId string, filters map[string]string) ([]*models.AuditEvent, error) {
+	query := `
+	SELECT * FROM audit_events
+	WHERE target_type = $1 AND metadata->>'vendorId' = $2 AND ($3 = '' OR target_id = $3)
+	`
+	limit, offset := pageFilters(filters)
+	query += fmt.Sprintf(" ORDER BY createdat DESC, eventid DESC LIMIT %d OFFSET %d", limit, offset)
+
+	events := []*models.AuditEvent{}
+	err := aus.DB.Select(&events, query, auditTargetProduct, vendorId, productId)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching product history: %w", err)
+	}
+
+	return events, nil
+}
@@ -34,23 +34,23 @@ func (cs *CartService) checkOwnership(cartId, userId string) error {
 	return nil
 }
 
-func (cs *CartService) getAvailableStock(productId string) (int, error) {
+func (cs *CartService) getAvailableStock(variantId string) (int, error) {
 	var availableStock int
-	err := cs.DB.Get(&availableStock, `SELECT stock FROM products WHERE productid = $1`, productId)
+	err := cs.DB.Get(&availableStock, `SELECT stock FROM product_variants WHERE variantid = $1`, variantId)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return 0, models.ErrNotFound
 		}
-		return 0, fmt.Errorf("error fetching stock for product: %w", err)
+		return 0, fmt.Errorf("error fetching stock for variant: %w", err)
 	}
 	return availableStock, nil
 }
 
-func (cs *CartService) getExistingQty(cartId, productId string) (int, error) {
+func (cs *CartService) getExistingQty(cartId, variantId string) (int, error) {
 	var existingQty int
 	err := cs.DB.Get(&existingQty,
-		`SELECT quantity FROM cart_items WHERE cartid = $1 AND productid = $2`,
-		cartId, productId,
+		`SELECT quantity FROM cart_items WHERE cartid = $1 AND variantid = $2`,
+		cartId, variantId,
 	)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
@@ -65,10 +65,11 @@ func (cs *CartService) getTotal(db sqlx.Ext, cartId, userId string) (float64, er
 	var total float64
 	err := sqlx.Get(db, &total, `
 		SELECT COALESCE(SUM(
-			(p.price - COALESCE(p.discount, 0)) * ci.quantity
+			(COALESCE(v.price, p.price) - COALESCE(p.discount, 0)) * ci.quantity
 		), 0) as total
 		FROM cart_items ci
 		JOIN products p ON p.productid = ci.productid
+		JOIN product_variants v ON v.variantid = ci.variantid
 		JOIN carts c ON c.cartid = ci.cartid
 		WHERE ci.cartid = $1 AND c.userid = $2
 	`, cartId, userId)
@@ -95,16 +96,19 @@ func (cs *CartService) ViewCart(cartId, userId string) (*models.ReturnedCart, er
 	fetchQuery := `
 	SELECT
     p.productid,
+    v.variantid,
     p.name,
     p.description,
-    p.sku,
-    p.price,
+    v.sku,
+    v.options,
+    COALESCE(v.price, p.price) AS price,
     p.discount,
     p.brand,
     p.category,
     ci.quantity
 		FROM products p
 		JOIN cart_items ci ON p.productid = ci.productid
+		JOIN product_variants v ON v.variantid = ci.variantid
 		WHERE ci.cartid = $1`
 	err := cs.DB.Select(&cartItems, fetchQuery, cartId)
 	if err != nil {
@@ -152,12 +156,18 @@ func (cs *CartService) AddToCart(cartId, userId string, cartItem *models.CartIte
 		}
 	}()
 
-	availableStock, err := cs.getAvailableStock(cartItem.ProductId)
+	// Products without options are added without picking their variant
+	variant, err := resolveVariant(tx, cartItem.ProductId, cartItem.VariantId)
 	if err != nil {
 		return nil, err
 	}
 
-	existingQty, err := cs.getExistingQty(cartId, cartItem.ProductId)
+	availableStock, err := cs.getAvailableStock(variant.VariantId)
+	if err != nil {
+		return nil, err
+	}
+
+	existingQty, err := cs.getExistingQty(cartId, variant.VariantId)
 	if err != nil {
 		return nil, err
 	}
@@ -175,11 +185,11 @@ func (cs *CartService) AddToCart(cartId, userId string, cartItem *models.CartIte
 	// }
 
 	_, err = tx.Exec(`
-		INSERT INTO cart_items (cartid, productid, quantity)
-		VALUES ($1, $2, $3)
-		ON CONFLICT (cartid, productid)
+		INSERT INTO cart_items (cartid, productid, variantid, quantity)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (cartid, variantid)
 		DO UPDATE SET quantity = cart_items.quantity + EXCLUDED.quantity
-	`, cartId, cartItem.ProductId, cartItem.Quantity)
+	`, cartId, variant.ProductId, variant.VariantId, cartItem.Quantity)
 	if err != nil {
 		return nil, fmt.Errorf("error adding new item: %w", err)
 	}
@@ -188,16 +198,19 @@ func (cs *CartService) AddToCart(cartId, userId string, cartItem *models.CartIte
 	err = tx.Select(&cartItems, `
 		SELECT
 			p.productid,
+			v.variantid,
 			p.name,
 			p.description,
-			p.sku,
-			p.price,
+			v.sku,
+			v.options,
+			COALESCE(v.price, p.price) AS price,
 			p.discount,
 			p.brand,
 			p.category,
 			ci.quantity
 		FROM products p
 		JOIN cart_items ci ON p.productid = ci.productid
+		JOIN product_variants v ON v.variantid = ci.variantid
 		WHERE ci.cartid = $1
 	`, cartId)
 	if err != nil {
@@ -224,7 +237,17 @@ func (cs *CartService) EditCartItem(cartItem *models.CartItem, userId string) (*
 		return nil, err
 	}
 
-	availableStock, err := cs.getAvailableStock(cartItem.ProductId)
+	// The stock checked is that of the variant already in the cart
+	var variantId string
+	err := cs.DB.Get(&variantId, `SELECT variantid FROM cart_items WHERE cartid = $1 AND cartitemid = $2`, cartItem.CartId, cartItem.CartItemId)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("item does not exist in cart")
+		}
+		return nil, fmt.Errorf("error fetching item: %w", err)
+	}
+
+	availableStock, err := cs.getAvailableStock(variantId)
 	if err != nil {
 		return nil, err
 	}
@@ -237,10 +260,10 @@ func (cs *CartService) EditCartItem(cartItem *models.CartItem, userId string) (*
 	updateQuery := `
 		UPDATE cart_items
 		SET quantity = $1
-		WHERE productid = $2 AND cartid = $3 AND cartitemid = $4
+		WHERE cartid = $2 AND cartitemid = $3
 		RETURNING *
 	`
-	err = cs.DB.Get(&newItem, updateQuery, cartItem.Quantity, cartItem.ProductId, cartItem.CartId, cartItem.CartItemId)
+	err = cs.DB.Get(&newItem, updateQuery, cartItem.Quantity, cartItem.CartId, cartItem.CartItemId)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, fmt.Errorf("item does not exist in cart")
@@ -0,0 +1,487 @@
+package services
+
+import (
+	"database/sql"
+	"eCommerce/helpers"
+	"eCommerce/models"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+// categoryPathSeparator joins category names into the path stored in products.category
+const categoryPathSeparator = " > "
+
+// CategoryService manages the category tree. Products keep the path of their category
+// in products.category, so listings, carts and orders can show it without walking the
+// tree, and full-text search finds a phone by "electronics".
+type CategoryService struct {
+	DB  *sqlx.DB
+	aus AuditService
+}
+
+func NewCategoryService(db *sqlx.DB, aus AuditService) *CategoryService {
+	return &CategoryService{
+		db,
+		aus,
+	}
+}
+
+// lockCategories serializes changes to the tree with assigning categories to products.
+// Product writes take the lock shared, so they never wait for each other, and always
+// store a path that a concurrent rename will refresh.
+func lockCategories(db sqlx.Ext, exclusive bool) error {
+	query := `SELECT pg_advisory_xact_lock_shared(hashtext('categories'))`
+	if exclusive {
+		query = `SELECT pg_advisory_xact_lock(hashtext('categories'))`
+	}
+	_, err := db.Exec(query)
+	if err != nil {
+		return fmt.Errorf("failed to lock categories: %w", err)
+	}
+	return nil
+}
+
+// categorySubtree returns a query selecting the category whose id or slug is the
+// parameter, and all categories below it
+func categorySubtree(param string) string {
+	return fmt.Sprintf(`
+	WITH RECURSIVE subtree AS (
+		SELECT categoryid FROM categories WHERE categoryid::text = %s OR slug = %s
+		UNION ALL
+		SELECT c.categoryid FROM categories c JOIN subtree s ON c.parentid = s.categoryid
+	)
+	SELECT categoryid FROM subtree
+	`, param, param)
+}
+
+// categoryPath returns the path of a category, e.g. "Electronics > Phones"
+func categoryPath(db sqlx.Ext, categoryId string) (string, error) {
+	if _, err := uuid.Parse(categoryId); err != nil {
+		return "", fmt.Errorf("category not found")
+	}
+
+	query := `
+	WITH RECURSIVE ancestors AS (
+		SELECT categoryid, parentid, name, 0 AS depth FROM categories WHERE categoryid = $1
+		UNION ALL
+		SELECT c.categoryid, c.parentid, c.name, a.depth + 1
+		FROM categories c JOIN ancestors a ON c.categoryid = a.parentid
+	)
+	SELECT COALESCE(string_agg(name, $2 ORDER BY depth DESC), '') FROM ancestors
+	`
+	var path string
+	err := sqlx.Get(db, &path, query, categoryId, categoryPathSeparator)
+	if err != nil {
+		return "", fmt.Errorf("error fetching category path: %w", err)
+	}
+	if path == "" {
+		return "", fmt.Errorf("category not found")
+	}
+	return path, nil
+}
+
+// refreshCategoryPaths rewrites the category path of every product whose category was
+// renamed or moved, or sits below one that was
+func refreshCategoryPaths(db sqlx.Ext) error {
+	query := `
+	WITH RECURSIVE paths AS (
+		SELECT categoryid, name AS path FROM categories WHERE parentid IS NULL
+		UNION ALL
+		SELECT c.categoryid, p.path || $1 || c.name
+		FROM categories c JOIN paths p ON c.parentid = p.categoryid
+	)
+	UPDATE products pr
+	SET category = paths.path
+	FROM paths
+	WHERE pr.categoryid = paths.categoryid AND pr.category IS DISTINCT FROM paths.path
+	`
+	_, err := db.Exec(query, categoryPathSeparator)
+	if err != nil {
+		return fmt.Errorf("failed to update product categories: %w", err)
+	}
+	return nil
+}
+
+func (cs *CategoryService) auditCategory(db sqlx.Ext, action, categoryId, adminId, ip string, changes map[string]models.AuditChange, metadata map[string]any) error {
+	return cs.aus.Record(db, &models.AuditEntry{
+		Action:     action,
+		ActorId:    adminId,
+		IPAddress:  ip,
+		TargetType: auditTargetCategory,
+		TargetId:   categoryId,
+		Changes:    changes,
+		Metadata:   metadata,
+	})
+}
+
+func lockCategory(tx *sqlx.Tx, categoryId string) (*models.Category, error) {
+	if _, err := uuid.Parse(categoryId); err != nil {
+		return nil, models.ErrNotFound
+	}
+
+	var category models.Category
+	err := tx.Get(&category, `SELECT * FROM categories WHERE categoryid = $1 FOR UPDATE`, categoryId)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, models.ErrNotFound
+		}
+		return nil, fmt.Errorf("error fetching category: %w", err)
+	}
+	return &category, nil
+}
+
+func checkSlugAvailable(db sqlx.Ext, slug, categoryId string) error {
+	var taken bool
+	query := `SELECT EXISTS (SELECT 1 FROM categories WHERE slug = $1 AND categoryid::text <> $2)`
+	err := sqlx.Get(db, &taken, query, slug, categoryId)
+	if err != nil {
+		return fmt.Errorf("error checking slug: %w", err)
+	}
+	if taken {
+		return fmt.Errorf("slug %q is already in use", slug)
+	}
+	return nil
+}
+
+func categoryExists(db sqlx.Ext, categoryId string) (bool, error) {
+	if _, err := uuid.Parse(categoryId); err != nil {
+		return false, nil
+	}
+
+	var exists bool
+	err := sqlx.Get(db, &exists, `SELECT EXISTS (SELECT 1 FROM categories WHERE categoryid = $1)`, categoryId)
+	if err != nil {
+		return false, fmt.Errorf("error fetching category: %w", err)
+	}
+	return exists, nil
+}
+
+// checkParent makes sure the parent exists and, when moving a category, isn't the
+// category itself or one of its subcategories
+func checkParent(db sqlx.Ext, parentId, categoryId string) error {
+	exists, err := categoryExists(db, parentId)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("parent category not found")
+	}
+
+	if categoryId == "" {
+		return nil
+	}
+	var cycle bool
+	query := `SELECT $1::uuid IN (` + categorySubtree("$2") + `)`
+	err = sqlx.Get(db, &cycle, query, parentId, categoryId)
+	if err != nil {
+		return fmt.Errorf("error checking parent category: %w", err)
+	}
+	if cycle {
+		return fmt.Errorf("a category can't be moved below itself")
+	}
+	return nil
+}
+
+// GetCategories lists all categories, sorted by name
+func (cs *CategoryService) GetCategories() ([]*models.Category, error) {
+	categories := []*models.Category{}
+	err := cs.DB.Select(&categories, `SELECT * FROM categories ORDER BY name, categoryid`)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching categories: %w", err)
+	}
+	return categories, nil
+}
+
+// GetTree returns the top-level categories with their subcategories nested below them,
+// each sorted by name and counting their active products
+func (cs *CategoryService) GetTree() ([]*models.CategoryNode, error) {
+	query := `
+	SELECT c.*, COUNT(p.productid) AS productcount
+	FROM categories c
+	LEFT JOIN products p ON p.categoryid = c.categoryid AND p.is_active = TRUE
+	GROUP BY c.categoryid
+	ORDER BY c.name, c.categoryid
+	`
+	var nodes []*models.CategoryNode
+	err := cs.DB.Select(&nodes, query)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching categories: %w", err)
+	}
+
+	byId := make(map[string]*models.CategoryNode, len(nodes))
+	for _, node := range nodes {
+		node.Children = []*models.CategoryNode{}
+		byId[node.CategoryId] = node
+	}
+
+	roots := []*models.CategoryNode{}
+	for _, node := range nodes {
+		if node.ParentId == nil {
+			roots = append(roots, node)
+			continue
+		}
+		if parent, ok := byId[*node.ParentId]; ok {
+			parent.Children = append(parent.Children, node)
+		}
+	}
+
+	var addSubtreeCounts func(node *models.CategoryNode) int
+	addSubtreeCounts = func(node *models.CategoryNode) int {
+		for _, child := range node.Children {
+			node.ProductCount += addSubtreeCounts(child)
+		}
+		return node.ProductCount
+	}
+	for _, root := range roots {
+		addSubtreeCounts(root)
+	}
+
+	return roots, nil
+}
+
+// CreateCategory adds a category. The slug defaults to the slugified name.
+func (cs *CategoryService) CreateCategory(adminId, ip string, request *models.CategoryRequest) (_ *models.Category, err error) {
+	if request.Name == nil || strings.TrimSpace(*request.Name) == "" {
+		return nil, fmt.Errorf("name is required")
+	}
+	name := strings.TrimSpace(*request.Name)
+
+	slug := helpers.Slugify(name)
+	if request.Slug != nil {
+		slug = *request.Slug
+	}
+	if err := helpers.ValidateSlug(slug); err != nil {
+		return nil, err
+	}
+
+	var parentId *string
+	if request.ParentId != nil && *request.ParentId != "" {
+		parentId = request.ParentId
+	}
+
+	tx, err := cs.DB.Beginx()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		} else if err != nil {
+			tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
+
+	err = lockCategories(tx, true)
+	if err != nil {
+		return nil, err
+	}
+	err = checkSlugAvailable(tx, slug, "")
+	if err != nil {
+		return nil, err
+	}
+	if parentId != nil {
+		err = checkParent(tx, *parentId, "")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var category models.Category
+	query := `INSERT INTO categories (parentid, name, slug) VALUES ($1, $2, $3) RETURNING *`
+	err = tx.Get(&category, query, parentId, name, slug)
+	if err != nil {
+		return nil, fmt.Errorf("error creating category: %w", err)
+	}
+
+	changes, err := helpers.AuditDiff(models.Category{}, category, "categoryId", "createdAt", "updatedAt")
+	if err != nil {
+		return nil, fmt.Errorf("failed to compare category: %w", err)
+	}
+	err = cs.auditCategory(tx, AuditCategoryCreated, category.CategoryId, adminId, ip, changes, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &category, nil
+}
+
+// UpdateCategory renames, re-slugs or moves a category. Products below it get their new
+// category path in the same transaction.
+func (cs *CategoryService) UpdateCategory(adminId, categoryId, ip string, request *models.CategoryRequest) (_ *models.Category, err error) {
+	tx, err := cs.DB.Beginx()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		} else if err != nil {
+			tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
+
+	err = lockCategories(tx, true)
+	if err != nil {
+		return nil, err
+	}
+	before, err := lockCategory(tx, categoryId)
+	if err != nil {
+		return nil, err
+	}
+	after := *before
+
+	if request.Name != nil {
+		after.Name = strings.TrimSpace(*request.Name)
+		if after.Name == "" {
+			return nil, fmt.Errorf("name can't be empty")
+		}
+	}
+	if request.Slug != nil {
+		after.Slug = *request.Slug
+		if err := helpers.ValidateSlug(after.Slug); err != nil {
+			return nil, err
+		}
+		err = checkSlugAvailable(tx, after.Slug, categoryId)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if request.ParentId != nil {
+		after.ParentId = nil
+		if *request.ParentId != "" {
+			err = checkParent(tx, *request.ParentId, categoryId)
+			if err != nil {
+				return nil, err
+			}
+			after.ParentId = request.ParentId
+		}
+	}
+
+	var updated models.Category
+	query := `
+	UPDATE categories
+	SET parentid = $1, name = $2, slug = $3, updatedat = CURRENT_TIMESTAMP
+	WHERE categoryid = $4
+	RETURNING *
+	`
+	err = tx.Get(&updated, query, after.ParentId, after.Name, after.Slug, categoryId)
+	if err != nil {
+		return nil, fmt.Errorf("error updating category: %w", err)
+	}
+
+	changes, err := helpers.AuditDiff(before, updated, "updatedAt")
+	if err != nil {
+		return nil, fmt.Errorf("failed to compare category: %w", err)
+	}
+	if len(changes) == 0 {
+		return &updated, nil
+	}
+
+	err = cs.auditCategory(tx, AuditCategoryUpdated, categoryId, adminId, ip, changes, nil)
+	if err != nil {
+		return nil, err
+	}
+	err = refreshCategoryPaths(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &updated, nil
+}
+
+// DeleteCategory removes a category without subcategories. Its products, active or not,
+// move to moveTo, which is required when there are any; this is also how two categories
+// are merged.
+func (cs *CategoryService) DeleteCategory(adminId, categoryId, moveTo, ip string) (err error) {
+	tx, err := cs.DB.Beginx()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		} else if err != nil {
+			tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
+
+	err = lockCategories(tx, true)
+	if err != nil {
+		return err
+	}
+	category, err := lockCategory(tx, categoryId)
+	if err != nil {
+		return err
+	}
+
+	var hasChildren bool
+	err = tx.Get(&hasChildren, `SELECT EXISTS (SELECT 1 FROM categories WHERE parentid = $1)`, categoryId)
+	if err != nil {
+		return fmt.Errorf("error checking subcategories: %w", err)
+	}
+	if hasChildren {
+		return fmt.Errorf("category has subcategories, move or delete them first")
+	}
+
+	var productCount int
+	err = tx.Get(&productCount, `SELECT COUNT(*) FROM products WHERE categoryid = $1`, categoryId)
+	if err != nil {
+		return fmt.Errorf("error counting category products: %w", err)
+	}
+
+	metadata := map[string]any{}
+	if productCount > 0 {
+		if moveTo == "" {
+			return fmt.Errorf("category has %d products, choose a category to move them to", productCount)
+		}
+		if moveTo == categoryId {
+			return fmt.Errorf("can't move products to the category being deleted")
+		}
+		exists, err := categoryExists(tx, moveTo)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return fmt.Errorf("category to move products to not found")
+		}
+
+		_, err = tx.Exec(`UPDATE products SET categoryid = $1 WHERE categoryid = $2`, moveTo, categoryId)
+		if err != nil {
+			return fmt.Errorf("failed to move category products: %w", err)
+		}
+		metadata["movedTo"] = moveTo
+		metadata["products"] = productCount
+	}
+
+	_, err = tx.Exec(`DELETE FROM categories WHERE categoryid = $1`, categoryId)
+	if err != nil {
+		return fmt.Errorf("error deleting category: %w", err)
+	}
+
+	changes, err := helpers.AuditDiff(category, models.Category{}, "categoryId", "createdAt", "updatedAt")
+	if err != nil {
+		return fmt.Errorf("failed to compare category: %w", err)
+	}
+	err = cs.auditCategory(tx, AuditCategoryDeleted, categoryId, adminId, ip, changes, metadata)
+	if err != nil {
+		return err
+	}
+
+	return refreshCategoryPaths(tx)
+}
@@ -4,22 +4,29 @@ import (
 	"eCommerce/helpers"
 	"eCommerce/models"
 	"fmt"
+	"slices"
 	"time"
 
 	"github.com/jmoiron/sqlx"
 )
 
 type CheckoutService struct {
-	DB *sqlx.DB
-	cs CartService
-	ss ShippingService
+	DB  *sqlx.DB
+	cs  CartService
+	ss  ShippingService
+	obs OutboxService
+	ws  WebhookService
+	ns  NotificationService
 }
 
-func NewCheckoutService(db *sqlx.DB, cs CartService, ss ShippingService) *CheckoutService {
+func NewCheckoutService(db *sqlx.DB, cs CartService, ss ShippingService, obs OutboxService, ws WebhookService, ns NotificationService) *CheckoutService {
 	return &CheckoutService{
 		db,
 		cs,
 		ss,
+		obs,
+		ws,
+		ns,
 	}
 }
 
@@ -39,7 +46,9 @@ func (chs *CheckoutService) OrderSummary(cartId, userId string) (*models.Summary
 	return result, nil
 }
 
-func (chs *CheckoutService) ConfirmPurchase(cartId, userId, userEmail string) (*models.Summary, error) {
+// ConfirmPurchase places the order. Everything, including queueing the confirmation
+// email, happens in one transaction, so an order is either fully placed or not at all.
+func (chs *CheckoutService) ConfirmPurchase(cartId, userId, userEmail string) (_ *models.Summary, err error) {
 	tx, err := chs.DB.Beginx()
 	if err != nil {
 		return nil, fmt.Errorf("failed to begin transaction: %w", err)
@@ -77,18 +86,34 @@ func (chs *CheckoutService) ConfirmPurchase(cartId, userId, userEmail string) (*
 		return nil, fmt.Errorf("error creating a new order: %w", err)
 	}
 
-	// Collect all product IDs from cart items
+	// Collect all product and variant IDs from cart items
 	productIDs := make([]string, 0, len(cart.Items))
+	variantIDs := make([]string, 0, len(cart.Items))
 	for _, item := range cart.Items {
-		productIDs = append(productIDs, item.ProductId)
+		if !slices.Contains(productIDs, item.ProductId) {
+			productIDs = append(productIDs, item.ProductId)
+		}
+		variantIDs = append(variantIDs, item.VariantId)
 	}
 
-	// Lock all products in one query
+	// Each variant's stock is synced to its product below. Locking the products first,
+	// in one order and like vendor updates do, keeps two checkouts of different variants
+	// of the same products from deadlocking on them.
+	query, args, err := sqlx.In(`SELECT productid FROM products WHERE productid IN (?) ORDER BY productid FOR UPDATE`, productIDs)
+	if err != nil {
+		return nil, fmt.Errorf("error preparing product lock query: %w", err)
+	}
+	_, err = tx.Exec(tx.Rebind(query), args...)
+	if err != nil {
+		return nil, fmt.Errorf("error locking products: %w", err)
+	}
+
+	// Lock all variants in one query
 	// sqlx.In: it takes your query with a placeholder ? and a slice of values, and expands that slice into the proper number of placeholders.
 	// So, before rebinding, the query string would look like:
-	// SELECT productid, stock FROM products WHERE productid IN (?, ?, ?, ...) FOR UPDATE, with as many ? placeholders as there are product IDs in your productIDs slice.
+	// SELECT variantid, stock, is_active FROM product_variants WHERE variantid IN (?, ?, ?, ...) FOR UPDATE, with as many ? placeholders as there are variant IDs in your variantIDs slice.
 	// After Rebind, it converts those ? placeholders to whatever the database driver expects $1, $2, $3, ...
-	query, args, err := sqlx.In(`SELECT productid, stock FROM products WHERE productid IN (?) FOR UPDATE`, productIDs)
+	query, args, err = sqlx.In(`SELECT variantid, stock, is_active FROM product_variants WHERE variantid IN (?) ORDER BY variantid FOR UPDATE`, variantIDs)
 	if err != nil {
 		return nil, fmt.Errorf("error preparing stock lock query: %w", err)
 	}
@@ -96,64 +121,123 @@ func (chs *CheckoutService) ConfirmPurchase(cartId, userId, userEmail string) (*
 
 	rows, err := tx.Queryx(query, args...)
 	if err != nil {
-		return nil, fmt.Errorf("error locking product stocks: %w", err)
+		return nil, fmt.Errorf("error locking variant stocks: %w", err)
 	}
 	defer rows.Close()
 
-	// Map productid -> current stock
+	// Map variantid -> current stock, for the variants still on sale
 	stockMap := make(map[string]int)
 	for rows.Next() {
-		var pid string
+		var vid string
 		var stock int
-		if err := rows.Scan(&pid, &stock); err != nil {
+		var isActive bool
+		if err := rows.Scan(&vid, &stock, &isActive); err != nil {
 			return nil, fmt.Errorf("error scanning locked stock row: %w", err)
 		}
-		stockMap[pid] = stock
+		if isActive {
+			stockMap[vid] = stock
+		}
 	}
 
 	// Check stock availability
 	for _, item := range cart.Items {
-		currentStock, exists := stockMap[item.ProductId]
+		currentStock, exists := stockMap[item.VariantId]
 		if !exists {
-			return nil, fmt.Errorf("product %s no longer exists", item.Name)
+			return nil, fmt.Errorf("%s %s is no longer available", item.Name, item.SKU)
 		}
 		if currentStock < item.Quantity {
-			return nil, fmt.Errorf("not enough stock for %s", item.Name)
+			return nil, fmt.Errorf("not enough stock for %s %s", item.Name, item.SKU)
 		}
 	}
 
-	// Update stock for each product
+	// Update stock for each variant, and the product's stock with it
 	for _, item := range cart.Items {
-		updateQuery := `UPDATE products SET stock = stock - $1 WHERE productid = $2 AND stock >= $1`
-		res, err := tx.Exec(updateQuery, item.Quantity, item.ProductId)
+		updateQuery := `UPDATE product_variants SET stock = stock - $1, updatedat = CURRENT_TIMESTAMP WHERE variantid = $2 AND stock >= $1`
+		res, err := tx.Exec(updateQuery, item.Quantity, item.VariantId)
 		if err != nil {
 			return nil, fmt.Errorf("error updating stock for %s: %w", item.Name, err)
 		}
-		rowsAffected, err := res.RowsAffected()
+		if updated, _ := res.RowsAffected(); updated == 0 {
+			return nil, fmt.Errorf("failed to update stock for %s, possibly insufficient stock", item.Name)
+		}
+
+		product, err := syncProductStock(tx, item.ProductId)
 		if err != nil {
-			return nil, fmt.Errorf("error checking update result for %s: %w", item.Name, err)
+			return nil, err
 		}
-		if rowsAffected == 0 {
-			return nil, fmt.Errorf("failed to update stock for %s, possibly insufficient stock", item.Name)
+
+		// Only the purchase that takes the stock below the threshold notifies the vendor
+		if *product.Stock < lowStockThreshold && *product.Stock+item.Quantity >= lowStockThreshold {
+			err = chs.ws.Publish(tx, product.VendorId, WebhookProductLowStock, product)
+			if err != nil {
+				return nil, err
+			}
 		}
 	}
 	// insert into order_items
 	for _, item := range cart.Items {
-		insertQuery := `INSERT INTO order_items (orderid, productid, quantity) VALUES($1, $2, $3)`
-		_, err := tx.Exec(insertQuery, newOrderId, item.ProductId, item.Quantity)
+		insertQuery := `INSERT INTO order_items (orderid, productid, variantid, quantity) VALUES($1, $2, $3, $4)`
+		_, err := tx.Exec(insertQuery, newOrderId, item.ProductId, item.VariantId, item.Quantity)
 		if err != nil {
 			return nil, fmt.Errorf("error inserting order items: %w", err)
 		}
 	}
 
-	err = chs.cs.DeleteCart(cartId, userId)
+	err = recordOrderStatusEvent(tx, newOrderId, userId, nil, "processing")
+	if err != nil {
+		return nil, err
+	}
+
+	vendorOrders, err := loadVendorOrders(tx, newOrderId)
+	if err != nil {
+		return nil, err
+	}
+	for vendorId, order := range vendorOrders {
+		err = chs.ws.Publish(tx, vendorId, WebhookOrderCreated, order)
+		if err != nil {
+			return nil, err
+		}
+
+		err = chs.ns.Notify(tx, &models.NotificationEntry{
+			UserId:   vendorId,
+			Audience: NotificationAudienceVendor,
+			Type:     NotificationVendorOrder,
+			Category: helpers.CategoryStoreActivity,
+			Title:    "New order",
+			Body:     fmt.Sprintf("You received an order for %d of your products.", len(order.Items)),
+			Data:     map[string]any{"orderId": newOrderId},
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = chs.ns.Notify(tx, &models.NotificationEntry{
+		UserId:   userId,
+		Audience: NotificationAudienceCustomer,
+		Type:     NotificationOrderPlaced,
+		Category: helpers.CategoryOrderUpdates,
+		Title:    "Order placed",
+		Body:     fmt.Sprintf("Your order totalling $%.2f was placed.", cart.Total),
+		Data:     map[string]any{"orderId": newOrderId},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec("DELETE FROM cart_items WHERE cartid = $1 AND userid = $2", cartId, userId)
 	if err != nil {
 		return nil, fmt.Errorf("error emptying cart: %w", err)
 	}
 
-	err = helpers.SendConfirmationEmail(newOrderId, userEmail, cart.Total, time.Now())
+	// Sent by the outbox dispatcher after commit; a mail failure can't undo the order
+	email, err := helpers.OrderConfirmationEmail(newOrderId, userEmail, cart.Total, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("error rendering confirmation email: %w", err)
+	}
+	err = chs.obs.EnqueueEmail(tx, email)
 	if err != nil {
-		return nil, fmt.Errorf("error sending confirmation email: %w", err)
+		return nil, err
 	}
 
 	return summary, nil
@@ -0,0 +1,179 @@
+package services
+
+import (
+	"database/sql"
+	"eCommerce/models"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// LoginAttemptStore keeps the failed-login counters used by LoginThrottleService
+type LoginAttemptStore interface {
+	// Get returns the counters for key, or nil if there are none
+	Get(key string) (*models.LoginAttempt, error)
+	// RecordFailure adds a failure for key. Failures older than window are forgotten first.
+	RecordFailure(key string, now time.Time, window time.Duration) (*models.LoginAttempt, error)
+	// Lock locks key until the given time. unlockTokenHash lets the owner lift the lock early.
+	Lock(key string, until time.Time, unlockTokenHash string) error
+	// Unlock clears the active lock that matches unlockTokenHash and reports whether one was found
+	Unlock(unlockTokenHash string, now time.Time) (bool, error)
+	Reset(key string) error
+}
+
+// === Postgres ===
+
+type PostgresLoginAttemptStore struct {
+	DB *sqlx.DB
+}
+
+func NewPostgresLoginAttemptStore(db *sqlx.DB) *PostgresLoginAttemptStore {
+	return &PostgresLoginAttemptStore{
+		db,
+	}
+}
+
+func (ps *PostgresLoginAttemptStore) Get(key string) (*models.LoginAttempt, error) {
+	var attempt models.LoginAttempt
+	err := ps.DB.Get(&attempt, `SELECT * FROM login_attempts WHERE attemptkey = $1`, key)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to fetch login attempts: %w", err)
+	}
+
+	return &attempt, nil
+}
+
+func (ps *PostgresLoginAttemptStore) RecordFailure(key string, now time.Time, window time.Duration) (*models.LoginAttempt, error) {
+	var attempt models.LoginAttempt
+	query := `
+	INSERT INTO login_attempts (attemptkey, failures, lastfailureat)
+	VALUES ($1, 1, $2)
+	ON CONFLICT (attemptkey) DO UPDATE
+	SET failures = CASE WHEN login_attempts.lastfailureat < $3 THEN 1 ELSE login_attempts.failures + 1 END,
+		lastfailureat = $2
+	RETURNING *
+	`
+	err := ps.DB.Get(&attempt, query, key, now, now.Add(-window))
+	if err != nil {
+		return nil, fmt.Errorf("failed to record login attempt: %w", err)
+	}
+
+	return &attempt, nil
+}
+
+func (ps *PostgresLoginAttemptStore) Lock(key string, until time.Time, unlockTokenHash string) error {
+	_, err := ps.DB.Exec(`UPDATE login_attempts SET lockeduntil = $1, unlock_token_hash = $2 WHERE attemptkey = $3`, until, unlockTokenHash, key)
+	if err != nil {
+		return fmt.Errorf("failed to lock account: %w", err)
+	}
+	return nil
+}
+
+func (ps *PostgresLoginAttemptStore) Unlock(unlockTokenHash string, now time.Time) (bool, error) {
+	res, err := ps.DB.Exec(`DELETE FROM login_attempts WHERE unlock_token_hash = $1 AND lockeduntil > $2`, unlockTokenHash, now)
+	if err != nil {
+		return false, fmt.Errorf("failed to unlock account: %w", err)
+	}
+
+	affectedRows, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to check unlocked rows: %w", err)
+	}
+
+	return affectedRows > 0, nil
+}
+
+func (ps *PostgresLoginAttemptStore) Reset(key string) error {
+	_, err := ps.DB.Exec(`DELETE FROM login_attempts WHERE attemptkey = $1`, key)
+	if err != nil {
+		return fmt.Errorf("failed to reset login attempts: %w", err)
+	}
+	return nil
+}
+
+// === In-memory ===
+
+// MemoryLoginAttemptStore keeps counters in process memory. It is meant for tests and
+// single-instance deployments, counters are lost on restart.
+type MemoryLoginAttemptStore struct {
+	mu       sync.Mutex
+	attempts map[string]*models.LoginAttempt
+}
+
+func NewMemoryLoginAttemptStore() *MemoryLoginAttemptStore {
+	return &MemoryLoginAttemptStore{
+		attempts: make(map[string]*models.LoginAttempt),
+	}
+}
+
+func (ms *MemoryLoginAttemptStore) Get(key string) (*models.LoginAttempt, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	attempt, ok := ms.attempts[key]
+	if !ok {
+		return nil, nil
+	}
+	copied := *attempt
+	return &copied, nil
+}
+
+func (ms *MemoryLoginAttemptStore) RecordFailure(key string, now time.Time, window time.Duration) (*models.LoginAttempt, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	attempt, ok := ms.attempts[key]
+	if !ok {
+		attempt = &models.LoginAttempt{AttemptKey: key}
+		ms.attempts[key] = attempt
+	}
+	if attempt.LastFailureAt.Before(now.Add(-window)) {
+		attempt.Failures = 0
+	}
+	attempt.Failures++
+	attempt.LastFailureAt = now
+
+	copied := *attempt
+	return &copied, nil
+}
+
+func (ms *MemoryLoginAttemptStore) Lock(key string, until time.Time, unlockTokenHash string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	attempt, ok := ms.attempts[key]
+	if !ok {
+		return nil
+	}
+	attempt.LockedUntil = &until
+	attempt.UnlockTokenHash = &unlockTokenHash
+	return nil
+}
+
+func (ms *MemoryLoginAttemptStore) Unlock(unlockTokenHash string, now time.Time) (bool, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	for key, attempt := range ms.attempts {
+		if attempt.UnlockTokenHash != nil && *attempt.UnlockTokenHash == unlockTokenHash &&
+			attempt.LockedUntil != nil && attempt.LockedUntil.After(now) {
+			delete(ms.attempts, key)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (ms *MemoryLoginAttemptStore) Reset(key string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	delete(ms.attempts, key)
+	return nil
+}
@@ -0,0 +1,206 @@
+package services
+
+import (
+	"eCommerce/helpers"
+	"eCommerce/models"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// failures are forgotten after this long without a new one
+	loginAttemptWindow = time.Hour
+
+	// accounts get a growing delay after a few failures and are locked after more
+	accountFreeAttempts = 3
+	accountLockAttempts = 10
+	accountLockDuration = 30 * time.Minute
+
+	// IPs are never locked (they may be shared) but back off after more failures
+	ipFreeAttempts = 10
+
+	maxLoginBackoff = 15 * time.Minute
+)
+
+// LoginThrottledError is returned while a key is locked or backing off. It matches
+// models.ErrTooManyAttempts with errors.Is.
+type LoginThrottledError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LoginThrottledError) Error() string {
+	return models.ErrTooManyAttempts.Error()
+}
+
+func (e *LoginThrottledError) Unwrap() error {
+	return models.ErrTooManyAttempts
+}
+
+// RateLimitedError is returned by Limit. It matches models.ErrTooManyRequests with errors.Is.
+type RateLimitedError struct {
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitedError) Error() string {
+	return models.ErrTooManyRequests.Error()
+}
+
+func (e *RateLimitedError) Unwrap() error {
+	return models.ErrTooManyRequests
+}
+
+type LoginThrottleService struct {
+	store LoginAttemptStore
+	now   func() time.Time
+}
+
+func NewLoginThrottleService(store LoginAttemptStore) *LoginThrottleService {
+	return &LoginThrottleService{
+		store: store,
+		now:   time.Now,
+	}
+}
+
+func accountAttemptKey(email string) string {
+	return "account:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func ipAttemptKey(ip string) string {
+	return "ip:" + ip
+}
+
+// loginBackoff doubles the delay for every failure past the free ones: 1s, 2s, 4s...
+func loginBackoff(failures, freeAttempts int) time.Duration {
+	if failures < freeAttempts {
+		return 0
+	}
+	shift := failures - freeAttempts
+	if shift > 20 {
+		return maxLoginBackoff
+	}
+	delay := time.Second << shift
+	if delay > maxLoginBackoff {
+		return maxLoginBackoff
+	}
+	return delay
+}
+
+// Check returns a *LoginThrottledError if the account or IP may not try to log in yet
+func (lts *LoginThrottleService) Check(email, ip string) error {
+	now := lts.now()
+
+	account, err := lts.store.Get(accountAttemptKey(email))
+	if err != nil {
+		return err
+	}
+	if account != nil && account.LockedUntil != nil {
+		if account.LockedUntil.After(now) {
+			return &LoginThrottledError{RetryAfter: account.LockedUntil.Sub(now)}
+		}
+		// the lock has run out, start counting again
+		if err := lts.store.Reset(account.AttemptKey); err != nil {
+			return err
+		}
+		account = nil
+	}
+	if retryAfter := lts.retryAfter(account, accountFreeAttempts, now); retryAfter > 0 {
+		return &LoginThrottledError{RetryAfter: retryAfter}
+	}
+
+	if ip == "" {
+		return nil
+	}
+	client, err := lts.store.Get(ipAttemptKey(ip))
+	if err != nil {
+		return err
+	}
+	if retryAfter := lts.retryAfter(client, ipFreeAttempts, now); retryAfter > 0 {
+		return &LoginThrottledError{RetryAfter: retryAfter}
+	}
+
+	return nil
+}
+
+func (lts *LoginThrottleService) retryAfter(attempt *models.LoginAttempt, freeAttempts int, now time.Time) time.Duration {
+	if attempt == nil || attempt.LastFailureAt.Before(now.Add(-loginAttemptWindow)) {
+		return 0
+	}
+	allowedAt := attempt.LastFailureAt.Add(loginBackoff(attempt.Failures, freeAttempts))
+	if allowedAt.After(now) {
+		return allowedAt.Sub(now)
+	}
+	return 0
+}
+
+// RegisterFailure records a failed login for the account and the IP. When this failure
+// locks the account, it returns the unlock token that should be emailed to the owner.
+func (lts *LoginThrottleService) RegisterFailure(email, ip string) (string, error) {
+	now := lts.now()
+
+	if ip != "" {
+		if _, err := lts.store.RecordFailure(ipAttemptKey(ip), now, loginAttemptWindow); err != nil {
+			return "", err
+		}
+	}
+
+	key := accountAttemptKey(email)
+	account, err := lts.store.RecordFailure(key, now, loginAttemptWindow)
+	if err != nil {
+		return "", err
+	}
+	if account.Failures < accountLockAttempts || account.LockedUntil != nil {
+		return "", nil
+	}
+
+	unlockToken, err := helpers.GenerateSecureToken()
+	if err != nil {
+		return "", fmt.Errorf("failed to generate unlock token: %w", err)
+	}
+	if err := lts.store.Lock(key, now.Add(accountLockDuration), helpers.HashToken(unlockToken)); err != nil {
+		return "", err
+	}
+
+	return unlockToken, nil
+}
+
+// RegisterSuccess clears the account's counters. IP counters are left alone so one valid
+// account can't be used to reset them.
+func (lts *LoginThrottleService) RegisterSuccess(email string) error {
+	return lts.store.Reset(accountAttemptKey(email))
+}
+
+// Unlock lifts a lock using the token from the unlock email
+func (lts *LoginThrottleService) Unlock(unlockToken string) error {
+	unlocked, err := lts.store.Unlock(helpers.HashToken(unlockToken), lts.now())
+	if err != nil {
+		return err
+	}
+	if !unlocked {
+		return fmt.Errorf("invalid or expired unlock token")
+	}
+	return nil
+}
+
+// Limit allows at most max requests for key per window, at least interval apart, and
+// counts this one when it is allowed. It shares the login_attempts counters, so keys
+// must not collide with the login ones.
+func (lts *LoginThrottleService) Limit(key string, max int, window, interval time.Duration) error {
+	now := lts.now()
+
+	attempt, err := lts.store.Get(key)
+	if err != nil {
+		return err
+	}
+	if attempt != nil && attempt.LastFailureAt.After(now.Add(-window)) {
+		if allowedAt := attempt.LastFailureAt.Add(interval); allowedAt.After(now) {
+			return &RateLimitedError{RetryAfter: allowedAt.Sub(now)}
+		}
+		if attempt.Failures >= max {
+			return &RateLimitedError{RetryAfter: attempt.LastFailureAt.Add(window).Sub(now)}
+		}
+	}
+
+	_, err = lts.store.RecordFailure(key, now, window)
+	return err
+}
@@ -0,0 +1,200 @@
+package services
+
+import (
+	"eCommerce/models"
+	"errors"
+	"testing"
+	"time"
+)
+
+// newTestThrottle returns a throttle over the in-memory store whose clock only moves
+// when the test advances it
+func newTestThrottle() (*LoginThrottleService, *MemoryLoginAttemptStore, func(time.Duration)) {
+	store := NewMemoryLoginAttemptStore()
+	lts := NewLoginThrottleService(store)
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	lts.now = func() time.Time { return now }
+	advance := func(d time.Duration) { now = now.Add(d) }
+	return lts, store, advance
+}
+
+func registerFailures(t *testing.T, lts *LoginThrottleService, email, ip string, n int) string {
+	t.Helper()
+	var unlockToken string
+	for i := 0; i < n; i++ {
+		token, err := lts.RegisterFailure(email, ip)
+		if err != nil {
+			t.Fatalf("RegisterFailure: %v", err)
+		}
+		if token != "" {
+			unlockToken = token
+		}
+	}
+	return unlockToken
+}
+
+// retryAfter returns how long Check asks the caller to wait, 0 if it may try now
+func retryAfter(t *testing.T, lts *LoginThrottleService, email, ip string) time.Duration {
+	t.Helper()
+	err := lts.Check(email, ip)
+	if err == nil {
+		return 0
+	}
+	var throttled *LoginThrottledError
+	if !errors.As(err, &throttled) {
+		t.Fatalf("Check: unexpected error %v", err)
+	}
+	if !errors.Is(err, models.ErrTooManyAttempts) {
+		t.Fatalf("Check: %v doesn't match ErrTooManyAttempts", err)
+	}
+	return throttled.RetryAfter
+}
+
+func TestLoginBackoff(t *testing.T) {
+	tests := []struct {
+		failures, free int
+		want           time.Duration
+	}{
+		{0, 3, 0},
+		{2, 3, 0},
+		{3, 3, time.Second},
+		{4, 3, 2 * time.Second},
+		{6, 3, 8 * time.Second},
+		{13, 3, maxLoginBackoff},
+		{100, 3, maxLoginBackoff},
+	}
+	for _, tt := range tests {
+		if got := loginBackoff(tt.failures, tt.free); got != tt.want {
+			t.Errorf("loginBackoff(%d, %d) = %v, want %v", tt.failures, tt.free, got, tt.want)
+		}
+	}
+}
+
+func TestLoginThrottleAccountBackoff(t *testing.T) {
+	lts, store, advance := newTestThrottle()
+
+	registerFailures(t, lts, "Shopper@Example.com", "", accountFreeAttempts-1)
+	if wait := retryAfter(t, lts, "shopper@example.com", ""); wait != 0 {
+		t.Fatalf("free attempts: got wait %v, want none", wait)
+	}
+
+	// Counted per account, regardless of case and surrounding spaces
+	attempt, err := store.Get(accountAttemptKey(" shopper@example.com "))
+	if err != nil || attempt == nil || attempt.Failures != accountFreeAttempts-1 {
+		t.Fatalf("account counter = %+v, %v", attempt, err)
+	}
+
+	// The delay doubles with every failure past the free ones
+	for i, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second} {
+		registerFailures(t, lts, "shopper@example.com", "", 1)
+		if wait := retryAfter(t, lts, "shopper@example.com", ""); wait != want {
+			t.Fatalf("failure %d: got wait %v, want %v", accountFreeAttempts+i, wait, want)
+		}
+		advance(want)
+		if wait := retryAfter(t, lts, "shopper@example.com", ""); wait != 0 {
+			t.Fatalf("failure %d: still waiting %v after the backoff", accountFreeAttempts+i, wait)
+		}
+	}
+
+	if wait := retryAfter(t, lts, "someone-else@example.com", ""); wait != 0 {
+		t.Fatalf("other account: got wait %v, want none", wait)
+	}
+}
+
+func TestLoginThrottleFailuresExpire(t *testing.T) {
+	lts, store, advance := newTestThrottle()
+
+	registerFailures(t, lts, "shopper@example.com", "", accountFreeAttempts)
+	advance(loginAttemptWindow + time.Second)
+	if wait := retryAfter(t, lts, "shopper@example.com", ""); wait != 0 {
+		t.Fatalf("after the window: got wait %v, want none", wait)
+	}
+
+	registerFailures(t, lts, "shopper@example.com", "", 1)
+	attempt, err := store.Get(accountAttemptKey("shopper@example.com"))
+	if err != nil || attempt == nil || attempt.Failures != 1 {
+		t.Fatalf("counter after the window = %+v, %v, want 1 failure", attempt, err)
+	}
+}
+
+func TestLoginThrottleIPBackoff(t *testing.T) {
+	lts, _, _ := newTestThrottle()
+
+	// Spread over accounts so no single account backs off
+	for i := 0; i < ipFreeAttempts; i++ {
+		email := string(rune('a'+i)) + "@example.com"
+		if wait := retryAfter(t, lts, "new@example.com", "203.0.113.7"); wait != 0 {
+			t.Fatalf("failure %d: got wait %v, want none", i, wait)
+		}
+		registerFailures(t, lts, email, "203.0.113.7", 1)
+	}
+
+	if wait := retryAfter(t, lts, "new@example.com", "203.0.113.7"); wait != time.Second {
+		t.Fatalf("IP past its free attempts: got wait %v, want 1s", wait)
+	}
+	if wait := retryAfter(t, lts, "new@example.com", "198.51.100.1"); wait != 0 {
+		t.Fatalf("other IP: got wait %v, want none", wait)
+	}
+}
+
+func TestLoginThrottleLockout(t *testing.T) {
+	lts, _, advance := newTestThrottle()
+
+	if token := registerFailures(t, lts, "shopper@example.com", "", accountLockAttempts-1); token != "" {
+		t.Fatalf("locked before %d failures", accountLockAttempts)
+	}
+	token := registerFailures(t, lts, "shopper@example.com", "", 1)
+	if token == "" {
+		t.Fatalf("not locked after %d failures", accountLockAttempts)
+	}
+	if wait := retryAfter(t, lts, "shopper@example.com", ""); wait != accountLockDuration {
+		t.Fatalf("locked: got wait %v, want %v", wait, accountLockDuration)
+	}
+
+	// Failures during the lock don't lock again or send another token
+	if again := registerFailures(t, lts, "shopper@example.com", "", 1); again != "" {
+		t.Fatalf("a failure while locked issued another unlock token")
+	}
+
+	advance(accountLockDuration)
+	if wait := retryAfter(t, lts, "shopper@example.com", ""); wait != 0 {
+		t.Fatalf("after the lock: got wait %v, want none", wait)
+	}
+	if err := lts.Unlock(token); err == nil {
+		t.Fatalf("Unlock accepted the token of an expired lock")
+	}
+}
+
+func TestLoginThrottleUnlock(t *testing.T) {
+	lts, _, _ := newTestThrottle()
+
+	token := registerFailures(t, lts, "shopper@example.com", "", accountLockAttempts)
+	if err := lts.Unlock("not-the-token"); err == nil {
+		t.Fatalf("Unlock accepted a wrong token")
+	}
+	if err := lts.Unlock(token); err != nil {
+		t.Fatalf("Unlock: %v", err)
+	}
+	if wait := retryAfter(t, lts, "shopper@example.com", ""); wait != 0 {
+		t.Fatalf("after unlock: got wait %v, want none", wait)
+	}
+	if err := lts.Unlock(token); err == nil {
+		t.Fatalf("Unlock accepted the same token twice")
+	}
+}
+
+func TestLoginThrottleSuccessResetsAccountOnly(t *testing.T) {
+	lts, _, _ := newTestThrottle()
+
+	registerFailures(t, lts, "shopper@example.com", "203.0.113.7", ipFreeAttempts)
+	if err := lts.RegisterSuccess("shopper@example.com"); err != nil {
+		t.Fatalf("RegisterSuccess: %v", err)
+	}
+
+	if wait := retryAfter(t, lts, "shopper@example.com", ""); wait != 0 {
+		t.Fatalf("account after success: got wait %v, want none", wait)
+	}
+	if wait := retryAfter(t, lts, "shopper@example.com", "203.0.113.7"); wait == 0 {
+		t.Fatalf("a successful login reset the IP counter")
+	}
+}
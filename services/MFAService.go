@@ -0,0 +1,271 @@
+package services
+
+import (
+	"database/sql"
+	"eCommerce/helpers"
+	"eCommerce/models"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	mfaIssuer         = "eCommerce"
+	recoveryCodeCount = 10
+)
+
+type MFAService struct {
+	DB  *sqlx.DB
+	aus AuditService
+}
+
+func NewMFAService(db *sqlx.DB, aus AuditService) *MFAService {
+	return &MFAService{
+		db,
+		aus,
+	}
+}
+
+func (ms *MFAService) IsEnabled(userId string) (bool, error) {
+	var enabled bool
+	err := ms.DB.Get(&enabled, `SELECT enabled FROM user_mfa WHERE userid = $1`, userId)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to check mfa status: %w", err)
+	}
+	return enabled, nil
+}
+
+// Enroll creates a new unconfirmed secret. MFA is only enforced once ConfirmEnrollment
+// proves the authenticator app produces matching codes.
+func (ms *MFAService) Enroll(userId, email string) (*models.MFAEnrollment, error) {
+	enabled, err := ms.IsEnabled(userId)
+	if err != nil {
+		return nil, err
+	}
+	if enabled {
+		return nil, fmt.Errorf("mfa is already enabled")
+	}
+
+	secret, err := helpers.GenerateTOTPSecret()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate mfa secret: %w", err)
+	}
+
+	upsertQuery := `
+	INSERT INTO user_mfa (userid, secret)
+	VALUES ($1, $2)
+	ON CONFLICT (userid)
+	DO UPDATE SET secret = EXCLUDED.secret, enabled = FALSE, last_used_step = 0, confirmedat = NULL
+	`
+	_, err = ms.DB.Exec(upsertQuery, userId, secret)
+	if err != nil {
+		return nil, fmt.Errorf("failed to store mfa secret: %w", err)
+	}
+
+	enrollment := &models.MFAEnrollment{
+		Secret:          secret,
+		ProvisioningURI: helpers.TOTPProvisioningURI(mfaIssuer, email, secret),
+	}
+
+	return enrollment, nil
+}
+
+// ConfirmEnrollment enables MFA and returns the plaintext recovery codes. They are
+// only ever shown here; the database keeps their hashes.
+func (ms *MFAService) ConfirmEnrollment(userId, code, ip string) (_ []string, err error) {
+	tx, err := ms.DB.Beginx()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		} else if err != nil {
+			tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
+
+	var mfa models.UserMFA
+	err = tx.Get(&mfa, `SELECT * FROM user_mfa WHERE userid = $1 FOR UPDATE`, userId)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("mfa enrollment not started")
+		}
+		return nil, fmt.Errorf("failed to fetch mfa settings: %w", err)
+	}
+
+	if mfa.Enabled {
+		err = fmt.Errorf("mfa is already enabled")
+		return nil, err
+	}
+
+	step, ok := helpers.ValidateTOTP(mfa.Secret, code, mfa.LastUsedStep, time.Now())
+	if !ok {
+		err = fmt.Errorf("invalid mfa code")
+		return nil, err
+	}
+
+	_, err = tx.Exec(`
+		UPDATE user_mfa
+		SET enabled = TRUE, last_used_step = $1, confirmedat = CURRENT_TIMESTAMP
+		WHERE userid = $2
+	`, step, userId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to enable mfa: %w", err)
+	}
+
+	codes, err := ms.replaceRecoveryCodes(tx, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	err = ms.aus.Record(tx, &models.AuditEntry{
+		Action:     AuditMFAEnabled,
+		ActorId:    userId,
+		IPAddress:  ip,
+		TargetType: auditTargetUser,
+		TargetId:   userId,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+func (ms *MFAService) replaceRecoveryCodes(tx *sqlx.Tx, userId string) ([]string, error) {
+	_, err := tx.Exec(`DELETE FROM mfa_recovery_codes WHERE userid = $1`, userId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete old recovery codes: %w", err)
+	}
+
+	codes, err := helpers.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate recovery codes: %w", err)
+	}
+
+	for _, code := range codes {
+		_, err = tx.Exec(`INSERT INTO mfa_recovery_codes (userid, code_hash) VALUES ($1, $2)`, userId, helpers.HashToken(code))
+		if err != nil {
+			return nil, fmt.Errorf("failed to store recovery codes: %w", err)
+		}
+	}
+
+	return codes, nil
+}
+
+// Verify accepts either a current TOTP code or an unused recovery code, consuming
+// whichever one matched.
+func (ms *MFAService) Verify(userId, code string) (err error) {
+	code = strings.TrimSpace(code)
+	if code == "" {
+		return fmt.Errorf("mfa code is required")
+	}
+
+	tx, err := ms.DB.Beginx()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		} else if err != nil {
+			tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
+
+	var mfa models.UserMFA
+	err = tx.Get(&mfa, `SELECT * FROM user_mfa WHERE userid = $1 AND enabled = TRUE FOR UPDATE`, userId)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("mfa is not enabled")
+		}
+		return fmt.Errorf("failed to fetch mfa settings: %w", err)
+	}
+
+	if step, ok := helpers.ValidateTOTP(mfa.Secret, code, mfa.LastUsedStep, time.Now()); ok {
+		_, err = tx.Exec(`UPDATE user_mfa SET last_used_step = $1 WHERE userid = $2`, step, userId)
+		if err != nil {
+			return fmt.Errorf("failed to update mfa settings: %w", err)
+		}
+		return nil
+	}
+
+	res, err := tx.Exec(`
+		UPDATE mfa_recovery_codes SET usedat = CURRENT_TIMESTAMP
+		WHERE userid = $1 AND code_hash = $2 AND usedat IS NULL
+	`, userId, helpers.HashToken(strings.ToLower(code)))
+	if err != nil {
+		return fmt.Errorf("failed to check recovery code: %w", err)
+	}
+
+	affectedRows, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to check recovery code: %w", err)
+	}
+	if affectedRows == 0 {
+		err = fmt.Errorf("invalid mfa code")
+		return err
+	}
+
+	return nil
+}
+
+func (ms *MFAService) Disable(userId, code, ip string) (err error) {
+	if err := ms.Verify(userId, code); err != nil {
+		return err
+	}
+
+	tx, err := ms.DB.Beginx()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		} else if err != nil {
+			tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
+
+	_, err = tx.Exec(`DELETE FROM mfa_recovery_codes WHERE userid = $1`, userId)
+	if err != nil {
+		return fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
+
+	_, err = tx.Exec(`DELETE FROM user_mfa WHERE userid = $1`, userId)
+	if err != nil {
+		return fmt.Errorf("failed to disable mfa: %w", err)
+	}
+
+	err = ms.aus.Record(tx, &models.AuditEntry{
+		Action:     AuditMFADisabled,
+		ActorId:    userId,
+		IPAddress:  ip,
+		TargetType: auditTargetUser,
+		TargetId:   userId,
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,282 @@
+package services
+
+import (
+	"eCommerce/helpers"
+	"eCommerce/models"
+	"encoding/json"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Notification audiences. A vendor has both inboxes: what they bought and what
+// happens in their store.
+const (
+	NotificationAudienceCustomer = "customer"
+	NotificationAudienceVendor   = "vendor"
+)
+
+// Notification types
+const (
+	NotificationOrderPlaced        = "order.placed"
+	NotificationOrderStatusChanged = "order.status_changed"
+	NotificationBackInStock        = "product.back_in_stock"
+	NotificationVendorOrder        = "vendor.order_received"
+	NotificationVendorReview       = "vendor.review_received"
+)
+
+// NotificationService keeps the in-app inbox. Like audit events, notifications are
+// written with the caller's db handle, so they exist exactly when the change they
+// describe was committed.
+type NotificationService struct {
+	DB *sqlx.DB
+}
+
+func NewNotificationService(db *sqlx.DB) *NotificationService {
+	return &NotificationService{
+		db,
+	}
+}
+
+func encodeNotificationData(data map[string]any) (*string, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode notification data: %w", err)
+	}
+	value := string(encoded)
+	return &value, nil
+}
+
+// loadNotificationPreferences returns the user's preferences with the defaults filled in
+// for everything they haven't set
+func loadNotificationPreferences(db sqlx.Ext, userId string) (models.NotificationPreferences, error) {
+	var rows []struct {
+		Channel  string `db:"channel"`
+		Category string `db:"category"`
+		Enabled  bool   `db:"enabled"`
+	}
+	query := `SELECT channel, category, enabled FROM notification_preferences WHERE userid = $1`
+	err := sqlx.Select(db, &rows, query, userId)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching notification preferences: %w", err)
+	}
+
+	preferences := helpers.DefaultNotificationPreferences()
+	for _, row := range rows {
+		if _, ok := preferences[row.Channel][row.Category]; ok {
+			preferences[row.Channel][row.Category] = row.Enabled
+		}
+	}
+	return preferences, nil
+}
+
+func setNotificationPreference(db sqlx.Ext, userId, channel, category string, enabled bool) error {
+	upsertQuery := `
+	INSERT INTO notification_preferences (userid, channel, category, enabled)
+	VALUES ($1, $2, $3, $4)
+	ON CONFLICT (userid, channel, category)
+	DO UPDATE SET enabled = EXCLUDED.enabled, updatedat = CURRENT_TIMESTAMP
+	`
+	_, err := db.Exec(upsertQuery, userId, channel, category, enabled)
+	if err != nil {
+		return fmt.Errorf("failed to update notification preferences: %w", err)
+	}
+	return nil
+}
+
+// Notify adds the notification unless the user turned its category off for the in-app
+// channel
+func (ns *NotificationService) Notify(db sqlx.Ext, entry *models.NotificationEntry) error {
+	preferences, err := loadNotificationPreferences(db, entry.UserId)
+	if err != nil {
+		return err
+	}
+	if !helpers.NotificationAllowed(preferences, helpers.ChannelInApp, entry.Category) {
+		return nil
+	}
+
+	data, err := encodeNotificationData(entry.Data)
+	if err != nil {
+		return err
+	}
+
+	insertQuery := `
+	INSERT INTO notifications (userid, audience, type, title, body, data)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	`
+	_, err = db.Exec(insertQuery, entry.UserId, entry.Audience, entry.Type, entry.Title, entry.Body, data)
+	if err != nil {
+		return fmt.Errorf("failed to create notification: %w", err)
+	}
+
+	return nil
+}
+
+// NotifyBackInStock tells every customer with the product on a wishlist that it can be
+// bought again, skipping those who turned off in-app price alerts
+func (ns *NotificationService) NotifyBackInStock(db sqlx.Ext, product *models.Product) error {
+	data, err := encodeNotificationData(map[string]any{"productId": product.ProductId})
+	if err != nil {
+		return err
+	}
+
+	insertQuery := `
+	INSERT INTO notifications (userid, audience, type, title, body, data)
+	SELECT DISTINCT w.userid, $1, $2, $3, $4, $5::jsonb
+	FROM wishlist_items wi
+	JOIN wishlists w ON w.wishlistid = wi.wishlistid
+	LEFT JOIN notification_preferences np
+		ON np.userid = w.userid AND np.channel = $7 AND np.category = $8
+	WHERE wi.productid = $6 AND COALESCE(np.enabled, $9)
+	`
+	_, err = db.Exec(insertQuery,
+		NotificationAudienceCustomer,
+		NotificationBackInStock,
+		"Back in stock",
+		fmt.Sprintf("%s from your wishlist is available again.", product.Name),
+		data,
+		product.ProductId,
+		helpers.ChannelInApp,
+		helpers.CategoryPriceAlerts,
+		helpers.DefaultNotificationPreferences()[helpers.ChannelInApp][helpers.CategoryPriceAlerts],
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create back in stock notifications: %w", err)
+	}
+
+	return nil
+}
+
+// GetNotifications lists one inbox of the user, unread first and then newest first.
+// Filters: unread ("true" for unread only), limit (default 50, at most 200) and offset.
+func (ns *NotificationService) GetNotifications(userId, audience string, filters map[string]string) ([]*models.Notification, error) {
+	limit, offset := pageFilters(filters)
+
+	query := `
+	SELECT * FROM notifications
+	WHERE userid = $1 AND audience = $2 AND ($3 = FALSE OR readat IS NULL)
+	ORDER BY readat IS NULL DESC, createdat DESC, notificationid DESC
+	LIMIT $4 OFFSET $5
+	`
+	notifications := []*models.Notification{}
+	err := ns.DB.Select(&notifications, query, userId, audience, filters["unread"] == "true", limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching notifications: %w", err)
+	}
+
+	return notifications, nil
+}
+
+func (ns *NotificationService) GetUnreadCount(userId, audience string) (int, error) {
+	var count int
+	query := `SELECT COUNT(*) FROM notifications WHERE userid = $1 AND audience = $2 AND readat IS NULL`
+	err := ns.DB.Get(&count, query, userId, audience)
+	if err != nil {
+		return 0, fmt.Errorf("error counting unread notifications: %w", err)
+	}
+	return count, nil
+}
+
+// MarkRead marks one notification as read. Marking a read notification again is a no-op.
+func (ns *NotificationService) MarkRead(userId, audience string, notificationId int64) error {
+	query := `
+	UPDATE notifications
+	SET readat = COALESCE(readat, CURRENT_TIMESTAMP)
+	WHERE notificationid = $1 AND userid = $2 AND audience = $3
+	`
+	res, err := ns.DB.Exec(query, notificationId, userId, audience)
+	if err != nil {
+		return fmt.Errorf("failed to mark notification as read: %w", err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to mark notification as read: %w", err)
+	}
+	if affected == 0 {
+		return models.ErrNotFound
+	}
+	return nil
+}
+
+// MarkAllRead marks the whole inbox as read and returns how many notifications were unread
+func (ns *NotificationService) MarkAllRead(userId, audience string) (int64, error) {
+	query := `
+	UPDATE notifications
+	SET readat = CURRENT_TIMESTAMP
+	WHERE userid = $1 AND audience = $2 AND readat IS NULL
+	`
+	res, err := ns.DB.Exec(query, userId, audience)
+	if err != nil {
+		return 0, fmt.Errorf("failed to mark notifications as read: %w", err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to mark notifications as read: %w", err)
+	}
+	return affected, nil
+}
+
+func (ns *NotificationService) GetPreferences(userId string) (models.NotificationPreferences, error) {
+	return loadNotificationPreferences(ns.DB, userId)
+}
+
+// UpdatePreferences sets the given channel and category pairs and returns the full
+// preferences afterwards. Transactional messages have no preference.
+func (ns *NotificationService) UpdatePreferences(userId string, update models.NotificationPreferences) (_ models.NotificationPreferences, err error) {
+	if len(update) == 0 {
+		return nil, fmt.Errorf("no preferences to update")
+	}
+	for channel, categories := range update {
+		for category := range categories {
+			if err := helpers.ValidateNotificationPreference(channel, category); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	tx, err := ns.DB.Beginx()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		} else if err != nil {
+			tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
+
+	for channel, categories := range update {
+		for category, enabled := range categories {
+			err = setNotificationPreference(tx, userId, channel, category, enabled)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return loadNotificationPreferences(tx, userId)
+}
+
+// Unsubscribe turns off the emails of the category in a signed unsubscribe link. It
+// needs no login, and unsubscribing twice is not an error.
+func (ns *NotificationService) Unsubscribe(token string) (string, error) {
+	userId, category, err := helpers.ParseUnsubscribeToken(token)
+	if err != nil {
+		return "", err
+	}
+
+	err = setNotificationPreference(ns.DB, userId, helpers.ChannelEmail, category, false)
+	if err != nil {
+		return "", err
+	}
+
+	return category, nil
+}
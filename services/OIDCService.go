@@ -0,0 +1,410 @@
+package services
+
+import (
+	"crypto"
+	"database/sql"
+	"eCommerce/helpers"
+	"eCommerce/models"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	oidcStateTTL = 10 * time.Minute
+	// discovery documents and keys are cached this long
+	oidcCacheTTL = time.Hour
+	// an unknown kid triggers a key refetch at most this often
+	oidcKeyRefetchInterval = time.Minute
+)
+
+var oidcSigningMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}
+
+type oidcDiscovery struct {
+	Issuer                string   `json:"issuer"`
+	AuthorizationEndpoint string   `json:"authorization_endpoint"`
+	TokenEndpoint         string   `json:"token_endpoint"`
+	JWKSURI               string   `json:"jwks_uri"`
+	UserinfoEndpoint      string   `json:"userinfo_endpoint"`
+	CodeChallengeMethods  []string `json:"code_challenge_methods_supported"`
+}
+
+type oidcProvider struct {
+	config models.OIDCProviderConfig
+
+	mu            sync.Mutex
+	discovery     *oidcDiscovery
+	discoveredAt  time.Time
+	keys          map[string]crypto.PublicKey
+	keysFetchedAt time.Time
+}
+
+// OIDCService runs the authorization-code flow with PKCE against any OpenID Connect
+// issuer. It only proves who the user is at the provider; UserService.LoginWithIdentity
+// turns that into a local user and session.
+type OIDCService struct {
+	DB        *sqlx.DB
+	providers map[string]*oidcProvider
+	client    *http.Client
+}
+
+func NewOIDCService(db *sqlx.DB, configs []models.OIDCProviderConfig) *OIDCService {
+	providers := make(map[string]*oidcProvider, len(configs))
+	for _, config := range configs {
+		providers[config.Name] = &oidcProvider{config: config}
+	}
+
+	return &OIDCService{
+		DB:        db,
+		providers: providers,
+		client:    &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (ois *OIDCService) provider(name string) (*oidcProvider, error) {
+	provider, ok := ois.providers[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown login provider: %s", name)
+	}
+	return provider, nil
+}
+
+func (ois *OIDCService) getJSON(endpoint string, target any) error {
+	res, err := ois.client.Get(endpoint)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d from %s", res.StatusCode, endpoint)
+	}
+	return json.NewDecoder(res.Body).Decode(target)
+}
+
+func (ois *OIDCService) discover(provider *oidcProvider) (*oidcDiscovery, error) {
+	provider.mu.Lock()
+	defer provider.mu.Unlock()
+
+	if provider.discovery != nil && time.Since(provider.discoveredAt) < oidcCacheTTL {
+		return provider.discovery, nil
+	}
+
+	var discovery oidcDiscovery
+	if err := ois.getJSON(provider.config.DiscoveryURL, &discovery); err != nil {
+		return nil, fmt.Errorf("failed to fetch provider configuration: %w", err)
+	}
+	if discovery.Issuer == "" || discovery.AuthorizationEndpoint == "" || discovery.TokenEndpoint == "" || discovery.JWKSURI == "" {
+		return nil, fmt.Errorf("failed to use provider configuration: missing endpoints")
+	}
+	// Providers that don't list methods may still support PKCE, but one that lists
+	// others explicitly doesn't
+	if len(discovery.CodeChallengeMethods) > 0 && !slices.Contains(discovery.CodeChallengeMethods, "S256") {
+		return nil, fmt.Errorf("failed to use provider configuration: S256 PKCE is not supported")
+	}
+
+	provider.discovery = &discovery
+	provider.discoveredAt = time.Now()
+	return provider.discovery, nil
+}
+
+// signingKey returns the provider key for kid, refetching the JWKS when the key is
+// unknown so provider key rotation is picked up
+func (ois *OIDCService) signingKey(provider *oidcProvider, discovery *oidcDiscovery, kid string) (crypto.PublicKey, error) {
+	provider.mu.Lock()
+	defer provider.mu.Unlock()
+
+	if key, ok := provider.keys[kid]; ok && time.Since(provider.keysFetchedAt) < oidcCacheTTL {
+		return key, nil
+	}
+	if provider.keys != nil && time.Since(provider.keysFetchedAt) < oidcKeyRefetchInterval {
+		return nil, fmt.Errorf("unknown signing key")
+	}
+
+	res, err := ois.client.Get(discovery.JWKSURI)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch provider keys: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch provider keys: status %d", res.StatusCode)
+	}
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read provider keys: %w", err)
+	}
+	keys, err := helpers.ParseJWKS(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse provider keys: %w", err)
+	}
+
+	provider.keys = keys
+	provider.keysFetchedAt = time.Now()
+
+	key, ok := keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key")
+	}
+	return key, nil
+}
+
+// StartLogin stores a state, nonce and PKCE verifier and returns the provider URL to
+// send the browser to, along with the state so it can also be bound to the browser
+func (ois *OIDCService) StartLogin(providerName string) (authURL string, state string, err error) {
+	provider, err := ois.provider(providerName)
+	if err != nil {
+		return "", "", err
+	}
+
+	discovery, err := ois.discover(provider)
+	if err != nil {
+		return "", "", err
+	}
+
+	state, err = helpers.GenerateSecureToken()
+	if err != nil {
+		return "", "", fmt.Errorf("failed to generate state: %w", err)
+	}
+	nonce, err := helpers.GenerateSecureToken()
+	if err != nil {
+		return "", "", fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	verifier, err := helpers.GeneratePKCEVerifier()
+	if err != nil {
+		return "", "", fmt.Errorf("failed to generate code verifier: %w", err)
+	}
+
+	insertQuery := `
+	INSERT INTO oidc_login_states (state_hash, provider, code_verifier, nonce, expiresat)
+	VALUES ($1, $2, $3, $4, $5)
+	`
+	_, err = ois.DB.Exec(insertQuery, helpers.HashToken(state), providerName, verifier, nonce, time.Now().Add(oidcStateTTL))
+	if err != nil {
+		return "", "", fmt.Errorf("failed to store login state: %w", err)
+	}
+
+	authURL, err = authorizationURL(provider, discovery, state, nonce, verifier)
+	if err != nil {
+		return "", "", err
+	}
+
+	return authURL, state, nil
+}
+
+func authorizationURL(provider *oidcProvider, discovery *oidcDiscovery, state, nonce, verifier string) (string, error) {
+	authURL, err := url.Parse(discovery.AuthorizationEndpoint)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse authorization endpoint: %w", err)
+	}
+	query := authURL.Query()
+	query.Set("response_type", "code")
+	query.Set("client_id", provider.config.ClientID)
+	query.Set("redirect_uri", provider.config.RedirectURL)
+	query.Set("scope", "openid email profile")
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", helpers.PKCEChallenge(verifier))
+	query.Set("code_challenge_method", "S256")
+	authURL.RawQuery = query.Encode()
+
+	return authURL.String(), nil
+}
+
+// consumeState deletes the state so each one can only be used once
+func (ois *OIDCService) consumeState(providerName, state string) (*models.OIDCLoginState, error) {
+	var loginState models.OIDCLoginState
+	query := `DELETE FROM oidc_login_states WHERE state_hash = $1 AND provider = $2 RETURNING *`
+	err := ois.DB.Get(&loginState, query, helpers.HashToken(state), providerName)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("invalid or expired login state")
+		}
+		return nil, fmt.Errorf("failed to fetch login state: %w", err)
+	}
+
+	if time.Now().After(loginState.ExpiresAt) {
+		return nil, fmt.Errorf("invalid or expired login state")
+	}
+
+	return &loginState, nil
+}
+
+type oidcTokens struct {
+	IDToken     string `json:"id_token"`
+	AccessToken string `json:"access_token"`
+}
+
+func (ois *OIDCService) exchangeCode(provider *oidcProvider, discovery *oidcDiscovery, code, verifier string) (*oidcTokens, error) {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", provider.config.RedirectURL)
+	form.Set("client_id", provider.config.ClientID)
+	form.Set("code_verifier", verifier)
+	if provider.config.ClientSecret != "" {
+		form.Set("client_secret", provider.config.ClientSecret)
+	}
+
+	res, err := ois.client.PostForm(discovery.TokenEndpoint, form)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach login provider: %w", err)
+	}
+	defer res.Body.Close()
+
+	var tokenResponse struct {
+		oidcTokens
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&tokenResponse); err != nil {
+		return nil, fmt.Errorf("failed to decode token response: %w", err)
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("login provider rejected the code: %s %s", tokenResponse.Error, tokenResponse.ErrorDescription)
+	}
+	if tokenResponse.IDToken == "" {
+		return nil, fmt.Errorf("login provider returned no id token")
+	}
+
+	return &tokenResponse.oidcTokens, nil
+}
+
+// fetchUserinfo reads the claims some providers leave out of the ID token. They must
+// be about the same subject.
+func (ois *OIDCService) fetchUserinfo(discovery *oidcDiscovery, accessToken, subject string) (map[string]any, error) {
+	req, err := http.NewRequest(http.MethodGet, discovery.UserinfoEndpoint, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build userinfo request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+
+	res, err := ois.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach login provider: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch userinfo: status %d", res.StatusCode)
+	}
+	var claims map[string]any
+	if err := json.NewDecoder(res.Body).Decode(&claims); err != nil {
+		return nil, fmt.Errorf("failed to decode userinfo: %w", err)
+	}
+
+	if claims["sub"] != subject {
+		return nil, fmt.Errorf("invalid userinfo: subject mismatch")
+	}
+	return claims, nil
+}
+
+// verifyIDToken checks the signature against the provider's keys and the iss, aud, exp
+// and nonce claims
+func (ois *OIDCService) verifyIDToken(provider *oidcProvider, discovery *oidcDiscovery, idToken, nonce string) (jwt.MapClaims, error) {
+	token, err := jwt.Parse(idToken, func(token *jwt.Token) (any, error) {
+		kid, _ := token.Header["kid"].(string)
+		return ois.signingKey(provider, discovery, kid)
+	},
+		jwt.WithValidMethods(oidcSigningMethods),
+		jwt.WithIssuer(discovery.Issuer),
+		jwt.WithAudience(provider.config.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(time.Minute),
+	)
+	if err != nil {
+		if strings.Contains(err.Error(), "failed to") {
+			return nil, err
+		}
+		return nil, fmt.Errorf("invalid id token: %w", err)
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, fmt.Errorf("invalid id token claims")
+	}
+
+	if claims["nonce"] != nonce {
+		return nil, fmt.Errorf("invalid id token: nonce mismatch")
+	}
+	// With several audiences the token must have been issued to us
+	if azp, ok := claims["azp"].(string); ok && azp != provider.config.ClientID {
+		return nil, fmt.Errorf("invalid id token: wrong authorized party")
+	}
+
+	return claims, nil
+}
+
+// CompleteLogin handles the provider callback and returns the verified identity
+func (ois *OIDCService) CompleteLogin(providerName, code, state string) (*models.OIDCIdentity, error) {
+	provider, err := ois.provider(providerName)
+	if err != nil {
+		return nil, err
+	}
+
+	loginState, err := ois.consumeState(providerName, state)
+	if err != nil {
+		return nil, err
+	}
+
+	return ois.identityFromCode(provider, code, loginState)
+}
+
+// identityFromCode redeems the code with the verifier and nonce of the login state
+func (ois *OIDCService) identityFromCode(provider *oidcProvider, code string, loginState *models.OIDCLoginState) (*models.OIDCIdentity, error) {
+	discovery, err := ois.discover(provider)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens, err := ois.exchangeCode(provider, discovery, code, loginState.CodeVerifier)
+	if err != nil {
+		return nil, err
+	}
+
+	claims, err := ois.verifyIDToken(provider, discovery, tokens.IDToken, loginState.Nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	subject, _ := claims["sub"].(string)
+	if subject == "" {
+		return nil, fmt.Errorf("invalid id token: missing subject")
+	}
+
+	// Without an email in the ID token, ask the userinfo endpoint
+	if _, ok := claims["email"].(string); !ok && discovery.UserinfoEndpoint != "" && tokens.AccessToken != "" {
+		claims, err = ois.fetchUserinfo(discovery, tokens.AccessToken, subject)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	identity := &models.OIDCIdentity{
+		Provider: provider.config.Name,
+		Subject:  subject,
+	}
+	identity.Email, _ = claims["email"].(string)
+	identity.Name, _ = claims["name"].(string)
+	// some providers send email_verified as a string
+	switch verified := claims["email_verified"].(type) {
+	case bool:
+		identity.EmailVerified = verified
+	case string:
+		identity.EmailVerified = verified == "true"
+	}
+
+	return identity, nil
+}
@@ -0,0 +1,521 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"eCommerce/helpers"
+	"eCommerce/models"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq"
+)
+
+const (
+	testClientID    = "shop-client"
+	testRedirectURL = "http://localhost:8000/account/oidc/callback?provider=mock"
+	testKeyId       = "mock-key"
+)
+
+// mockIssuer is an OpenID Connect provider serving discovery, JWKS, token and userinfo
+// endpoints. Codes are handed out by authorize, which plays the provider's login page.
+type mockIssuer struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+
+	mu    sync.Mutex
+	codes map[string]mockAuthorization
+	// access token -> userinfo claims
+	userinfo map[string]jwt.MapClaims
+}
+
+type mockAuthorization struct {
+	challenge string
+	claims    jwt.MapClaims
+	userinfo  jwt.MapClaims
+}
+
+func newMockIssuer(t *testing.T) *mockIssuer {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generating key: %v", err)
+	}
+
+	issuer := &mockIssuer{
+		key:      key,
+		codes:    map[string]mockAuthorization{},
+		userinfo: map[string]jwt.MapClaims{},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"issuer":                           issuer.URL,
+			"authorization_endpoint":           issuer.URL + "/authorize",
+			"token_endpoint":                   issuer.URL + "/token",
+			"jwks_uri":                         issuer.URL + "/jwks",
+			"userinfo_endpoint":                issuer.URL + "/userinfo",
+			"code_challenge_methods_supported": []string{"S256"},
+		})
+	})
+	mux.HandleFunc("GET /jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(helpers.JWKS{Keys: []helpers.JWK{{
+			Kty: "RSA",
+			Kid: testKeyId,
+			Alg: "RS256",
+			N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("POST /token", issuer.token)
+	mux.HandleFunc("GET /userinfo", func(w http.ResponseWriter, r *http.Request) {
+		issuer.mu.Lock()
+		claims, ok := issuer.userinfo[strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")]
+		issuer.mu.Unlock()
+		if !ok {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(claims)
+	})
+
+	issuer.Server = httptest.NewServer(mux)
+	t.Cleanup(issuer.Close)
+	return issuer
+}
+
+func (mi *mockIssuer) token(w http.ResponseWriter, r *http.Request) {
+	tokenError := func(code string) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": code})
+	}
+
+	if r.PostFormValue("grant_type") != "authorization_code" || r.PostFormValue("client_id") != testClientID ||
+		r.PostFormValue("redirect_uri") != testRedirectURL {
+		tokenError("invalid_request")
+		return
+	}
+
+	mi.mu.Lock()
+	code := r.PostFormValue("code")
+	authorization, ok := mi.codes[code]
+	delete(mi.codes, code)
+	mi.mu.Unlock()
+	if !ok || helpers.PKCEChallenge(r.PostFormValue("code_verifier")) != authorization.challenge {
+		tokenError("invalid_grant")
+		return
+	}
+
+	accessToken := rand.Text()
+	mi.mu.Lock()
+	mi.userinfo[accessToken] = authorization.userinfo
+	mi.mu.Unlock()
+
+	json.NewEncoder(w).Encode(map[string]string{
+		"id_token":     mi.sign(authorization.claims),
+		"access_token": accessToken,
+		"token_type":   "Bearer",
+	})
+}
+
+func (mi *mockIssuer) sign(claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = testKeyId
+	signed, err := token.SignedString(mi.key)
+	if err != nil {
+		panic(err)
+	}
+	return signed
+}
+
+// idClaims are valid ID token claims for the subject
+func (mi *mockIssuer) idClaims(subject, nonce string) jwt.MapClaims {
+	now := time.Now()
+	return jwt.MapClaims{
+		"iss":            mi.URL,
+		"aud":            testClientID,
+		"sub":            subject,
+		"nonce":          nonce,
+		"iat":            now.Unix(),
+		"exp":            now.Add(5 * time.Minute).Unix(),
+		"email":          subject + "@example.com",
+		"email_verified": true,
+		"name":           "Test User",
+	}
+}
+
+// authorize logs the user in at the provider and returns the code the browser would be
+// redirected back with. edit can change the claims of the ID token.
+func (mi *mockIssuer) authorize(t *testing.T, authURL, subject string, edit func(claims, userinfo jwt.MapClaims)) string {
+	t.Helper()
+	parsed, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatalf("parsing authorization url: %v", err)
+	}
+	query := parsed.Query()
+	if query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "" {
+		t.Fatalf("authorization url without an S256 code challenge: %s", authURL)
+	}
+	if query.Get("client_id") != testClientID || query.Get("redirect_uri") != testRedirectURL || query.Get("state") == "" {
+		t.Fatalf("unexpected authorization url: %s", authURL)
+	}
+
+	claims := mi.idClaims(subject, query.Get("nonce"))
+	userinfo := jwt.MapClaims{"sub": subject}
+	if edit != nil {
+		edit(claims, userinfo)
+	}
+
+	code := rand.Text()
+	mi.mu.Lock()
+	mi.codes[code] = mockAuthorization{challenge: query.Get("code_challenge"), claims: claims, userinfo: userinfo}
+	mi.mu.Unlock()
+	return code
+}
+
+func newTestOIDCService(db *sqlx.DB, issuer *mockIssuer) *OIDCService {
+	return NewOIDCService(db, []models.OIDCProviderConfig{{
+		Name:         "mock",
+		DiscoveryURL: issuer.URL + "/.well-known/openid-configuration",
+		ClientID:     testClientID,
+		RedirectURL:  testRedirectURL,
+	}})
+}
+
+// startTestLogin builds the authorization URL like StartLogin, without storing the state
+func startTestLogin(t *testing.T, ois *OIDCService) (*oidcProvider, string, *models.OIDCLoginState) {
+	t.Helper()
+	provider, err := ois.provider("mock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	discovery, err := ois.discover(provider)
+	if err != nil {
+		t.Fatalf("discover: %v", err)
+	}
+
+	verifier, err := helpers.GeneratePKCEVerifier()
+	if err != nil {
+		t.Fatal(err)
+	}
+	loginState := &models.OIDCLoginState{Provider: "mock", CodeVerifier: verifier, Nonce: rand.Text()}
+	authURL, err := authorizationURL(provider, discovery, rand.Text(), loginState.Nonce, verifier)
+	if err != nil {
+		t.Fatalf("authorizationURL: %v", err)
+	}
+	return provider, authURL, loginState
+}
+
+func TestOIDCIdentityFromCode(t *testing.T) {
+	issuer := newMockIssuer(t)
+	ois := newTestOIDCService(nil, issuer)
+	provider, authURL, loginState := startTestLogin(t, ois)
+
+	code := issuer.authorize(t, authURL, "alice", nil)
+	identity, err := ois.identityFromCode(provider, code, loginState)
+	if err != nil {
+		t.Fatalf("identityFromCode: %v", err)
+	}
+
+	want := models.OIDCIdentity{Provider: "mock", Subject: "alice", Email: "alice@example.com", EmailVerified: true, Name: "Test User"}
+	if *identity != want {
+		t.Fatalf("identity = %+v, want %+v", *identity, want)
+	}
+
+	// Codes are single use
+	if _, err := ois.identityFromCode(provider, code, loginState); err == nil {
+		t.Fatalf("a code was redeemed twice")
+	}
+}
+
+func TestOIDCExchangeRequiresCodeVerifier(t *testing.T) {
+	issuer := newMockIssuer(t)
+	ois := newTestOIDCService(nil, issuer)
+	provider, authURL, loginState := startTestLogin(t, ois)
+
+	code := issuer.authorize(t, authURL, "alice", nil)
+	stolen := *loginState
+	stolen.CodeVerifier, _ = helpers.GeneratePKCEVerifier()
+
+	_, err := ois.identityFromCode(provider, code, &stolen)
+	if err == nil || !strings.Contains(err.Error(), "invalid_grant") {
+		t.Fatalf("code redeemed with the wrong verifier: %v", err)
+	}
+}
+
+func TestOIDCVerifyIDToken(t *testing.T) {
+	issuer := newMockIssuer(t)
+	ois := newTestOIDCService(nil, issuer)
+
+	tests := []struct {
+		name    string
+		edit    func(claims jwt.MapClaims)
+		wantErr string
+	}{
+		{"valid", nil, ""},
+		{"nonce mismatch", func(claims jwt.MapClaims) { claims["nonce"] = "replayed" }, "nonce mismatch"},
+		{"missing nonce", func(claims jwt.MapClaims) { delete(claims, "nonce") }, "nonce mismatch"},
+		{"other issuer", func(claims jwt.MapClaims) { claims["iss"] = "https://evil.example.com" }, "invalid id token"},
+		{"other audience", func(claims jwt.MapClaims) { claims["aud"] = "other-client" }, "invalid id token"},
+		{"other authorized party", func(claims jwt.MapClaims) {
+			claims["aud"] = []string{testClientID, "other-client"}
+			claims["azp"] = "other-client"
+		}, "wrong authorized party"},
+		{"expired", func(claims jwt.MapClaims) { claims["exp"] = time.Now().Add(-time.Hour).Unix() }, "invalid id token"},
+		{"no expiry", func(claims jwt.MapClaims) { delete(claims, "exp") }, "invalid id token"},
+		{"missing subject", func(claims jwt.MapClaims) { delete(claims, "sub") }, "missing subject"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider, authURL, loginState := startTestLogin(t, ois)
+			code := issuer.authorize(t, authURL, "alice", func(claims, _ jwt.MapClaims) {
+				if tt.edit != nil {
+					tt.edit(claims)
+				}
+			})
+
+			_, err := ois.identityFromCode(provider, code, loginState)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Fatalf("got error %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestOIDCVerifyIDTokenRejectsForeignSignatures(t *testing.T) {
+	issuer := newMockIssuer(t)
+	ois := newTestOIDCService(nil, issuer)
+	provider, _ := ois.provider("mock")
+	discovery, err := ois.discover(provider)
+	if err != nil {
+		t.Fatalf("discover: %v", err)
+	}
+
+	claims := issuer.idClaims("alice", "nonce")
+
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	forged.Header["kid"] = testKeyId
+	forgedToken, _ := forged.SignedString(otherKey)
+
+	// HMAC with the public modulus as secret, the classic algorithm confusion
+	hmac := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	hmac.Header["kid"] = testKeyId
+	hmacToken, _ := hmac.SignedString(issuer.key.N.Bytes())
+
+	unknownKid := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	unknownKid.Header["kid"] = "rotated-away"
+	unknownKidToken, _ := unknownKid.SignedString(issuer.key)
+
+	for name, token := range map[string]string{"forged": forgedToken, "hmac": hmacToken, "unknown kid": unknownKidToken} {
+		if _, err := ois.verifyIDToken(provider, discovery, token, "nonce"); err == nil {
+			t.Errorf("%s token was accepted", name)
+		}
+	}
+}
+
+func TestOIDCUserinfoFallback(t *testing.T) {
+	issuer := newMockIssuer(t)
+	ois := newTestOIDCService(nil, issuer)
+
+	provider, authURL, loginState := startTestLogin(t, ois)
+	code := issuer.authorize(t, authURL, "bob", func(claims, userinfo jwt.MapClaims) {
+		delete(claims, "email")
+		delete(claims, "email_verified")
+		userinfo["email"] = "bob@example.com"
+		userinfo["email_verified"] = "true"
+	})
+	identity, err := ois.identityFromCode(provider, code, loginState)
+	if err != nil {
+		t.Fatalf("identityFromCode: %v", err)
+	}
+	if identity.Email != "bob@example.com" || !identity.EmailVerified {
+		t.Fatalf("identity = %+v, want the userinfo email", *identity)
+	}
+
+	// Userinfo about someone else is refused
+	provider, authURL, loginState = startTestLogin(t, ois)
+	code = issuer.authorize(t, authURL, "bob", func(claims, userinfo jwt.MapClaims) {
+		delete(claims, "email")
+		userinfo["sub"] = "mallory"
+		userinfo["email"] = "mallory@example.com"
+	})
+	if _, err := ois.identityFromCode(provider, code, loginState); err == nil || !strings.Contains(err.Error(), "subject mismatch") {
+		t.Fatalf("got %v, want a subject mismatch", err)
+	}
+}
+
+// testDB connects to TEST_POSTGRES_DSN, a database with the schema from docs/ applied.
+// Tests needing it are skipped without one.
+func testDB(t *testing.T) *sqlx.DB {
+	t.Helper()
+	dsn := os.Getenv("TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("TEST_POSTGRES_DSN is not set")
+	}
+	db, err := sqlx.Connect("postgres", dsn)
+	if err != nil {
+		t.Fatalf("connecting to the test database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestOIDCLoginState(t *testing.T) {
+	db := testDB(t)
+	issuer := newMockIssuer(t)
+	ois := newTestOIDCService(db, issuer)
+
+	authURL, state, err := ois.StartLogin("mock")
+	if err != nil {
+		t.Fatalf("StartLogin: %v", err)
+	}
+	parsed, _ := url.Parse(authURL)
+	if parsed.Query().Get("state") != state {
+		t.Fatalf("the returned state is not the one sent to the provider")
+	}
+
+	code := issuer.authorize(t, authURL, "carol", nil)
+	if _, err := ois.CompleteLogin("mock", code, "unknown-state"); err == nil {
+		t.Fatalf("an unknown state was accepted")
+	}
+	identity, err := ois.CompleteLogin("mock", code, state)
+	if err != nil {
+		t.Fatalf("CompleteLogin: %v", err)
+	}
+	if identity.Subject != "carol" {
+		t.Fatalf("identity = %+v", *identity)
+	}
+
+	// A state is consumed by its first use
+	code = issuer.authorize(t, authURL, "carol", nil)
+	if _, err := ois.CompleteLogin("mock", code, state); err == nil || !strings.Contains(err.Error(), "invalid or expired login state") {
+		t.Fatalf("a state was used twice: %v", err)
+	}
+
+	// Expired states are refused
+	authURL, state, err = ois.StartLogin("mock")
+	if err != nil {
+		t.Fatalf("StartLogin: %v", err)
+	}
+	if _, err := db.Exec(`UPDATE oidc_login_states SET expiresat = $1 WHERE state_hash = $2`, time.Now().Add(-time.Minute), helpers.HashToken(state)); err != nil {
+		t.Fatal(err)
+	}
+	code = issuer.authorize(t, authURL, "carol", nil)
+	if _, err := ois.CompleteLogin("mock", code, state); err == nil || !strings.Contains(err.Error(), "invalid or expired login state") {
+		t.Fatalf("an expired state was accepted: %v", err)
+	}
+}
+
+func createTestUser(t *testing.T, db *sqlx.DB, email string, verified bool) *models.User {
+	t.Helper()
+	user := &models.User{}
+	query := `
+	INSERT INTO users (userid, name, email, password, phone_number, verified, verification_token, role)
+	VALUES (gen_random_uuid(), 'Existing', $1, 'password-hash', '', $2, '', $3)
+	RETURNING *
+	`
+	if err := db.Get(user, query, email, verified, helpers.RoleCustomer); err != nil {
+		t.Fatalf("creating user: %v", err)
+	}
+	return user
+}
+
+func TestLinkIdentity(t *testing.T) {
+	db := testDB(t)
+	us := &UserService{DB: db}
+	suffix := strings.ToLower(rand.Text())
+	email := func(name string) string { return name + "-" + suffix + "@example.com" }
+	t.Cleanup(func() {
+		db.Exec(`DELETE FROM users WHERE email LIKE $1`, "%-"+suffix+"@example.com")
+	})
+
+	identity := func(subject, email string, verified bool) *models.OIDCIdentity {
+		return &models.OIDCIdentity{Provider: "mock", Subject: subject + "-" + suffix, Email: email, EmailVerified: verified, Name: "Linked"}
+	}
+	identityOwner := func(identity *models.OIDCIdentity) string {
+		var userId string
+		err := db.Get(&userId, `SELECT userid FROM user_identities WHERE provider = $1 AND subject = $2`, identity.Provider, identity.Subject)
+		if err != nil {
+			t.Fatalf("fetching identity: %v", err)
+		}
+		return userId
+	}
+
+	t.Run("links to the verified account with the email", func(t *testing.T) {
+		existing := createTestUser(t, db, email("dave"), true)
+		linked, err := us.linkIdentity(identity("dave", existing.Email, true))
+		if err != nil {
+			t.Fatalf("linkIdentity: %v", err)
+		}
+		if linked.UserId != existing.UserId || linked.Password != "password-hash" {
+			t.Fatalf("linked to %+v, want the existing account with its password", linked)
+		}
+		if owner := identityOwner(identity("dave", "", true)); owner != existing.UserId.String() {
+			t.Fatalf("identity belongs to %s, want %s", owner, existing.UserId)
+		}
+	})
+
+	t.Run("verifies an unverified account and drops its password", func(t *testing.T) {
+		existing := createTestUser(t, db, email("erin"), false)
+		linked, err := us.linkIdentity(identity("erin", existing.Email, true))
+		if err != nil {
+			t.Fatalf("linkIdentity: %v", err)
+		}
+		var stored models.User
+		if err := db.Get(&stored, `SELECT * FROM users WHERE userid = $1`, existing.UserId); err != nil {
+			t.Fatal(err)
+		}
+		if linked.UserId != existing.UserId || !stored.Verified || stored.Password != "" {
+			t.Fatalf("stored user = %+v, want it verified without a password", stored)
+		}
+	})
+
+	t.Run("provisions a customer with a cart", func(t *testing.T) {
+		newIdentity := identity("frank", email("frank"), true)
+		user, err := us.linkIdentity(newIdentity)
+		if err != nil {
+			t.Fatalf("linkIdentity: %v", err)
+		}
+		if user.Email != newIdentity.Email || user.Name != "Linked" || !user.Verified || user.Password != "" || user.Role != helpers.RoleCustomer {
+			t.Fatalf("provisioned %+v", user)
+		}
+		var carts int
+		if err := db.Get(&carts, `SELECT COUNT(*) FROM carts WHERE userid = $1`, user.UserId); err != nil || carts != 1 {
+			t.Fatalf("user has %d carts (%v), want 1", carts, err)
+		}
+		if owner := identityOwner(newIdentity); owner != user.UserId.String() {
+			t.Fatalf("identity belongs to %s, want %s", owner, user.UserId)
+		}
+	})
+
+	t.Run("refuses an unverified email", func(t *testing.T) {
+		existing := createTestUser(t, db, email("grace"), true)
+		if _, err := us.linkIdentity(identity("grace", existing.Email, false)); err == nil {
+			t.Fatalf("linked an identity without a verified email to %s", existing.Email)
+		}
+		var identities int
+		db.Get(&identities, `SELECT COUNT(*) FROM user_identities WHERE userid = $1`, existing.UserId)
+		if identities != 0 {
+			t.Fatalf("the account has %d identities, want none", identities)
+		}
+	})
+}
@@ -2,21 +2,34 @@ package services
 
 import (
 	"database/sql"
+	"eCommerce/helpers"
 	"eCommerce/models"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/jmoiron/sqlx"
 )
 
+// Statuses an order moves through after checkout
+var OrderStatuses = []string{"processing", "shipping", "delivery", "delivered"}
+
 type OrderService struct {
-	DB *sqlx.DB
+	DB  *sqlx.DB
+	ws  WebhookService
+	ns  NotificationService
+	obs OutboxService
 }
 
-func NewOrderService(db *sqlx.DB) *OrderService {
+func NewOrderService(db *sqlx.DB, ws WebhookService, ns NotificationService, obs OutboxService) *OrderService {
 	return &OrderService{
 		db,
+		ws,
+		ns,
+		obs,
 	}
 }
 
@@ -55,6 +68,9 @@ func (os *OrderService) ViewOrders(userId string) ([]*models.Order, error) {
 			o.total_price,
 			o.orderedat,
 			p.productid,
+			v.variantid,
+			v.sku,
+			v.options,
 			p.name,
 			p.description,
 			p.brand,
@@ -63,19 +79,23 @@ func (os *OrderService) ViewOrders(userId string) ([]*models.Order, error) {
 		FROM orders o
 		JOIN order_items oi ON o.orderid = oi.orderid
 		JOIN products p ON p.productid = oi.productid
+		JOIN product_variants v ON v.variantid = oi.variantid
 		WHERE o.userid = $1
 		ORDER BY o.orderedat DESC
 	`
 	type row struct {
-		OrderId     string    `db:"orderid"`
-		TotalPrice  float64   `db:"total_price"`
-		OrderedAt   time.Time `db:"orderedat"`
-		ProductId   string    `db:"productid"`
-		Name        string    `db:"name"`
-		Description string    `db:"description"`
-		Brand       string    `db:"brand"`
-		Category    string    `db:"category"`
-		Quantity    int       `db:"quantity"`
+		OrderId     string          `db:"orderid"`
+		TotalPrice  float64         `db:"total_price"`
+		OrderedAt   time.Time       `db:"orderedat"`
+		ProductId   string          `db:"productid"`
+		VariantId   string          `db:"variantid"`
+		SKU         string          `db:"sku"`
+		Options     json.RawMessage `db:"options"`
+		Name        string          `db:"name"`
+		Description string          `db:"description"`
+		Brand       string          `db:"brand"`
+		Category    string          `db:"category"`
+		Quantity    int             `db:"quantity"`
 	}
 
 	var rows []row
@@ -96,6 +116,9 @@ func (os *OrderService) ViewOrders(userId string) ([]*models.Order, error) {
 
 		orderMap[r.OrderId].Items = append(orderMap[r.OrderId].Items, &models.OrderItem{
 			ProductId:   r.ProductId,
+			VariantId:   r.VariantId,
+			SKU:         r.SKU,
+			Options:     r.Options,
 			Name:        r.Name,
 			Description: r.Description,
 			Brand:       r.Brand,
@@ -110,3 +133,165 @@ func (os *OrderService) ViewOrders(userId string) ([]*models.Order, error) {
 	}
 	return orders, nil
 }
+
+// loadVendorOrders splits an order by vendor, so each vendor's webhook only lists its
+// own items
+func loadVendorOrders(db sqlx.Ext, orderId string) (map[string]*models.Order, error) {
+	query := `
+		SELECT
+			o.orderid,
+			o.total_price,
+			o.orderedat,
+			p.vendorid,
+			p.productid,
+			v.variantid,
+			v.sku,
+			v.options,
+			p.name,
+			p.description,
+			p.brand,
+			p.category,
+			oi.quantity
+		FROM orders o
+		JOIN order_items oi ON o.orderid = oi.orderid
+		JOIN products p ON p.productid = oi.productid
+		JOIN product_variants v ON v.variantid = oi.variantid
+		WHERE o.orderid = $1
+	`
+	type row struct {
+		OrderId     string          `db:"orderid"`
+		TotalPrice  float64         `db:"total_price"`
+		OrderedAt   time.Time       `db:"orderedat"`
+		VendorId    string          `db:"vendorid"`
+		ProductId   string          `db:"productid"`
+		VariantId   string          `db:"variantid"`
+		SKU         string          `db:"sku"`
+		Options     json.RawMessage `db:"options"`
+		Name        string          `db:"name"`
+		Description string          `db:"description"`
+		Brand       string          `db:"brand"`
+		Category    string          `db:"category"`
+		Quantity    int             `db:"quantity"`
+	}
+
+	var rows []row
+	if err := sqlx.Select(db, &rows, query, orderId); err != nil {
+		return nil, fmt.Errorf("error fetching order: %w", err)
+	}
+
+	vendorOrders := make(map[string]*models.Order)
+	for _, r := range rows {
+		if _, exists := vendorOrders[r.VendorId]; !exists {
+			vendorOrders[r.VendorId] = &models.Order{
+				OrderId:    r.OrderId,
+				TotalPrice: r.TotalPrice,
+				OrderedAt:  r.OrderedAt,
+				Items:      []*models.OrderItem{},
+			}
+		}
+
+		vendorOrders[r.VendorId].Items = append(vendorOrders[r.VendorId].Items, &models.OrderItem{
+			ProductId:   r.ProductId,
+			VariantId:   r.VariantId,
+			SKU:         r.SKU,
+			Options:     r.Options,
+			Name:        r.Name,
+			Description: r.Description,
+			Brand:       r.Brand,
+			Category:    r.Category,
+			Quantity:    r.Quantity,
+		})
+	}
+
+	return vendorOrders, nil
+}
+
+// UpdateOrderStatus moves an order to another status and notifies the vendors whose
+// products are in it
+func (os *OrderService) UpdateOrderStatus(orderId, status string) (err error) {
+	if !slices.Contains(OrderStatuses, status) {
+		return fmt.Errorf("invalid status, use one of %s", strings.Join(OrderStatuses, ", "))
+	}
+
+	tx, err := os.DB.Beginx()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		} else if err != nil {
+			tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
+
+	var current struct {
+		UserId string `db:"userid"`
+		Email  string `db:"email"`
+		Status string `db:"status"`
+	}
+	currentQuery := `
+	SELECT o.userid, u.email, o.status
+	FROM orders o
+	JOIN users u ON u.userid = o.userid
+	WHERE o.orderid = $1
+	FOR UPDATE OF o
+	`
+	err = tx.Get(&current, currentQuery, orderId)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.ErrNotFound
+		}
+		return fmt.Errorf("error fetching order status: %w", err)
+	}
+	previousStatus := current.Status
+	if previousStatus == status {
+		return fmt.Errorf("order is already %s", status)
+	}
+
+	_, err = tx.Exec(`UPDATE orders SET status = $1 WHERE orderid = $2`, status, orderId)
+	if err != nil {
+		return fmt.Errorf("failed to update order status: %w", err)
+	}
+
+	vendorOrders, err := loadVendorOrders(tx, orderId)
+	if err != nil {
+		return err
+	}
+	for vendorId, order := range vendorOrders {
+		change := &models.OrderStatusChange{Order: order, PreviousStatus: previousStatus, Status: status}
+		err = os.ws.Publish(tx, vendorId, WebhookOrderStatusChanged, change)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = recordOrderStatusEvent(tx, orderId, current.UserId, &previousStatus, status)
+	if err != nil {
+		return err
+	}
+
+	// Skipped by the outbox when the customer turned off order update emails
+	email, err := helpers.OrderStatusEmail(current.UserId, current.Email, orderId, status)
+	if err != nil {
+		return fmt.Errorf("error rendering order status email: %w", err)
+	}
+	err = os.obs.EnqueueEmail(tx, email)
+	if err != nil {
+		return err
+	}
+
+	return os.ns.Notify(tx, &models.NotificationEntry{
+		UserId:   current.UserId,
+		Audience: NotificationAudienceCustomer,
+		Type:     NotificationOrderStatusChanged,
+		Category: helpers.CategoryOrderUpdates,
+		Title:    "Order update",
+		Body:     fmt.Sprintf("Your order %s.", helpers.OrderStatusDescriptions[status]),
+		Data:     map[string]any{"orderId": orderId, "status": status},
+	})
+}
@@ -0,0 +1,171 @@
+package services
+
+import (
+	"eCommerce/models"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+const (
+	// Postgres channel order status events are announced on. The payload is the userid.
+	orderEventsChannel = "order_status_events"
+)
+
+// OrderEventsBatchSize is the most events GetEventsSince returns at once
+const OrderEventsBatchSize = 100
+
+// recordOrderStatusEvent appends to the order's status history and announces it with
+// NOTIFY. Both are part of the caller's transaction, so listeners only hear about
+// committed changes.
+func recordOrderStatusEvent(db sqlx.Ext, orderId, userId string, previousStatus *string, status string) error {
+	// Event ids are taken at insert but become visible at commit. Holding a per-user lock
+	// until commit makes a user's events visible in id order, so a stream that has sent
+	// an id never misses a smaller one committed later.
+	_, err := db.Exec(`SELECT pg_advisory_xact_lock(hashtext($1))`, userId)
+	if err != nil {
+		return fmt.Errorf("failed to lock order events: %w", err)
+	}
+
+	insertQuery := `
+	INSERT INTO order_status_events (orderid, userid, previous_status, status)
+	VALUES ($1, $2, $3, $4)
+	`
+	_, err = db.Exec(insertQuery, orderId, userId, previousStatus, status)
+	if err != nil {
+		return fmt.Errorf("failed to record order status event: %w", err)
+	}
+
+	_, err = db.Exec(`SELECT pg_notify($1, $2)`, orderEventsChannel, userId)
+	if err != nil {
+		return fmt.Errorf("failed to announce order status event: %w", err)
+	}
+
+	return nil
+}
+
+// OrderStreamService fans order status changes out to the open streams of this
+// instance. Every instance LISTENs on orderEventsChannel, so a change made through any
+// instance reaches streams on all of them. Notifications only wake the streams up; the
+// events themselves are read from order_status_events, which is also how a client
+// resumes after reconnecting.
+type OrderStreamService struct {
+	DB  *sqlx.DB
+	dsn string
+
+	mu          *sync.Mutex
+	subscribers map[string]map[chan struct{}]struct{}
+}
+
+func NewOrderStreamService(db *sqlx.DB, dsn string) *OrderStreamService {
+	return &OrderStreamService{
+		DB:          db,
+		dsn:         dsn,
+		mu:          &sync.Mutex{},
+		subscribers: map[string]map[chan struct{}]struct{}{},
+	}
+}
+
+// Subscribe returns a channel that receives a value whenever the user may have new
+// events, and a function that ends the subscription
+func (oss *OrderStreamService) Subscribe(userId string) (<-chan struct{}, func()) {
+	wake := make(chan struct{}, 1)
+
+	oss.mu.Lock()
+	if oss.subscribers[userId] == nil {
+		oss.subscribers[userId] = map[chan struct{}]struct{}{}
+	}
+	oss.subscribers[userId][wake] = struct{}{}
+	oss.mu.Unlock()
+
+	unsubscribe := func() {
+		oss.mu.Lock()
+		delete(oss.subscribers[userId], wake)
+		if len(oss.subscribers[userId]) == 0 {
+			delete(oss.subscribers, userId)
+		}
+		oss.mu.Unlock()
+	}
+
+	return wake, unsubscribe
+}
+
+// wake signals the user's streams, or every stream when userId is empty. A stream that
+// already has a pending signal doesn't need a second one.
+func (oss *OrderStreamService) wake(userId string) {
+	oss.mu.Lock()
+	defer oss.mu.Unlock()
+
+	for subscriber, channels := range oss.subscribers {
+		if userId != "" && subscriber != userId {
+			continue
+		}
+		for wake := range channels {
+			select {
+			case wake <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+// RunListener LISTENs for order status events and wakes the matching streams. The
+// listener reconnects on its own; notifications sent while it was disconnected are
+// lost, so every stream is woken after a reconnect to catch up from the table. It never
+// returns.
+func (oss *OrderStreamService) RunListener() {
+	listener := pq.NewListener(oss.dsn, time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Println("order stream listener:", err)
+		}
+	})
+	if err := listener.Listen(orderEventsChannel); err != nil {
+		log.Println("order stream listener:", err)
+	}
+
+	for {
+		select {
+		case notification := <-listener.Notify:
+			// nil after a reconnect
+			if notification == nil {
+				oss.wake("")
+				continue
+			}
+			oss.wake(notification.Extra)
+		case <-time.After(90 * time.Second):
+			go listener.Ping()
+		}
+	}
+}
+
+// GetLatestEventId returns the id a new stream starts after, so it only receives
+// changes from now on
+func (oss *OrderStreamService) GetLatestEventId(userId string) (int64, error) {
+	var eventId int64
+	query := `SELECT COALESCE(MAX(eventid), 0) FROM order_status_events WHERE userid = $1`
+	err := oss.DB.Get(&eventId, query, userId)
+	if err != nil {
+		return 0, fmt.Errorf("error fetching latest order event: %w", err)
+	}
+	return eventId, nil
+}
+
+// GetEventsSince returns the user's events after lastEventId, oldest first
+func (oss *OrderStreamService) GetEventsSince(userId string, lastEventId int64) ([]*models.OrderStatusEvent, error) {
+	query := `
+	SELECT * FROM order_status_events
+	WHERE userid = $1 AND eventid > $2
+	ORDER BY eventid
+	LIMIT $3
+	`
+	events := []*models.OrderStatusEvent{}
+	err := oss.DB.Select(&events, query, userId, lastEventId, OrderEventsBatchSize)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching order events: %w", err)
+	}
+	return events, nil
+}
@@ -0,0 +1,252 @@
+package services
+
+import (
+	"database/sql"
+	"eCommerce/helpers"
+	"eCommerce/models"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	OutboxKindEmail = "email"
+
+	OutboxStatusPending = "pending"
+	OutboxStatusDead    = "dead"
+)
+
+const (
+	outboxBatchSize = 50
+	// a claimed message is retried by any instance once its lease runs out, e.g. when
+	// the instance delivering it crashed
+	outboxLease = 5 * time.Minute
+	// delays double from outboxBaseDelay up to outboxMaxDelay; after outboxMaxAttempts
+	// failures (about 3 hours) the message is dead-lettered
+	outboxBaseDelay   = 30 * time.Second
+	outboxMaxDelay    = time.Hour
+	outboxMaxAttempts = 10
+)
+
+// OutboxHandler delivers a message of one kind. Attempts on the message counts the
+// current attempt.
+type OutboxHandler func(message *models.OutboxMessage) error
+
+// OutboxService is a transactional outbox. Side effects like emails are written to
+// outbox_messages in the same transaction as the change that causes them, and
+// RunDispatcher delivers them afterwards. A failed delivery never rolls back the change;
+// it is retried with backoff and moved to the dead letters when it keeps failing.
+type OutboxService struct {
+	DB *sqlx.DB
+
+	mu       *sync.RWMutex
+	handlers map[string]OutboxHandler
+}
+
+func NewOutboxService(db *sqlx.DB, mailer helpers.Mailer) *OutboxService {
+	obs := &OutboxService{
+		DB:       db,
+		mu:       &sync.RWMutex{},
+		handlers: map[string]OutboxHandler{},
+	}
+
+	obs.Register(OutboxKindEmail, func(message *models.OutboxMessage) error {
+		var email models.Email
+		if err := json.Unmarshal(message.Payload, &email); err != nil {
+			return fmt.Errorf("invalid email payload: %w", err)
+		}
+		return mailer.Send(&email)
+	})
+
+	return obs
+}
+
+// Register sets the handler for a kind of message. Messages of a kind with no handler
+// are retried like failures, so a kind added in a newer release isn't lost while older
+// instances are still running.
+func (obs *OutboxService) Register(kind string, handler OutboxHandler) {
+	obs.mu.Lock()
+	obs.handlers[kind] = handler
+	obs.mu.Unlock()
+}
+
+// Enqueue adds a message. db should be the transaction of the change the message belongs
+// to, so it is only delivered if that change is committed.
+func (obs *OutboxService) Enqueue(db sqlx.Ext, kind string, payload any) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to encode outbox payload: %w", err)
+	}
+
+	_, err = db.Exec(`INSERT INTO outbox_messages (kind, payload) VALUES ($1, $2)`, kind, string(data))
+	if err != nil {
+		return fmt.Errorf("failed to enqueue %s: %w", kind, err)
+	}
+
+	return nil
+}
+
+// EnqueueEmail adds an email rendered by one of the helpers email constructors. Emails
+// outside the transactional category are dropped when the recipient turned their
+// category off.
+func (obs *OutboxService) EnqueueEmail(db sqlx.Ext, email *models.Email) error {
+	if email.UserId != "" {
+		preferences, err := loadNotificationPreferences(db, email.UserId)
+		if err != nil {
+			return err
+		}
+		if !helpers.NotificationAllowed(preferences, helpers.ChannelEmail, email.Category) {
+			return nil
+		}
+	}
+	return obs.Enqueue(db, OutboxKindEmail, email)
+}
+
+func outboxBackoff(attempts int) time.Duration {
+	delay := outboxBaseDelay
+	for i := 1; i < attempts && delay < outboxMaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, outboxMaxDelay)
+}
+
+// claim takes up to outboxBatchSize due messages and leases them to this instance.
+// SKIP LOCKED lets several instances dispatch at once without taking the same message.
+func (obs *OutboxService) claim() ([]*models.OutboxMessage, error) {
+	claimQuery := `
+	UPDATE outbox_messages
+	SET availableat = $1, attempts = attempts + 1, updatedat = CURRENT_TIMESTAMP
+	WHERE messageid IN (
+		SELECT messageid FROM outbox_messages
+		WHERE status = $2 AND availableat <= CURRENT_TIMESTAMP
+		ORDER BY availableat
+		LIMIT $3
+		FOR UPDATE SKIP LOCKED
+	)
+	RETURNING *
+	`
+	messages := []*models.OutboxMessage{}
+	err := obs.DB.Select(&messages, claimQuery, time.Now().Add(outboxLease), OutboxStatusPending, outboxBatchSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim outbox messages: %w", err)
+	}
+	return messages, nil
+}
+
+func (obs *OutboxService) deliver(message *models.OutboxMessage) error {
+	obs.mu.RLock()
+	handler, ok := obs.handlers[message.Kind]
+	obs.mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("no handler for %s messages", message.Kind)
+	}
+	return handler(message)
+}
+
+// Dispatch delivers one batch of due messages and returns how many it claimed.
+// Delivered messages are deleted; failed ones are rescheduled or dead-lettered. Only
+// claiming can fail it; a message that can't be updated afterwards is logged and retried
+// when its lease runs out.
+func (obs *OutboxService) Dispatch() (int, error) {
+	messages, err := obs.claim()
+	if err != nil {
+		return 0, err
+	}
+
+	for _, message := range messages {
+		deliveryErr := obs.deliver(message)
+		if deliveryErr == nil {
+			_, err = obs.DB.Exec(`DELETE FROM outbox_messages WHERE messageid = $1`, message.MessageId)
+			// The rest of the batch is still leased to us, so carry on. The message is
+			// delivered again once its lease runs out.
+			if err != nil {
+				log.Printf("outbox message %d (%s): failed to remove delivered message: %v", message.MessageId, message.Kind, err)
+			}
+			continue
+		}
+
+		status := OutboxStatusPending
+		if message.Attempts >= outboxMaxAttempts {
+			status = OutboxStatusDead
+			log.Printf("outbox message %d (%s) dead-lettered after %d attempts: %v", message.MessageId, message.Kind, message.Attempts, deliveryErr)
+		}
+
+		updateQuery := `
+		UPDATE outbox_messages
+		SET status = $1, availableat = $2, last_error = $3, updatedat = CURRENT_TIMESTAMP
+		WHERE messageid = $4
+		`
+		_, err = obs.DB.Exec(updateQuery, status, time.Now().Add(outboxBackoff(message.Attempts)), deliveryErr.Error(), message.MessageId)
+		if err != nil {
+			log.Printf("outbox message %d (%s): failed to reschedule: %v", message.MessageId, message.Kind, err)
+		}
+	}
+
+	return len(messages), nil
+}
+
+// RunDispatcher delivers due messages every interval, draining full batches right away.
+// It never returns.
+func (obs *OutboxService) RunDispatcher(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		for {
+			claimed, err := obs.Dispatch()
+			if err != nil {
+				log.Println("outbox dispatcher:", err)
+				break
+			}
+			if claimed < outboxBatchSize {
+				break
+			}
+		}
+		<-ticker.C
+	}
+}
+
+// GetMessages lists messages for admins, newest first. Filters: status (pending or
+// dead), kind, limit (default 50, at most 200) and offset.
+func (obs *OutboxService) GetMessages(filters map[string]string) ([]*models.OutboxMessage, error) {
+	limit, offset := pageFilters(filters)
+
+	query := `
+	SELECT * FROM outbox_messages
+	WHERE ($1 = '' OR status = $1) AND ($2 = '' OR kind = $2)
+	ORDER BY createdat DESC, messageid DESC
+	LIMIT $3 OFFSET $4
+	`
+	messages := []*models.OutboxMessage{}
+	err := obs.DB.Select(&messages, query, filters["status"], filters["kind"], limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching outbox messages: %w", err)
+	}
+
+	return messages, nil
+}
+
+// Retry gives a dead message a fresh set of attempts, delivered on the next dispatch
+func (obs *OutboxService) Retry(messageId int64) (*models.OutboxMessage, error) {
+	retryQuery := `
+	UPDATE outbox_messages
+	SET status = $1, attempts = 0, availableat = CURRENT_TIMESTAMP, updatedat = CURRENT_TIMESTAMP
+	WHERE messageid = $2 AND status = $3
+	RETURNING *
+	`
+	var message models.OutboxMessage
+	err := obs.DB.Get(&message, retryQuery, OutboxStatusPending, messageId, OutboxStatusDead)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, models.ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to retry outbox message: %w", err)
+	}
+
+	return &message, nil
+}
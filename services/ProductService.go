@@ -1,11 +1,15 @@
 package services
 
 import (
+	"database/sql"
 	"eCommerce/models"
+	"errors"
 	"fmt"
-	"strconv"
+	"html"
+	"slices"
 	"strings"
 
+	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
 )
 
@@ -19,18 +23,64 @@ func NewProductService(db *sqlx.DB) *ProductService {
 	}
 }
 
-func (ps *ProductService) GetProducts(filters map[string]string) ([]*models.Product, error) {
-	var products []*models.Product
-	baseQuery := `SELECT * FROM products WHERE is_active = TRUE`
+// Keyword search modes. Full-text search stems words and understands quoted phrases, "or"
+// and -exclusions; when it finds nothing, trigram similarity on the name catches typos.
+const (
+	searchNone = iota
+	searchFullText
+	searchFuzzy
+)
+
+// Match types of keyword search hits
+const (
+	MatchFullText = "fulltext"
+	MatchFuzzy    = "fuzzy"
+)
+
+// productSearchDocument has to stay the expression idx_products_search is built on, or
+// searches can't use the index
+const productSearchDocument = `product_search_document(name, brand, category, description)`
+
+// fuzzySearchThreshold is the word similarity a name needs to match a misspelled keyword.
+// pg_trgm's default of 0.6 misses most single typos.
+const fuzzySearchThreshold = 0.4
+
+// Price bands of the price facet, as upper bounds. The last band has no upper bound.
+var priceBucketBounds = []float64{25, 50, 100, 250, 500}
+
+// maxFacetValues caps how many brands and categories a facet lists, most common first
+const maxFacetValues = 20
+
+// productConditions builds the WHERE clause of a listing. When the mode searches the
+// keyword, it is always $1. Facets pass their own filters as exclude, so their counts
+// show what choosing another value would give.
+func productConditions(filters map[string]string, mode int, exclude ...string) (string, []any) {
+	conditions := []string{"is_active = TRUE"}
 	var args []any
-	var conditions []string
-	argsIndex := 1
+	switch mode {
+	case searchFullText:
+		args = append(args, filters["keyword"])
+		conditions = append(conditions, productSearchDocument+" @@ websearch_to_tsquery('english', $1)")
+	case searchFuzzy:
+		args = append(args, filters["keyword"])
+		conditions = append(conditions, "$1 <% name")
+	}
+	argsIndex := len(args) + 1
 
 	for key, value := range filters {
+		if slices.Contains(exclude, key) {
+			continue
+		}
 		switch key {
-		case "category", "brand":
-			conditions = append(conditions, fmt.Sprintf("%s ILIKE $%d", key, argsIndex))
-			args = append(args, "%"+value+"%")
+		case "category":
+			placeholder := fmt.Sprintf("$%d", argsIndex)
+			conditions = append(conditions, "categoryid IN ("+categorySubtree(placeholder)+")")
+			args = append(args, strings.ToLower(value))
+			argsIndex++
+		case "brand":
+			// Exact, so a value from the brand facet gives the count the facet showed
+			conditions = append(conditions, fmt.Sprintf("lower(brand) = lower($%d)", argsIndex))
+			args = append(args, value)
 			argsIndex++
 		case "min_price":
 			conditions = append(conditions, fmt.Sprintf("price >= $%d", argsIndex))
@@ -44,54 +94,368 @@ func (ps *ProductService) GetProducts(filters map[string]string) ([]*models.Prod
 			conditions = append(conditions, fmt.Sprintf("discount >= $%d", argsIndex))
 			args = append(args, value)
 			argsIndex++
-		case "keyword":
-			conditions = append(conditions, fmt.Sprintf("(name ILIKE $%d OR description ILIKE $%d)", argsIndex, argsIndex))
-			args = append(args, "%"+value+"%")
+		case "vendor":
+			conditions = append(conditions, fmt.Sprintf("vendorid = $%d", argsIndex))
+			args = append(args, value)
 			argsIndex++
+		case "in_stock":
+			if value == "true" {
+				conditions = append(conditions, "stock > 0")
+			}
 		}
 	}
-	if len(conditions) > 0 {
-		baseQuery += " AND " + strings.Join(conditions, " AND ")
+
+	return " WHERE " + strings.Join(conditions, " AND "), args
+}
+
+// productOrder sorts by sort_by, or by relevance when searching a keyword. Relevance
+// sorts best matches first unless order says otherwise; productid breaks ties so pages
+// don't overlap.
+func productOrder(filters map[string]string, mode int) string {
+	direction := ""
+	switch filters["order"] {
+	case "ASC", "DESC":
+		direction = filters["order"]
+	}
+
+	sortBy := filters["sort_by"]
+	if sortBy == "" && mode != searchNone {
+		sortBy = "relevance"
 	}
 
-	// sorting
-	if sortBy := filters["sort_by"]; sortBy != "" {
-		switch sortBy {
-		case "price", "discount", "stock", "createdat":
-			baseQuery += fmt.Sprintf(" ORDER BY %s", sortBy)
+	switch sortBy {
+	case "price", "discount", "stock", "createdat":
+		if direction == "" {
+			direction = "ASC"
 		}
-	} else {
-		baseQuery += " ORDER BY productid ASC "
-	}
-
-	// ordering
-	if order := filters["order"]; order != "" {
-		switch order {
-		case "ASC":
-			baseQuery += " ASC"
-		case "DESC":
-			baseQuery += " DESC"
-		default:
-			baseQuery += " ASC"
+		return fmt.Sprintf(" ORDER BY %s %s, productid", sortBy, direction)
+	case "relevance":
+		if mode != searchNone {
+			if direction == "" {
+				direction = "DESC"
+			}
+			return fmt.Sprintf(" ORDER BY rank %s, productid", direction)
 		}
 	}
 
-	// pagination
-	if limitStr := filters["limit"]; limitStr != "" {
-		if limit, err := strconv.Atoi(limitStr); err == nil && limit > 0 {
-			baseQuery += fmt.Sprintf(" LIMIT %d", limit)
+	if direction == "" {
+		direction = "ASC"
+	}
+	return " ORDER BY productid " + direction
+}
+
+// productPage pages listings like the other list endpoints, so one request can't read
+// the whole catalog
+func productPage(filters map[string]string) string {
+	limit, offset := pageFilters(filters)
+	return fmt.Sprintf(" LIMIT %d OFFSET %d", limit, offset)
+}
+
+// escapeHighlight HTML-escapes a headline but keeps the <mark> tags ts_headline added,
+// so clients can render it as is
+func escapeHighlight(headline *string) *string {
+	if headline == nil {
+		return nil
+	}
+	escaped := html.EscapeString(*headline)
+	escaped = strings.ReplaceAll(escaped, "&lt;mark&gt;", "<mark>")
+	escaped = strings.ReplaceAll(escaped, "&lt;/mark&gt;", "</mark>")
+	return &escaped
+}
+
+func searchProducts(db sqlx.Ext, filters map[string]string, mode int) ([]*models.ProductSearchHit, error) {
+	where, args := productConditions(filters, mode)
+	order := productOrder(filters, mode)
+
+	columns := "*"
+	switch mode {
+	case searchFullText:
+		columns = "*, ts_rank(" + productSearchDocument + ", websearch_to_tsquery('english', $1)) AS rank"
+	case searchFuzzy:
+		columns = "*, word_similarity($1, name) AS rank"
+	}
+	query := "SELECT " + columns + " FROM products" + where + order + productPage(filters)
+
+	// Headlines are the slowest part of a search, so only the returned page gets them
+	if mode == searchFullText {
+		query = `
+		SELECT page.*,
+			ts_headline('english', page.name, websearch_to_tsquery('english', $1),
+				'StartSel=<mark>, StopSel=</mark>, HighlightAll=TRUE') AS name_highlight,
+			ts_headline('english', COALESCE(page.description, ''), websearch_to_tsquery('english', $1),
+				'StartSel=<mark>, StopSel=</mark>, MaxFragments=2, MaxWords=20, MinWords=5') AS description_highlight
+		FROM (` + query + `) page` + order
+	}
+
+	hits := []*models.ProductSearchHit{}
+	err := sqlx.Select(db, &hits, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching products: %w", err)
+	}
+
+	for _, hit := range hits {
+		switch mode {
+		case searchFullText:
+			hit.MatchType = MatchFullText
+		case searchFuzzy:
+			hit.MatchType = MatchFuzzy
 		}
+		hit.NameHighlight = escapeHighlight(hit.NameHighlight)
+		hit.DescriptionHighlight = escapeHighlight(hit.DescriptionHighlight)
 	}
-	if offsetStr := filters["offset"]; offsetStr != "" {
-		if offset, err := strconv.Atoi(offsetStr); err == nil && offset > 0 {
-			baseQuery += fmt.Sprintf(" OFFSET %d", offset)
+
+	return hits, nil
+}
+
+// countProducts counts the products matching the filters except exclude, and condition
+// when it is set
+func countProducts(db sqlx.Ext, filters map[string]string, mode int, condition string, exclude ...string) (int, error) {
+	where, args := productConditions(filters, mode, exclude...)
+	if condition != "" {
+		where += " AND " + condition
+	}
+
+	var count int
+	err := sqlx.Get(db, &count, "SELECT COUNT(*) FROM products"+where, args...)
+	if err != nil {
+		return 0, fmt.Errorf("error counting products: %w", err)
+	}
+	return count, nil
+}
+
+// brandFacets counts the products per brand, ignoring the brand filter
+func brandFacets(db sqlx.Ext, filters map[string]string, mode int) ([]*models.FacetCount, error) {
+	where, args := productConditions(filters, mode, "brand")
+	query := fmt.Sprintf(
+		"SELECT brand AS value, COUNT(*) AS count FROM products%s GROUP BY brand ORDER BY count DESC, brand LIMIT %d",
+		where, maxFacetValues,
+	)
+
+	counts := []*models.FacetCount{}
+	err := sqlx.Select(db, &counts, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error counting brand facet: %w", err)
+	}
+	return counts, nil
+}
+
+// categoryFacets counts the products per category they are directly in, ignoring the
+// category filter
+func categoryFacets(db sqlx.Ext, filters map[string]string, mode int) ([]*models.FacetCount, error) {
+	where, args := productConditions(filters, mode, "category")
+	query := fmt.Sprintf(`
+	SELECT c.slug AS value, c.name, f.count
+	FROM (
+		SELECT categoryid, COUNT(*) AS count FROM products%s
+		GROUP BY categoryid ORDER BY count DESC, categoryid LIMIT %d
+	) f
+	JOIN categories c ON c.categoryid = f.categoryid
+	ORDER BY f.count DESC, c.name
+	`, where, maxFacetValues)
+
+	counts := []*models.FacetCount{}
+	err := sqlx.Select(db, &counts, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error counting category facet: %w", err)
+	}
+	return counts, nil
+}
+
+// priceFacets counts the products per price band, ignoring min_price and max_price. Every
+// band is listed, even when empty.
+func priceFacets(db sqlx.Ext, filters map[string]string, mode int) ([]*models.PriceBucketCount, error) {
+	where, args := productConditions(filters, mode, "min_price", "max_price")
+
+	var bands strings.Builder
+	for i, bound := range priceBucketBounds {
+		fmt.Fprintf(&bands, " WHEN price < %v THEN %d", bound, i)
+	}
+	query := fmt.Sprintf(
+		"SELECT CASE%s ELSE %d END AS bucket, COUNT(*) AS count FROM products%s GROUP BY bucket",
+		bands.String(), len(priceBucketBounds), where,
+	)
+
+	var rows []struct {
+		Bucket int `db:"bucket"`
+		Count  int `db:"count"`
+	}
+	err := sqlx.Select(db, &rows, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error counting price facet: %w", err)
+	}
+
+	buckets := make([]*models.PriceBucketCount, len(priceBucketBounds)+1)
+	lower := 0.0
+	for i := range buckets {
+		buckets[i] = &models.PriceBucketCount{Min: lower}
+		if i < len(priceBucketBounds) {
+			upper := priceBucketBounds[i]
+			buckets[i].Max = &upper
+			lower = upper
 		}
 	}
+	for _, row := range rows {
+		buckets[row.Bucket].Count = row.Count
+	}
+	return buckets, nil
+}
 
-	err := ps.DB.Select(&products, baseQuery, args...)
+func productFacets(db sqlx.Ext, filters map[string]string, mode int) (_ *models.ProductFacets, err error) {
+	facets := &models.ProductFacets{}
+	facets.Brands, err = brandFacets(db, filters, mode)
 	if err != nil {
-		return nil, fmt.Errorf("error fetching products: %w", err)
+		return nil, err
+	}
+	facets.Categories, err = categoryFacets(db, filters, mode)
+	if err != nil {
+		return nil, err
+	}
+	facets.Prices, err = priceFacets(db, filters, mode)
+	if err != nil {
+		return nil, err
+	}
+	facets.OnDiscount, err = countProducts(db, filters, mode, "discount > 0", "discount")
+	if err != nil {
+		return nil, err
+	}
+	facets.InStock, err = countProducts(db, filters, mode, "stock > 0", "in_stock")
+	if err != nil {
+		return nil, err
+	}
+	return facets, nil
+}
+
+// searchProductResult runs one listing: the page of hits, the total number of hits and,
+// when the facets filter is "true", the facets. All of them use the same search mode.
+func searchProductResult(db sqlx.Ext, filters map[string]string, mode int) (*models.ProductSearchResult, error) {
+	hits, err := searchProducts(db, filters, mode)
+	if err != nil {
+		return nil, err
+	}
+	total, err := countProducts(db, filters, mode, "")
+	if err != nil {
+		return nil, err
+	}
+
+	result := &models.ProductSearchResult{Products: hits, Total: total}
+	if filters["facets"] == "true" {
+		result.Facets, err = productFacets(db, filters, mode)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// GetProducts lists active products matching the filters. A keyword is searched as full
+// text over name, brand, category and description; only when that finds nothing are
+// names matched fuzzily.
+func (ps *ProductService) GetProducts(filters map[string]string) (*models.ProductSearchResult, error) {
+	if strings.TrimSpace(filters["keyword"]) == "" {
+		return searchProductResult(ps.DB, filters, searchNone)
+	}
+
+	where, args := productConditions(filters, searchFullText)
+	var matched bool
+	err := ps.DB.Get(&matched, "SELECT EXISTS (SELECT 1 FROM products"+where+")", args...)
+	if err != nil {
+		return nil, fmt.Errorf("error searching products: %w", err)
+	}
+	if matched {
+		return searchProductResult(ps.DB, filters, searchFullText)
+	}
+
+	return ps.fuzzySearchProducts(filters)
+}
+
+// fuzzySearchProducts runs the trigram search in its own transaction, so the lowered
+// similarity threshold doesn't leak into the pooled connection
+func (ps *ProductService) fuzzySearchProducts(filters map[string]string) (_ *models.ProductSearchResult, err error) {
+	tx, err := ps.DB.Beginx()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		} else if err != nil {
+			tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
+
+	_, err = tx.Exec(fmt.Sprintf("SET LOCAL pg_trgm.word_similarity_threshold = %v", fuzzySearchThreshold))
+	if err != nil {
+		return nil, fmt.Errorf("failed to set similarity threshold: %w", err)
+	}
+
+	return searchProductResult(tx, filters, searchFuzzy)
+}
+
+// GetCatalogProduct returns the public page of an active product. Inactive and unknown
+// products are both models.ErrNotFound, so the catalog doesn't tell them apart.
+func (ps *ProductService) GetCatalogProduct(productId string) (*models.CatalogProduct, error) {
+	if _, err := uuid.Parse(productId); err != nil {
+		return nil, models.ErrNotFound
+	}
+
+	query := `
+	SELECT p.*, u.name AS vendorname,
+		ROUND(AVG(r.stars), 2) AS averagerating,
+		COUNT(r.reviewid) AS reviewcount
+	FROM products p
+	JOIN users u ON u.userid = p.vendorid
+	LEFT JOIN reviews r ON r.productid = p.productid
+	WHERE p.productid = $1 AND p.is_active = TRUE
+	GROUP BY p.productid, u.name
+	`
+	var product models.CatalogProduct
+	err := ps.DB.Get(&product, query, productId)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, models.ErrNotFound
+		}
+		return nil, fmt.Errorf("error fetching product: %w", err)
+	}
+
+	matrix, err := loadProductVariants(ps.DB, productId, true)
+	if err != nil {
+		return nil, err
+	}
+	product.Options = matrix.Options
+	product.Variants = matrix.Variants
+
+	return &product, nil
+}
+
+// GetStorefront returns the public profile of a vendor. Only users selling at least one
+// active product have a storefront, which keeps customers and deleted vendors, whose
+// products are deactivated, out of the catalog.
+func (ps *ProductService) GetStorefront(vendorId string) (*models.VendorStorefront, error) {
+	if _, err := uuid.Parse(vendorId); err != nil {
+		return nil, models.ErrNotFound
+	}
+
+	query := `
+	SELECT u.userid AS vendorid, u.name, u.createdat AS joinedat,
+		(SELECT COUNT(*) FROM products p WHERE p.vendorid = u.userid AND p.is_active = TRUE) AS productcount,
+		(SELECT ROUND(AVG(r.stars), 2) FROM reviews r WHERE r.vendorid = u.userid) AS averagerating,
+		(SELECT COUNT(*) FROM reviews r WHERE r.vendorid = u.userid) AS reviewcount
+	FROM users u
+	WHERE u.userid = $1
+		AND EXISTS (SELECT 1 FROM products p WHERE p.vendorid = u.userid AND p.is_active = TRUE)
+	`
+	var storefront models.VendorStorefront
+	err := ps.DB.Get(&storefront, query, vendorId)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, models.ErrNotFound
+		}
+		return nil, fmt.Errorf("error fetching storefront: %w", err)
 	}
 
-	return products, nil
+	return &storefront, nil
 }
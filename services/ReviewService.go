@@ -2,6 +2,7 @@ package services
 
 import (
 	"database/sql"
+	"eCommerce/helpers"
 	"eCommerce/models"
 	"errors"
 	"fmt"
@@ -12,11 +13,15 @@ import (
 
 type ReviewService struct {
 	DB *sqlx.DB
+	ws WebhookService
+	ns NotificationService
 }
 
-func NewReviewService(db *sqlx.DB) *ReviewService {
+func NewReviewService(db *sqlx.DB, ws WebhookService, ns NotificationService) *ReviewService {
 	return &ReviewService{
 		db,
+		ws,
+		ns,
 	}
 }
 
@@ -45,7 +50,7 @@ func (rs *ReviewService) checkOwnership(reviewId, userId string) error {
 	return nil
 }
 
-func (rs *ReviewService) SubmitReview(userId, productId string, reviewData *models.ReviewData) error {
+func (rs *ReviewService) SubmitReview(userId, productId string, reviewData *models.ReviewData) (err error) {
 	vendorId, err := rs.getVendorId(productId)
 	if err != nil {
 		return err
@@ -58,18 +63,49 @@ func (rs *ReviewService) SubmitReview(userId, productId string, reviewData *mode
 	query := `
 	INSERT INTO reviews (userid, vendorid, productid, message, stars)
 	VALUES ($1, $2, $3, $4, $5)
+	RETURNING *
 	`
 
 	if reviewData.Stars < 1 || reviewData.Stars > 5 {
 		return fmt.Errorf("stars only range from 1 to 5 (inclusive)")
 	}
 
-	_, err = rs.DB.Exec(query, userId, vendorId, productId, reviewData.ReviewMessage, reviewData.Stars)
+	tx, err := rs.DB.Beginx()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		} else if err != nil {
+			tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
+
+	var review models.Review
+	err = tx.Get(&review, query, userId, vendorId, productId, reviewData.ReviewMessage, reviewData.Stars)
 	if err != nil {
 		return fmt.Errorf("error submitting review: %w", err)
 	}
 
-	return nil
+	err = rs.ws.Publish(tx, vendorId, WebhookReviewCreated, &review)
+	if err != nil {
+		return err
+	}
+
+	return rs.ns.Notify(tx, &models.NotificationEntry{
+		UserId:   vendorId,
+		Audience: NotificationAudienceVendor,
+		Type:     NotificationVendorReview,
+		Category: helpers.CategoryStoreActivity,
+		Title:    "New review",
+		Body:     fmt.Sprintf("A customer rated one of your products %d out of 5.", review.Stars),
+		Data:     map[string]any{"reviewId": review.ReviewId, "productId": productId},
+	})
 }
 
 func (rs *ReviewService) DeleteReview(userId, reviewId string) error {
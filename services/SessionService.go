@@ -0,0 +1,141 @@
+package services
+
+import (
+	"database/sql"
+	"eCommerce/models"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+// lastSeenInterval keeps ValidateSession from writing to the sessions table on every request
+const lastSeenInterval = time.Minute
+
+type SessionService struct {
+	DB *sqlx.DB
+}
+
+func NewSessionService(db *sqlx.DB) *SessionService {
+	return &SessionService{
+		db,
+	}
+}
+
+func (ss *SessionService) CreateSession(db sqlx.Ext, userId string, info *models.SessionInfo) (string, error) {
+	sessionId := uuid.New().String()
+	insertQuery := `
+	INSERT INTO sessions (sessionid, userid, device, ip_address, user_agent)
+	VALUES ($1, $2, $3, $4, $5)
+	`
+	_, err := db.Exec(insertQuery, sessionId, userId, info.Device, info.IPAddress, info.UserAgent)
+	if err != nil {
+		return "", fmt.Errorf("error creating session: %w", err)
+	}
+	return sessionId, nil
+}
+
+// ValidateSession is called by the auth middleware on every request. It rejects revoked
+// sessions and refreshes lastseenat at most once per lastSeenInterval.
+func (ss *SessionService) ValidateSession(sessionId, userId string) error {
+	var session models.Session
+	err := ss.DB.Get(&session, `SELECT * FROM sessions WHERE sessionid = $1 AND userid = $2`, sessionId, userId)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.ErrNotFound
+		}
+		return fmt.Errorf("error fetching session: %w", err)
+	}
+
+	if session.Revoked {
+		return fmt.Errorf("session has been revoked")
+	}
+
+	if time.Since(session.LastSeenAt) > lastSeenInterval {
+		_, err = ss.DB.Exec(`UPDATE sessions SET lastseenat = CURRENT_TIMESTAMP WHERE sessionid = $1`, sessionId)
+		if err != nil {
+			return fmt.Errorf("error updating session: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (ss *SessionService) GetSessions(userId, currentSessionId string) ([]*models.Session, error) {
+	var sessions []*models.Session
+	query := `SELECT * FROM sessions WHERE userid = $1 AND revoked = FALSE ORDER BY lastseenat DESC`
+	err := ss.DB.Select(&sessions, query, userId)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching sessions: %w", err)
+	}
+
+	for _, session := range sessions {
+		session.Current = session.SessionId == currentSessionId
+	}
+
+	return sessions, nil
+}
+
+// RevokeSession revokes the session and every refresh token issued for it
+func (ss *SessionService) RevokeSession(userId, sessionId string) (err error) {
+	tx, err := ss.DB.Beginx()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		} else if err != nil {
+			tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
+
+	res, err := tx.Exec(`UPDATE sessions SET revoked = TRUE WHERE sessionid = $1 AND userid = $2`, sessionId, userId)
+	if err != nil {
+		return fmt.Errorf("error revoking session: %w", err)
+	}
+
+	affectedRows, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error checking revoked rows: %w", err)
+	}
+	if affectedRows == 0 {
+		err = models.ErrNotFound
+		return err
+	}
+
+	_, err = tx.Exec(`UPDATE refresh_tokens SET revoked = TRUE WHERE familyid = $1`, sessionId)
+	if err != nil {
+		return fmt.Errorf("error revoking session tokens: %w", err)
+	}
+
+	return nil
+}
+
+// RevokeOtherSessions logs the user out everywhere except exceptSessionId. An empty
+// exceptSessionId revokes every session.
+func (ss *SessionService) RevokeOtherSessions(db sqlx.Ext, userId, exceptSessionId string) error {
+	_, err := db.Exec(`
+		UPDATE sessions SET revoked = TRUE
+		WHERE userid = $1 AND revoked = FALSE AND sessionid::text <> $2
+	`, userId, exceptSessionId)
+	if err != nil {
+		return fmt.Errorf("error revoking sessions: %w", err)
+	}
+
+	_, err = db.Exec(`
+		UPDATE refresh_tokens SET revoked = TRUE
+		WHERE userid = $1 AND revoked = FALSE AND familyid::text <> $2
+	`, userId, exceptSessionId)
+	if err != nil {
+		return fmt.Errorf("error revoking session tokens: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,266 @@
+package services
+
+import (
+	"crypto"
+	"eCommerce/helpers"
+	"eCommerce/models"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	signingKeyRotation = 30 * 24 * time.Hour
+	// new keys are published in the JWKS this long before they sign anything, so
+	// services caching the keyset already know them
+	signingKeyPublishAhead = time.Hour
+	// replaced keys stay in the keyset this long; it must be longer than the 15 minute
+	// access token lifetime
+	signingKeyRetention = 24 * time.Hour
+	// how long loaded keys are used before checking the table for rotations made by
+	// other instances
+	signingKeyCacheTTL = time.Minute
+	// an unknown kid reloads the keys at most this often
+	signingKeyReloadInterval = 10 * time.Second
+	// serializes Rotate across instances
+	signingKeyRotationLock = 741852
+)
+
+type signingKeyEntry struct {
+	key         *helpers.SigningKey
+	activatesAt time.Time
+}
+
+// SigningKeyService holds the keyset that signs access tokens. Keys are stored in
+// signing_keys and rotated by RunRotationWorker; every instance signs with the newest
+// active key and verifies with any key still in the table.
+type SigningKeyService struct {
+	DB  *sqlx.DB
+	alg string
+
+	mu       sync.RWMutex
+	keys     []signingKeyEntry // newest first
+	loadedAt time.Time
+}
+
+func NewSigningKeyService(db *sqlx.DB, alg string) *SigningKeyService {
+	return &SigningKeyService{
+		DB:  db,
+		alg: alg,
+	}
+}
+
+func (sks *SigningKeyService) load() ([]signingKeyEntry, error) {
+	var records []models.SigningKeyRecord
+	err := sks.DB.Select(&records, `SELECT * FROM signing_keys ORDER BY activatesat DESC`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch signing keys: %w", err)
+	}
+
+	entries := make([]signingKeyEntry, 0, len(records))
+	for _, record := range records {
+		key, err := helpers.ParseSigningKey(record.Kid, record.Algorithm, record.PrivateKeyPEM)
+		if err != nil {
+			log.Printf("error parsing signing key %s: %v", record.Kid, err)
+			continue
+		}
+		entries = append(entries, signingKeyEntry{key: key, activatesAt: record.ActivatesAt})
+	}
+
+	sks.mu.Lock()
+	sks.keys = entries
+	sks.loadedAt = time.Now()
+	sks.mu.Unlock()
+
+	return entries, nil
+}
+
+func (sks *SigningKeyService) cachedKeys() ([]signingKeyEntry, error) {
+	sks.mu.RLock()
+	keys, loadedAt := sks.keys, sks.loadedAt
+	sks.mu.RUnlock()
+
+	if keys != nil && time.Since(loadedAt) < signingKeyCacheTTL {
+		return keys, nil
+	}
+	return sks.load()
+}
+
+// SigningKey returns the newest active key. The first call on an empty table creates it.
+func (sks *SigningKeyService) SigningKey() (*helpers.SigningKey, error) {
+	keys, err := sks.cachedKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	for _, entry := range keys {
+		if !entry.activatesAt.After(now) {
+			return entry.key, nil
+		}
+	}
+
+	if err := sks.Rotate(); err != nil {
+		return nil, err
+	}
+	keys, err = sks.load()
+	if err != nil {
+		return nil, err
+	}
+	// The key Rotate just created activates at its own, later, time.Now()
+	now = time.Now()
+	for _, entry := range keys {
+		if !entry.activatesAt.After(now) {
+			return entry.key, nil
+		}
+	}
+	return nil, fmt.Errorf("failed to find an active signing key")
+}
+
+// VerificationKey returns the public key for a token's kid
+func (sks *SigningKeyService) VerificationKey(kid string) (crypto.PublicKey, error) {
+	keys, err := sks.cachedKeys()
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range keys {
+		if entry.key.Kid == kid {
+			return entry.key.Key.Public(), nil
+		}
+	}
+
+	// The key may have just been created by another instance
+	sks.mu.RLock()
+	loadedAt := sks.loadedAt
+	sks.mu.RUnlock()
+	if time.Since(loadedAt) < signingKeyReloadInterval {
+		return nil, fmt.Errorf("unknown signing key")
+	}
+
+	keys, err = sks.load()
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range keys {
+		if entry.key.Kid == kid {
+			return entry.key.Key.Public(), nil
+		}
+	}
+	return nil, fmt.Errorf("unknown signing key")
+}
+
+// JWKS returns the public keys of every key in the keyset, including the next key
+// before it becomes active and replaced keys until their retention ends
+func (sks *SigningKeyService) JWKS() (*helpers.JWKS, error) {
+	keys, err := sks.cachedKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	jwks := &helpers.JWKS{Keys: []helpers.JWK{}}
+	for _, entry := range keys {
+		jwk, err := entry.key.PublicJWK()
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode signing key: %w", err)
+		}
+		jwks.Keys = append(jwks.Keys, jwk)
+	}
+
+	return jwks, nil
+}
+
+// Rotate adds the next key once the newest one is due for rotation, and removes keys
+// that were replaced more than signingKeyRetention ago. The very first key is active
+// immediately; later ones after signingKeyPublishAhead.
+func (sks *SigningKeyService) Rotate() (err error) {
+	tx, err := sks.DB.Beginx()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		} else if err != nil {
+			tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
+
+	_, err = tx.Exec(`SELECT pg_advisory_xact_lock($1)`, signingKeyRotationLock)
+	if err != nil {
+		return fmt.Errorf("failed to lock signing keys: %w", err)
+	}
+
+	var newest []time.Time
+	err = tx.Select(&newest, `SELECT activatesat FROM signing_keys ORDER BY activatesat DESC LIMIT 1`)
+	if err != nil {
+		return fmt.Errorf("failed to fetch signing keys: %w", err)
+	}
+
+	now := time.Now()
+	var activatesAt time.Time
+	switch {
+	case len(newest) == 0:
+		activatesAt = now
+	case newest[0].Before(now.Add(-signingKeyRotation + signingKeyPublishAhead)):
+		activatesAt = now.Add(signingKeyPublishAhead)
+	}
+
+	if !activatesAt.IsZero() {
+		var key *helpers.SigningKey
+		key, err = helpers.GenerateSigningKey(sks.alg)
+		if err != nil {
+			return fmt.Errorf("failed to generate signing key: %w", err)
+		}
+		var privateKeyPEM string
+		privateKeyPEM, err = key.EncodePrivateKey()
+		if err != nil {
+			return fmt.Errorf("failed to encode signing key: %w", err)
+		}
+
+		insertQuery := `
+		INSERT INTO signing_keys (kid, algorithm, private_key, activatesat)
+		VALUES ($1, $2, $3, $4)
+		`
+		_, err = tx.Exec(insertQuery, key.Kid, key.Alg, privateKeyPEM, activatesAt)
+		if err != nil {
+			return fmt.Errorf("failed to store signing key: %w", err)
+		}
+	}
+
+	// A key is retired once a newer key has been active for the whole retention period
+	deleteQuery := `
+	DELETE FROM signing_keys k
+	WHERE EXISTS (
+		SELECT 1 FROM signing_keys n
+		WHERE n.activatesat > k.activatesat AND n.activatesat < $1
+	)
+	`
+	_, err = tx.Exec(deleteQuery, now.Add(-signingKeyRetention))
+	if err != nil {
+		return fmt.Errorf("failed to remove retired signing keys: %w", err)
+	}
+
+	return nil
+}
+
+// RunRotationWorker calls Rotate every interval. It never returns.
+func (sks *SigningKeyService) RunRotationWorker(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := sks.Rotate(); err != nil {
+			log.Println("signing key rotation worker:", err)
+		} else if _, err := sks.load(); err != nil {
+			log.Println("signing key rotation worker:", err)
+		}
+		<-ticker.C
+	}
+}
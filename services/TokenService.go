@@ -0,0 +1,142 @@
+package services
+
+import (
+	"database/sql"
+	"eCommerce/helpers"
+	"eCommerce/models"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+type TokenService struct {
+	DB  *sqlx.DB
+	sks *SigningKeyService
+}
+
+func NewTokenService(db *sqlx.DB, sks *SigningKeyService) *TokenService {
+	return &TokenService{
+		db,
+		sks,
+	}
+}
+
+// IssueTokens generates an access/refresh pair and records the refresh token in the
+// token store. Every refresh token rotated from the same login shares the login's
+// session id as its family id.
+func (ts *TokenService) IssueTokens(db sqlx.Ext, user *models.User, sessionId string) (string, string, error) {
+	signingKey, err := ts.sks.SigningKey()
+	if err != nil {
+		return "", "", err
+	}
+
+	accessToken, err := helpers.GenerateAccessToken(signingKey, user.UserId.String(), user.Email, user.Role, sessionId)
+	if err != nil {
+		return "", "", fmt.Errorf("error generating access token: %w", err)
+	}
+
+	tokenId := uuid.New().String()
+	expiresAt := time.Now().Add(helpers.RefreshTokenTTL)
+
+	refreshToken, err := helpers.GenerateRefreshToken(user.UserId.String(), user.Email, user.Role, tokenId, sessionId, expiresAt)
+	if err != nil {
+		return "", "", fmt.Errorf("error generating refresh Token: %w", err)
+	}
+
+	insertQuery := `
+	INSERT INTO refresh_tokens (tokenid, familyid, userid, expiresat)
+	VALUES ($1, $2, $3, $4)
+	`
+	_, err = db.Exec(insertQuery, tokenId, sessionId, user.UserId, expiresAt)
+	if err != nil {
+		return "", "", fmt.Errorf("error storing refresh token: %w", err)
+	}
+
+	return accessToken, refreshToken, nil
+}
+
+// Refresh redeems a refresh token and rotates it. Presenting a token that was already
+// used means it leaked, so the whole family is revoked.
+func (ts *TokenService) Refresh(refreshToken string) (*models.User, string, string, error) {
+	claims, err := helpers.ParseRefreshToken(refreshToken)
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	tokenId, tokenIdOk := claims["jti"].(string)
+	sessionId, sessionIdOk := claims["SessionId"].(string)
+	if !tokenIdOk || !sessionIdOk {
+		return nil, "", "", fmt.Errorf("invalid refresh token claims")
+	}
+
+	tx, err := ts.DB.Beginx()
+	if err != nil {
+		return nil, "", "", fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	var stored models.RefreshToken
+	err = tx.Get(&stored, `SELECT * FROM refresh_tokens WHERE tokenid = $1 FOR UPDATE`, tokenId)
+	if err != nil {
+		tx.Rollback()
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, "", "", fmt.Errorf("invalid refresh token")
+		}
+		return nil, "", "", fmt.Errorf("failed to fetch refresh token: %w", err)
+	}
+
+	if stored.Revoked {
+		tx.Rollback()
+		return nil, "", "", fmt.Errorf("refresh token has been revoked")
+	}
+
+	if stored.UsedAt != nil {
+		tx.Rollback()
+		if err := ts.RevokeFamily(stored.FamilyId); err != nil {
+			return nil, "", "", err
+		}
+		return nil, "", "", fmt.Errorf("refresh token reuse detected, please log in again")
+	}
+
+	_, err = tx.Exec(`UPDATE refresh_tokens SET usedat = CURRENT_TIMESTAMP WHERE tokenid = $1`, tokenId)
+	if err != nil {
+		tx.Rollback()
+		return nil, "", "", fmt.Errorf("failed to mark refresh token as used: %w", err)
+	}
+
+	// Reload the user so role or email changes since the last rotation are picked up
+	var user models.User
+	err = tx.Get(&user, `SELECT * FROM users WHERE userid = $1`, stored.UserId)
+	if err != nil {
+		tx.Rollback()
+		return nil, "", "", fmt.Errorf("failed to fetch user: %w", err)
+	}
+
+	accessToken, newRefreshToken, err := ts.IssueTokens(tx, &user, sessionId)
+	if err != nil {
+		tx.Rollback()
+		return nil, "", "", err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, "", "", fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return &user, accessToken, newRefreshToken, nil
+}
+
+// RevokeFamily revokes every token in the family along with the session it belongs to
+func (ts *TokenService) RevokeFamily(familyId string) error {
+	_, err := ts.DB.Exec(`UPDATE refresh_tokens SET revoked = TRUE WHERE familyid = $1`, familyId)
+	if err != nil {
+		return fmt.Errorf("failed to revoke token family: %w", err)
+	}
+
+	_, err = ts.DB.Exec(`UPDATE sessions SET revoked = TRUE WHERE sessionid = $1`, familyId)
+	if err != nil {
+		return fmt.Errorf("failed to revoke session: %w", err)
+	}
+	return nil
+}
@@ -1,26 +1,118 @@
 package services
 
 import (
+	"database/sql"
 	"eCommerce/helpers"
 	"eCommerce/models"
+	"errors"
 	"fmt"
+	"log"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
 )
 
+const (
+	passwordResetTTL = 30 * time.Minute
+	verificationTTL  = 24 * time.Hour
+	// per email: one new link a minute and at most 5 an hour. The IP limit stops one
+	// client from cycling through addresses.
+	verificationResendInterval = time.Minute
+	verificationResendMax      = 5
+	verificationResendIPMax    = 20
+	verificationResendWindow   = time.Hour
+	emailChangeTTL             = 24 * time.Hour
+	// how long the old address can undo a change, even after it was confirmed
+	emailChangeRevertWindow = 7 * 24 * time.Hour
+)
+
 type UserService struct {
-	DB *sqlx.DB
+	DB  *sqlx.DB
+	ts  TokenService
+	ss  SessionService
+	ms  MFAService
+	lt  LoginThrottleService
+	aus AuditService
+	obs OutboxService
 }
 
-func NewUserService(db *sqlx.DB) *UserService {
+func NewUserService(db *sqlx.DB, ts TokenService, ss SessionService, ms MFAService, lt LoginThrottleService, aus AuditService, obs OutboxService) *UserService {
 	return &UserService{
-		DB: db,
+		DB:  db,
+		ts:  ts,
+		ss:  ss,
+		ms:  ms,
+		lt:  lt,
+		aus: aus,
+		obs: obs,
+	}
+}
+
+var (
+	dummyPasswordHash     string
+	dummyPasswordHashOnce sync.Once
+)
+
+// checkDummyPassword spends the same bcrypt time as a real comparison, so unknown
+// emails can't be told apart by how long the login takes
+func checkDummyPassword(password string) {
+	dummyPasswordHashOnce.Do(func() {
+		dummyPasswordHash, _ = helpers.HashPassword("dummy-password")
+	})
+	helpers.CheckPasswords(password, dummyPasswordHash)
+}
+
+// loginFailed records a failed attempt. If it locks the account and the account exists,
+// the owner is sent an unlock link.
+func (us *UserService) loginFailed(email, ip string, user *models.User) error {
+	unlockToken, err := us.lt.RegisterFailure(email, ip)
+	if err != nil {
+		return err
+	}
+
+	entry := &models.AuditEntry{
+		Action:     AuditLoginFailed,
+		IPAddress:  ip,
+		TargetType: auditTargetUser,
+		Metadata:   map[string]any{"email": email},
+	}
+	if user != nil {
+		entry.TargetId = user.UserId.String()
+	}
+	if err := us.aus.Record(us.DB, entry); err != nil {
+		return err
 	}
+
+	if unlockToken != "" {
+		entry.Action = AuditAccountLocked
+		if err := us.aus.Record(us.DB, entry); err != nil {
+			return err
+		}
+	}
+
+	if unlockToken != "" && user != nil {
+		unlockEmail, err := helpers.AccountUnlockEmail(unlockToken, user.Email)
+		if err != nil {
+			return err
+		}
+		if err := us.obs.EnqueueEmail(us.DB, unlockEmail); err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
-func (us *UserService) Login(creds *models.Credentials) (*models.User, string, string, error) {
+// Login checks the credentials. When MFA is enabled no tokens are issued yet; the result
+// carries a challenge token to be exchanged at LoginMFA instead.
+func (us *UserService) Login(creds *models.Credentials, info *models.SessionInfo) (*models.LoginResult, error) {
+	if err := us.lt.Check(creds.Email, info.IPAddress); err != nil {
+		return nil, err
+	}
+
 	var user models.User
 	query := `
 	SELECT userid, name, email, password, phone_number, verified, verification_token, role FROM users WHERE email = $1
@@ -28,28 +120,248 @@ func (us *UserService) Login(creds *models.Credentials) (*models.User, string, s
 	// Get User
 	err := us.DB.Get(&user, query, creds.Email)
 	if err != nil {
-		return nil, "", "", fmt.Errorf("failed to fetch user: %w", err)
-	}
-	if !user.Verified {
-		return nil, "", "", fmt.Errorf("user not verified, please check your inbox for the verification link: %w", err)
+		if !errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("failed to fetch user: %w", err)
+		}
+		checkDummyPassword(creds.Password)
+		if err := us.loginFailed(creds.Email, info.IPAddress, nil); err != nil {
+			return nil, err
+		}
+		return nil, models.ErrInvalidCredentials
 	}
 
 	// Compare passwords
 	if !helpers.CheckPasswords(creds.Password, user.Password) {
-		return nil, "", "", fmt.Errorf("passwords don't match")
+		if err := us.loginFailed(creds.Email, info.IPAddress, &user); err != nil {
+			return nil, err
+		}
+		return nil, models.ErrInvalidCredentials
+	}
+
+	// Only checked after the password so it doesn't reveal that the account exists
+	if !user.Verified {
+		return nil, fmt.Errorf("user not verified, please check your inbox for the verification link")
+	}
+
+	if err := us.lt.RegisterSuccess(creds.Email); err != nil {
+		return nil, err
+	}
+
+	return us.completeLogin(&user, info, "password")
+}
+
+// completeLogin starts a session, or returns an MFA challenge when MFA is enabled.
+// method is recorded in the audit log.
+func (us *UserService) completeLogin(user *models.User, info *models.SessionInfo, method string) (*models.LoginResult, error) {
+	mfaEnabled, err := us.ms.IsEnabled(user.UserId.String())
+	if err != nil {
+		return nil, err
+	}
+
+	if mfaEnabled {
+		mfaToken, err := helpers.GenerateMFAToken(user.UserId.String())
+		if err != nil {
+			return nil, fmt.Errorf("error generating mfa token: %w", err)
+		}
+		return &models.LoginResult{User: user, MFARequired: true, MFAToken: mfaToken}, nil
+	}
+
+	return us.startSession(user, info, method)
+}
+
+// LoginWithIdentity logs in the user linked to an identity verified by OIDCService.
+// An unknown identity is linked to the account with the same verified email, or a new
+// verified account without a password is created for it.
+func (us *UserService) LoginWithIdentity(identity *models.OIDCIdentity, info *models.SessionInfo) (*models.LoginResult, error) {
+	var user models.User
+	query := `
+	SELECT u.* FROM users u
+	JOIN user_identities i ON i.userid = u.userid
+	WHERE i.provider = $1 AND i.subject = $2
+	`
+	err := us.DB.Get(&user, query, identity.Provider, identity.Subject)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("failed to fetch user: %w", err)
+		}
+
+		linkedUser, err := us.linkIdentity(identity)
+		if err != nil {
+			return nil, err
+		}
+		user = *linkedUser
+	}
+
+	// Throttled or locked accounts stay that way whichever way the user logs in
+	if err := us.lt.Check(user.Email, ""); err != nil {
+		return nil, err
+	}
+
+	return us.completeLogin(&user, info, "oidc:"+identity.Provider)
+}
+
+func (us *UserService) linkIdentity(identity *models.OIDCIdentity) (user *models.User, err error) {
+	// Without a verified email we can't tell whose account this is
+	if identity.Email == "" || !identity.EmailVerified {
+		return nil, fmt.Errorf("the provider did not return a verified email address")
+	}
+	if !helpers.IsValidEmail(identity.Email) {
+		return nil, fmt.Errorf("invalid email format")
+	}
+
+	tx, err := us.DB.Beginx()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		} else if err != nil {
+			tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
+
+	user = &models.User{}
+	err = tx.Get(user, `SELECT * FROM users WHERE email = $1 FOR UPDATE`, identity.Email)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		name := strings.TrimSpace(identity.Name)
+		if name == "" {
+			name, _, _ = strings.Cut(identity.Email, "@")
+		}
+		user = &models.User{
+			UserId:   uuid.New(),
+			Name:     name,
+			Email:    identity.Email,
+			Verified: true,
+			Role:     helpers.RoleCustomer,
+		}
+
+		// The empty password hash never matches, so the account can only log in
+		// through the provider until a password is set with ForgotPassword
+		insertQuery := `
+		INSERT INTO users (userid, name, email, password, phone_number, verified, verification_token, role)
+		VALUES ($1, $2, $3, '', '', TRUE, '', $4)
+		RETURNING *
+		`
+		err = tx.Get(user, insertQuery, user.UserId, user.Name, user.Email, user.Role)
+		if err != nil {
+			return nil, fmt.Errorf("error inserting user: %w", err)
+		}
+
+	case err != nil:
+		return nil, fmt.Errorf("failed to fetch user: %w", err)
+
+	case !user.Verified:
+		// The provider proved the address, which is what the verification email would
+		// have done. The unverified password may have been set by someone else, so it's
+		// dropped.
+		_, err = tx.Exec(`
+			UPDATE users SET verified = TRUE, verification_token = '', password = '', updatedat = CURRENT_TIMESTAMP
+			WHERE userid = $1
+		`, user.UserId)
+		if err != nil {
+			return nil, fmt.Errorf("error verifying user: %w", err)
+		}
+		user.Verified = true
+		user.Password = ""
+	}
+
+	_, err = tx.Exec(`INSERT INTO carts (userid) VALUES ($1) ON CONFLICT (userid) DO NOTHING`, user.UserId)
+	if err != nil {
+		return nil, fmt.Errorf("error creating cart for user: %w", err)
+	}
+
+	insertIdentityQuery := `
+	INSERT INTO user_identities (userid, provider, subject, email)
+	VALUES ($1, $2, $3, $4)
+	`
+	_, err = tx.Exec(insertIdentityQuery, user.UserId, identity.Provider, identity.Subject, identity.Email)
+	if err != nil {
+		return nil, fmt.Errorf("error linking identity: %w", err)
+	}
+
+	return user, nil
+}
+
+// LoginMFA completes a login started by Login for a user with MFA enabled
+func (us *UserService) LoginMFA(request *models.MFALoginRequest, info *models.SessionInfo) (*models.LoginResult, error) {
+	userId, err := helpers.ParseMFAToken(request.MFAToken)
+	if err != nil {
+		return nil, err
+	}
+
+	var user models.User
+	err = us.DB.Get(&user, `SELECT * FROM users WHERE userid = $1`, userId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch user: %w", err)
+	}
+
+	// MFA codes count towards the same limits as passwords
+	if err := us.lt.Check(user.Email, info.IPAddress); err != nil {
+		return nil, err
+	}
+
+	if err := us.ms.Verify(userId, request.Code); err != nil {
+		if !strings.Contains(err.Error(), "failed to") {
+			if failErr := us.loginFailed(user.Email, info.IPAddress, &user); failErr != nil {
+				return nil, failErr
+			}
+		}
+		return nil, err
+	}
+
+	if err := us.lt.RegisterSuccess(user.Email); err != nil {
+		return nil, err
+	}
+
+	return us.startSession(&user, info, "mfa")
+}
+
+func (us *UserService) startSession(user *models.User, info *models.SessionInfo, method string) (_ *models.LoginResult, err error) {
+	tx, err := us.DB.Beginx()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		} else if err != nil {
+			tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
+
+	sessionId, err := us.ss.CreateSession(tx, user.UserId.String(), info)
+	if err != nil {
+		return nil, err
 	}
 
-	accessToken, err := helpers.GenerateAccessToken(user.UserId.String(), user.Email, user.Role)
+	accessToken, refreshToken, err := us.ts.IssueTokens(tx, user, sessionId)
 	if err != nil {
-		return nil, "", "", fmt.Errorf("error generating access token: %w", err)
+		return nil, err
 	}
 
-	refreshToken, err := helpers.GenerateRefreshToken(user.UserId.String(), user.Email, user.Role)
+	err = us.aus.Record(tx, &models.AuditEntry{
+		Action:     AuditLogin,
+		ActorId:    user.UserId.String(),
+		IPAddress:  info.IPAddress,
+		TargetType: auditTargetUser,
+		TargetId:   user.UserId.String(),
+		Metadata:   map[string]any{"method": method, "sessionId": sessionId, "device": info.Device},
+	})
 	if err != nil {
-		return nil, "", "", fmt.Errorf("error generating refresh Token: %w", err)
+		return nil, err
 	}
 
-	return &user, accessToken, refreshToken, nil
+	return &models.LoginResult{User: user, AccessToken: accessToken, RefreshToken: refreshToken}, nil
 }
 
 func (us *UserService) Signup(user *models.User) error {
@@ -75,33 +387,134 @@ func (us *UserService) Signup(user *models.User) error {
 	}
 
 	user.Password = hashedPassword
-	user.VerifiedToken = uuid.New().String()
+	// Vendors are only created by approving a vendor application
+	user.Role = helpers.RoleCustomer
+
+	// The verification email goes through the outbox, so a mail server outage doesn't
+	// fail the signup
+	return us.insertUser(user)
+}
+
+// insertUser stores a new unverified user and queues their verification email
+func (us *UserService) insertUser(user *models.User) (err error) {
+	tx, err := us.DB.Beginx()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
 
-	fmt.Println(user.PhoneNumber)
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		} else if err != nil {
+			tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
 
 	// Insert into DB and return inserted user
 	query := `
 		INSERT INTO users (userid, name, email, password, phone_number, verification_token, role)
-		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		VALUES ($1, $2, $3, $4, $5, '', $6)
 	`
-	_, err = us.DB.Exec(query, user.UserId, user.Name, user.Email, user.Password, user.PhoneNumber, user.VerifiedToken, user.Role)
+	_, err = tx.Exec(query, user.UserId, user.Name, user.Email, user.Password, user.PhoneNumber, user.Role)
 	if err != nil {
 		return fmt.Errorf("error inserting user: %w", err)
 	}
 
-	err = helpers.SendVerificationEmail(user.VerifiedToken, user.Email)
+	return us.sendVerification(tx, user)
+}
+
+// createVerification replaces any unused verification token of the user with a new one.
+// Only its hash is stored.
+func (us *UserService) createVerification(db sqlx.Ext, userId string) (string, error) {
+	token, err := helpers.GenerateSecureToken()
+	if err != nil {
+		return "", fmt.Errorf("failed to generate verification token: %w", err)
+	}
+
+	// Only the most recent link should work
+	insertQuery := `
+	WITH invalidated AS (
+		UPDATE email_verifications SET usedat = CURRENT_TIMESTAMP
+		WHERE userid = $1 AND usedat IS NULL
+	)
+	INSERT INTO email_verifications (userid, token_hash, expiresat)
+	VALUES ($1, $2, $3)
+	`
+	_, err = db.Exec(insertQuery, userId, helpers.HashToken(token), time.Now().Add(verificationTTL))
 	if err != nil {
-		return fmt.Errorf("could not send verification email: %w", err)
+		return "", fmt.Errorf("failed to store verification token: %w", err)
 	}
 
-	return nil
+	return token, nil
+}
+
+// sendVerification creates a new verification token and queues the email with the link
+func (us *UserService) sendVerification(tx *sqlx.Tx, user *models.User) error {
+	token, err := us.createVerification(tx, user.UserId.String())
+	if err != nil {
+		return err
+	}
+	email, err := helpers.VerificationEmail(token, user.Email)
+	if err != nil {
+		return err
+	}
+	return us.obs.EnqueueEmail(tx, email)
 }
 
-func (us *UserService) UpdateUser(user *models.User, userId string) (*models.User, string, string, error) {
+// ResendVerification emails a new verification link. It returns nil without sending
+// anything for unknown or already verified emails so it can't be used to find accounts;
+// the rate limits apply to any email for the same reason.
+func (us *UserService) ResendVerification(email, ip string) (err error) {
+	email = strings.TrimSpace(email)
+	if err := us.lt.Limit("verify-resend-ip:"+ip, verificationResendIPMax, verificationResendWindow, 0); err != nil {
+		return err
+	}
+	if err := us.lt.Limit("verify-resend:"+strings.ToLower(email), verificationResendMax, verificationResendWindow, verificationResendInterval); err != nil {
+		return err
+	}
+
+	var user models.User
+	err = us.DB.Get(&user, `SELECT * FROM users WHERE email = $1`, email)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		return fmt.Errorf("failed to fetch user: %w", err)
+	}
+	if user.Verified {
+		return nil
+	}
+
+	tx, err := us.DB.Beginx()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		} else if err != nil {
+			tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
+
+	return us.sendVerification(tx, &user)
+}
+
+// UpdateUser updates the profile. A new email is not written to users.email; it starts
+// a pending change that the new address has to confirm, and the bool result reports that.
+func (us *UserService) UpdateUser(user *models.User, userId, sessionId, ip string) (_ *models.User, _ bool, err error) {
 	setClauses := []string{}
 	args := []any{}
 	argIndex := 1
-	needNewTokens := false
+	passwordChanged := false
+	newEmail := ""
 
 	if user.Name != "" {
 		setClauses = append(setClauses, fmt.Sprintf("name = $%d", argIndex))
@@ -111,23 +524,20 @@ func (us *UserService) UpdateUser(user *models.User, userId string) (*models.Use
 
 	if user.Email != "" {
 		if !helpers.IsValidEmail(user.Email) {
-			return nil, "", "", fmt.Errorf("invalid email format")
+			return nil, false, fmt.Errorf("invalid email format")
 		}
-
-		setClauses = append(setClauses, fmt.Sprintf("email = $%d", argIndex))
-		args = append(args, user.Email)
-		argIndex++
-		needNewTokens = true
+		newEmail = user.Email
 	}
 
 	if user.Password != "" {
 		hashedPassword, err := helpers.HashPassword(user.Password)
 		if err != nil {
-			return nil, "", "", fmt.Errorf("failed to hash password: %w", err)
+			return nil, false, fmt.Errorf("failed to hash password: %w", err)
 		}
 		setClauses = append(setClauses, fmt.Sprintf("password = $%d", argIndex))
 		args = append(args, hashedPassword)
 		argIndex++
+		passwordChanged = true
 	}
 
 	if user.PhoneNumber != "" {
@@ -138,8 +548,30 @@ func (us *UserService) UpdateUser(user *models.User, userId string) (*models.Use
 
 	setClauses = append(setClauses, "updatedat = CURRENT_TIMESTAMP")
 
-	if len(setClauses) == 1 {
-		return nil, "", "", fmt.Errorf("no fields to update")
+	if len(setClauses) == 1 && newEmail == "" {
+		return nil, false, fmt.Errorf("no fields to update")
+	}
+
+	tx, err := us.DB.Beginx()
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		} else if err != nil {
+			tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
+
+	var before models.User
+	err = tx.Get(&before, `SELECT * FROM users WHERE userid = $1 FOR UPDATE`, userId)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to fetch user: %w", err)
 	}
 
 	query := fmt.Sprintf(`
@@ -151,43 +583,132 @@ func (us *UserService) UpdateUser(user *models.User, userId string) (*models.Use
 
 	args = append(args, userId)
 
-	err := us.DB.Get(user, query, args...)
+	err = tx.Get(user, query, args...)
 	if err != nil {
-		return nil, "", "", fmt.Errorf("failed to update user: %w", err)
+		return nil, false, fmt.Errorf("failed to update user: %w", err)
 	}
 
-	if needNewTokens {
-		accessToken, err := helpers.GenerateAccessToken(user.UserId.String(), user.Email, user.Role)
+	audit := &models.AuditEntry{
+		Action:     AuditUserUpdated,
+		ActorId:    userId,
+		IPAddress:  ip,
+		TargetType: auditTargetUser,
+		TargetId:   userId,
+	}
+	audit.Changes, err = helpers.AuditDiff(before, user, "password", "verificationToken", "updatedAt")
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to compare user: %w", err)
+	}
+	if len(audit.Changes) > 0 {
+		err = us.aus.Record(tx, audit)
 		if err != nil {
-			return nil, "", "", fmt.Errorf("error generating access token: %w", err)
+			return nil, false, err
 		}
+	}
 
-		refreshToken, err := helpers.GenerateRefreshToken(user.UserId.String(), user.Email, user.Role)
+	// A password change logs the user out of every other device
+	if passwordChanged {
+		err = us.ss.RevokeOtherSessions(tx, userId, sessionId)
 		if err != nil {
-			return nil, "", "", fmt.Errorf("error generating refresh Token: %w", err)
+			return nil, false, fmt.Errorf("failed to revoke other sessions: %w", err)
 		}
 
-		return user, accessToken, refreshToken, nil
+		audit.Action = AuditPasswordChanged
+		audit.Changes = nil
+		err = us.aus.Record(tx, audit)
+		if err != nil {
+			return nil, false, err
+		}
 	}
 
-	return user, "", "", nil
+	emailChangePending := false
+	if newEmail != "" && !strings.EqualFold(newEmail, user.Email) {
+		err = us.requestEmailChange(tx, user, newEmail)
+		if err != nil {
+			return nil, false, err
+		}
+		emailChangePending = true
+
+		audit.Action = AuditEmailChangeRequested
+		audit.Changes = map[string]models.AuditChange{"email": {From: user.Email, To: newEmail}}
+		err = us.aus.Record(tx, audit)
+		if err != nil {
+			return nil, false, err
+		}
+	}
+
+	return user, emailChangePending, nil
 }
 
-func (us *UserService) VerifyUser(verificationToken string) error {
-	var user models.User
-	query := `
-	SELECT userid, name, email, password, phone_number, verified, verification_token, role FROM users WHERE verification_token = $1
+// requestEmailChange stores a pending change and emails a confirm link to the new address
+// and a cancel link to the current one
+func (us *UserService) requestEmailChange(tx *sqlx.Tx, user *models.User, newEmail string) error {
+	var count int
+	err := tx.Get(&count, `SELECT COUNT(*) FROM users WHERE email = $1`, newEmail)
+	if err != nil {
+		return fmt.Errorf("failed to check email availability: %w", err)
+	}
+	if count != 0 {
+		return fmt.Errorf("email already taken")
+	}
+
+	confirmToken, err := helpers.GenerateSecureToken()
+	if err != nil {
+		return fmt.Errorf("failed to generate confirm token: %w", err)
+	}
+	cancelToken, err := helpers.GenerateSecureToken()
+	if err != nil {
+		return fmt.Errorf("failed to generate cancel token: %w", err)
+	}
+
+	// Only the most recent request can be confirmed
+	_, err = tx.Exec(`
+		UPDATE email_changes SET cancelledat = CURRENT_TIMESTAMP
+		WHERE userid = $1 AND confirmedat IS NULL AND cancelledat IS NULL
+	`, user.UserId)
+	if err != nil {
+		return fmt.Errorf("failed to cancel old email changes: %w", err)
+	}
+
+	insertQuery := `
+	INSERT INTO email_changes (userid, old_email, new_email, confirm_token_hash, cancel_token_hash, expiresat)
+	VALUES ($1, $2, $3, $4, $5, $6)
 	`
-	// Get User
-	err := us.DB.Get(&user, query, verificationToken)
+	_, err = tx.Exec(insertQuery,
+		user.UserId,
+		user.Email,
+		newEmail,
+		helpers.HashToken(confirmToken),
+		helpers.HashToken(cancelToken),
+		time.Now().Add(emailChangeTTL),
+	)
 	if err != nil {
-		return fmt.Errorf("failed to fetch user: %w", err)
+		return fmt.Errorf("failed to store email change: %w", err)
 	}
 
-	if user.Verified {
-		return fmt.Errorf("user already verified, logging in")
+	confirmEmail, err := helpers.EmailChangeVerificationEmail(confirmToken, newEmail)
+	if err != nil {
+		return err
+	}
+	err = us.obs.EnqueueEmail(tx, confirmEmail)
+	if err != nil {
+		return err
+	}
+
+	noticeEmail, err := helpers.EmailChangeNoticeEmail(cancelToken, user.Email, newEmail)
+	if err != nil {
+		return err
+	}
+	err = us.obs.EnqueueEmail(tx, noticeEmail)
+	if err != nil {
+		return err
 	}
 
+	return nil
+}
+
+// ConfirmEmailChange applies a pending email change using the token sent to the new address
+func (us *UserService) ConfirmEmailChange(confirmToken, ip string) (err error) {
 	tx, err := us.DB.Beginx()
 	if err != nil {
 		return fmt.Errorf("failed to begin transaction: %w", err)
@@ -204,20 +725,206 @@ func (us *UserService) VerifyUser(verificationToken string) error {
 		}
 	}()
 
-	verificationQuery := `
-	UPDATE users
-	SET verified = $1, verification_token = $2
-	WHERE verification_token = $3
-	`
-	_, err = tx.Exec(verificationQuery, true, "", verificationToken)
+	var change models.EmailChange
+	err = tx.Get(&change, `SELECT * FROM email_changes WHERE confirm_token_hash = $1 FOR UPDATE`, helpers.HashToken(confirmToken))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("invalid or expired confirm token")
+		}
+		return fmt.Errorf("failed to fetch email change: %w", err)
+	}
+
+	if change.ConfirmedAt != nil || change.CancelledAt != nil || time.Now().After(change.ExpiresAt) {
+		err = fmt.Errorf("invalid or expired confirm token")
+		return err
+	}
+
+	// The address may have been registered since the change was requested
+	var count int
+	err = tx.Get(&count, `SELECT COUNT(*) FROM users WHERE email = $1`, change.NewEmail)
+	if err != nil {
+		return fmt.Errorf("failed to check email availability: %w", err)
+	}
+	if count != 0 {
+		err = fmt.Errorf("email already taken")
+		return err
+	}
+
+	res, err := tx.Exec(`
+		UPDATE users SET email = $1, updatedat = CURRENT_TIMESTAMP
+		WHERE userid = $2 AND email = $3
+	`, change.NewEmail, change.UserId, change.OldEmail)
+	if err != nil {
+		return fmt.Errorf("failed to update email: %w", err)
+	}
+
+	affectedRows, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to check updated rows: %w", err)
+	}
+	if affectedRows == 0 {
+		err = fmt.Errorf("invalid or expired confirm token")
+		return err
+	}
+
+	_, err = tx.Exec(`UPDATE email_changes SET confirmedat = CURRENT_TIMESTAMP WHERE changeid = $1`, change.ChangeId)
+	if err != nil {
+		return fmt.Errorf("failed to mark email change as confirmed: %w", err)
+	}
+
+	err = us.aus.Record(tx, &models.AuditEntry{
+		Action:     AuditEmailChanged,
+		IPAddress:  ip,
+		TargetType: auditTargetUser,
+		TargetId:   change.UserId.String(),
+		Changes:    map[string]models.AuditChange{"email": {From: change.OldEmail, To: change.NewEmail}},
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// CancelEmailChange uses the token sent to the old address. A pending change is dropped;
+// a confirmed one is reverted and every session is revoked, since the account may have
+// been taken over.
+func (us *UserService) CancelEmailChange(cancelToken, ip string) (err error) {
+	tx, err := us.DB.Beginx()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		} else if err != nil {
+			tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
+
+	var change models.EmailChange
+	err = tx.Get(&change, `SELECT * FROM email_changes WHERE cancel_token_hash = $1 FOR UPDATE`, helpers.HashToken(cancelToken))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("invalid or expired cancel token")
+		}
+		return fmt.Errorf("failed to fetch email change: %w", err)
+	}
+
+	if change.CancelledAt != nil || time.Now().After(change.CreatedAt.Add(emailChangeRevertWindow)) {
+		err = fmt.Errorf("invalid or expired cancel token")
+		return err
+	}
+
+	if change.ConfirmedAt != nil {
+		var res sql.Result
+		res, err = tx.Exec(`
+			UPDATE users SET email = $1, updatedat = CURRENT_TIMESTAMP
+			WHERE userid = $2 AND email = $3
+		`, change.OldEmail, change.UserId, change.NewEmail)
+		if err != nil {
+			return fmt.Errorf("failed to restore email: %w", err)
+		}
+
+		var affectedRows int64
+		affectedRows, err = res.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("failed to check updated rows: %w", err)
+		}
+		if affectedRows == 0 {
+			err = fmt.Errorf("email has changed again and can't be restored")
+			return err
+		}
+
+		err = us.ss.RevokeOtherSessions(tx, change.UserId.String(), "")
+		if err != nil {
+			return fmt.Errorf("failed to revoke sessions: %w", err)
+		}
+	}
+
+	_, err = tx.Exec(`UPDATE email_changes SET cancelledat = CURRENT_TIMESTAMP WHERE changeid = $1`, change.ChangeId)
+	if err != nil {
+		return fmt.Errorf("failed to cancel email change: %w", err)
+	}
+
+	audit := &models.AuditEntry{
+		Action:     AuditEmailChangeCancelled,
+		IPAddress:  ip,
+		TargetType: auditTargetUser,
+		TargetId:   change.UserId.String(),
+		Metadata:   map[string]any{"newEmail": change.NewEmail},
+	}
+	if change.ConfirmedAt != nil {
+		audit.Changes = map[string]models.AuditChange{"email": {From: change.NewEmail, To: change.OldEmail}}
+	}
+	err = us.aus.Record(tx, audit)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (us *UserService) VerifyUser(verificationToken string) (err error) {
+	tx, err := us.DB.Beginx()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		} else if err != nil {
+			tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
+
+	var verification models.EmailVerification
+	query := `SELECT * FROM email_verifications WHERE token_hash = $1 FOR UPDATE`
+	err = tx.Get(&verification, query, helpers.HashToken(verificationToken))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("invalid or expired verification token")
+		}
+		return fmt.Errorf("failed to fetch verification token: %w", err)
+	}
+
+	if verification.UsedAt != nil || time.Now().After(verification.ExpiresAt) {
+		err = fmt.Errorf("invalid or expired verification token, please request a new one")
+		return err
+	}
+
+	var verified bool
+	err = tx.Get(&verified, `SELECT verified FROM users WHERE userid = $1 FOR UPDATE`, verification.UserId)
+	if err != nil {
+		return fmt.Errorf("failed to fetch user: %w", err)
+	}
+	if verified {
+		err = fmt.Errorf("user already verified, logging in")
+		return err
+	}
+
+	_, err = tx.Exec(`UPDATE users SET verified = TRUE, updatedat = CURRENT_TIMESTAMP WHERE userid = $1`, verification.UserId)
 	if err != nil {
 		return fmt.Errorf("error verifying user: %w", err)
 	}
 
+	_, err = tx.Exec(`UPDATE email_verifications SET usedat = CURRENT_TIMESTAMP WHERE verificationid = $1`, verification.VerificationId)
+	if err != nil {
+		return fmt.Errorf("failed to mark verification token as used: %w", err)
+	}
+
 	cartQuery := `
 	INSERT INTO carts (userid)
 	VALUES ($1)`
-	_, err = tx.Exec(cartQuery, user.UserId)
+	_, err = tx.Exec(cartQuery, verification.UserId)
 	if err != nil {
 		return fmt.Errorf("error creating cart for new user: %w", err)
 	}
@@ -236,3 +943,147 @@ func (us *UserService) GetUserProfile(userId string) (*models.User, error) {
 
 	return &user, nil
 }
+
+func (us *UserService) RefreshTokens(refreshToken string) (*models.User, string, string, error) {
+	return us.ts.Refresh(refreshToken)
+}
+
+// ForgotPassword emails a reset link if the address belongs to an account. A missing
+// account is not an error so callers can't use it to probe for registered emails.
+func (us *UserService) ForgotPassword(email string) (err error) {
+	var userId string
+	err = us.DB.Get(&userId, `SELECT userid FROM users WHERE email = $1`, email)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		return fmt.Errorf("failed to fetch user: %w", err)
+	}
+
+	token, err := helpers.GenerateSecureToken()
+	if err != nil {
+		return fmt.Errorf("failed to generate reset token: %w", err)
+	}
+
+	tx, err := us.DB.Beginx()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		} else if err != nil {
+			tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
+
+	// Only the most recent link should work
+	_, err = tx.Exec(`UPDATE password_resets SET usedat = CURRENT_TIMESTAMP WHERE userid = $1 AND usedat IS NULL`, userId)
+	if err != nil {
+		return fmt.Errorf("failed to invalidate old reset tokens: %w", err)
+	}
+
+	insertQuery := `
+	INSERT INTO password_resets (userid, token_hash, expiresat)
+	VALUES ($1, $2, $3)
+	`
+	_, err = tx.Exec(insertQuery, userId, helpers.HashToken(token), time.Now().Add(passwordResetTTL))
+	if err != nil {
+		return fmt.Errorf("failed to store reset token: %w", err)
+	}
+
+	resetEmail, err := helpers.PasswordResetEmail(token, email)
+	if err != nil {
+		return err
+	}
+	err = us.obs.EnqueueEmail(tx, resetEmail)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// UnlockAccount lifts a login lockout using the token from the unlock email
+func (us *UserService) UnlockAccount(unlockToken string) error {
+	return us.lt.Unlock(unlockToken)
+}
+
+func (us *UserService) ResetPassword(resetToken, newPassword, ip string) (err error) {
+	if newPassword == "" {
+		return fmt.Errorf("password is required")
+	}
+
+	tx, err := us.DB.Beginx()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		} else if err != nil {
+			tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
+
+	var reset models.PasswordReset
+	err = tx.Get(&reset, `SELECT * FROM password_resets WHERE token_hash = $1 FOR UPDATE`, helpers.HashToken(resetToken))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("invalid or expired reset token")
+		}
+		return fmt.Errorf("failed to fetch reset token: %w", err)
+	}
+
+	if reset.UsedAt != nil || time.Now().After(reset.ExpiresAt) {
+		err = fmt.Errorf("invalid or expired reset token")
+		return err
+	}
+
+	hashedPassword, err := helpers.HashPassword(newPassword)
+	if err != nil {
+		return fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	var email string
+	err = tx.Get(&email, `UPDATE users SET password = $1, updatedat = CURRENT_TIMESTAMP WHERE userid = $2 RETURNING email`, hashedPassword, reset.UserId)
+	if err != nil {
+		return fmt.Errorf("failed to update password: %w", err)
+	}
+
+	_, err = tx.Exec(`UPDATE password_resets SET usedat = CURRENT_TIMESTAMP WHERE resetid = $1`, reset.ResetId)
+	if err != nil {
+		return fmt.Errorf("failed to mark reset token as used: %w", err)
+	}
+
+	// Whoever knew the old password shouldn't stay logged in
+	err = us.ss.RevokeOtherSessions(tx, reset.UserId.String(), "")
+	if err != nil {
+		return fmt.Errorf("failed to revoke sessions: %w", err)
+	}
+
+	err = us.aus.Record(tx, &models.AuditEntry{
+		Action:     AuditPasswordReset,
+		IPAddress:  ip,
+		TargetType: auditTargetUser,
+		TargetId:   reset.UserId.String(),
+	})
+	if err != nil {
+		return err
+	}
+
+	// A new password also lifts any lockout from failed logins
+	if err := us.lt.RegisterSuccess(email); err != nil {
+		log.Printf("error clearing login attempts: %v", err)
+	}
+
+	return nil
+}
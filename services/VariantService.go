@@ -0,0 +1,547 @@
+package services
+
+import (
+	"database/sql"
+	"eCommerce/helpers"
+	"eCommerce/models"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+const (
+	maxProductOptions  = 3
+	maxProductVariants = 100
+)
+
+// activeVariantStock is what products.stock is kept at, so listings, facets and stock
+// notifications don't need to know about variants
+const activeVariantStock = `(
+	SELECT COALESCE(SUM(v.stock), 0) FROM product_variants v
+	WHERE v.productid = products.productid AND v.is_active
+)`
+
+type VariantService struct {
+	DB *sqlx.DB
+	vs VendorService
+}
+
+func NewVariantService(db *sqlx.DB, vs VendorService) *VariantService {
+	return &VariantService{
+		db,
+		vs,
+	}
+}
+
+// syncProductStock sets a product's stock to the stock of its active variants. It is
+// called whenever a variant's stock or active flag changes.
+func syncProductStock(db sqlx.Ext, productId string) (*models.Product, error) {
+	var product models.Product
+	query := `UPDATE products SET stock = ` + activeVariantStock + ` WHERE productid = $1 RETURNING *`
+	err := sqlx.Get(db, &product, query, productId)
+	if err != nil {
+		return nil, fmt.Errorf("error updating product stock: %w", err)
+	}
+	return &product, nil
+}
+
+// loadProductVariants returns a product's options and variants. Shoppers only get the
+// active variants.
+func loadProductVariants(db sqlx.Ext, productId string, activeOnly bool) (*models.ProductVariants, error) {
+	matrix := &models.ProductVariants{
+		Options:  []*models.ProductOption{},
+		Variants: []*models.ProductVariant{},
+	}
+
+	err := sqlx.Select(db, &matrix.Options, `SELECT * FROM product_options WHERE productid = $1 ORDER BY position`, productId)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching product options: %w", err)
+	}
+
+	query := `SELECT * FROM product_variants WHERE productid = $1`
+	if activeOnly {
+		query += ` AND is_active`
+	}
+	query += ` ORDER BY createdat, sku`
+	err = sqlx.Select(db, &matrix.Variants, query, productId)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching product variants: %w", err)
+	}
+
+	return matrix, nil
+}
+
+// resolveVariant returns the variant a shopper picked. Without a variantId the product's
+// only active variant is used, so products without options are added as before.
+func resolveVariant(db sqlx.Ext, productId, variantId string) (*models.ProductVariant, error) {
+	var variants []*models.ProductVariant
+	var err error
+	if variantId != "" {
+		err = sqlx.Select(db, &variants, `SELECT * FROM product_variants WHERE variantid = $1`, variantId)
+	} else {
+		err = sqlx.Select(db, &variants, `SELECT * FROM product_variants WHERE productid = $1 AND is_active`, productId)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error fetching variant: %w", err)
+	}
+
+	switch {
+	case len(variants) == 0:
+		return nil, fmt.Errorf("this product is not available")
+	case len(variants) > 1:
+		return nil, fmt.Errorf("variantId is required, this product comes in several options")
+	}
+
+	variant := variants[0]
+	if productId != "" && variant.ProductId != productId {
+		return nil, fmt.Errorf("the variant is not one of this product's")
+	}
+	if !variant.IsActive {
+		return nil, fmt.Errorf("this variant is not available")
+	}
+
+	return variant, nil
+}
+
+func checkSKUAvailable(db sqlx.Ext, sku, variantId string) error {
+	var taken bool
+	query := `SELECT EXISTS (SELECT 1 FROM product_variants WHERE sku = $1 AND variantid::text <> $2)`
+	err := sqlx.Get(db, &taken, query, sku, variantId)
+	if err != nil {
+		return fmt.Errorf("error checking sku: %w", err)
+	}
+	if taken {
+		return fmt.Errorf("sku %q is already in use", sku)
+	}
+	return nil
+}
+
+// validateOptions trims the options a vendor sent and checks they expand to a sensible
+// number of variants. Names and values are compared case-insensitively, as they end up
+// in the generated SKUs.
+func validateOptions(options []*models.ProductOptionInput) ([]*models.ProductOptionInput, error) {
+	if len(options) > maxProductOptions {
+		return nil, fmt.Errorf("a product can have at most %d options", maxProductOptions)
+	}
+
+	cleaned := make([]*models.ProductOptionInput, 0, len(options))
+	names := map[string]bool{}
+	variants := 1
+	for _, option := range options {
+		name := strings.TrimSpace(option.Name)
+		if name == "" {
+			return nil, fmt.Errorf("option names are required")
+		}
+		if names[strings.ToLower(name)] {
+			return nil, fmt.Errorf("option %s is listed twice", name)
+		}
+		names[strings.ToLower(name)] = true
+
+		if len(option.Values) == 0 {
+			return nil, fmt.Errorf("option %s needs at least one value", name)
+		}
+		values := make([]string, 0, len(option.Values))
+		seen := map[string]bool{}
+		for _, value := range option.Values {
+			value = strings.TrimSpace(value)
+			if value == "" {
+				return nil, fmt.Errorf("option %s has an empty value", name)
+			}
+			if seen[strings.ToLower(value)] {
+				return nil, fmt.Errorf("option %s lists %s twice", name, value)
+			}
+			seen[strings.ToLower(value)] = true
+			values = append(values, value)
+		}
+
+		variants *= len(values)
+		if variants > maxProductVariants {
+			return nil, fmt.Errorf("the options make more than %d variants", maxProductVariants)
+		}
+		cleaned = append(cleaned, &models.ProductOptionInput{Name: name, Values: values})
+	}
+
+	return cleaned, nil
+}
+
+// optionCombinations expands options into every combination of their values. No options
+// is the single empty combination of a product's default variant.
+func optionCombinations(options []*models.ProductOptionInput) []map[string]string {
+	combinations := []map[string]string{{}}
+	for _, option := range options {
+		next := make([]map[string]string, 0, len(combinations)*len(option.Values))
+		for _, combination := range combinations {
+			for _, value := range option.Values {
+				expanded := maps.Clone(combination)
+				expanded[option.Name] = value
+				next = append(next, expanded)
+			}
+		}
+		combinations = next
+	}
+	return combinations
+}
+
+// variantKey identifies a combination regardless of the order its keys were stored in;
+// json.Marshal sorts map keys.
+func variantKey(options json.RawMessage) (string, error) {
+	combination := map[string]string{}
+	if err := json.Unmarshal(options, &combination); err != nil {
+		return "", fmt.Errorf("failed to decode variant options: %w", err)
+	}
+	key, err := json.Marshal(combination)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode variant options: %w", err)
+	}
+	return string(key), nil
+}
+
+// variantSKU generates the SKU of a new variant from the product's SKU and its values in
+// option order, e.g. TSHIRT-M-RED. The default variant gets the product's SKU.
+func variantSKU(productSKU string, options []*models.ProductOptionInput, combination map[string]string) string {
+	parts := []string{productSKU}
+	for _, option := range options {
+		value := combination[option.Name]
+		part := strings.ToUpper(helpers.Slugify(value))
+		if part == "" {
+			// Values without letters or digits, like "½", are numbered instead
+			part = strconv.Itoa(slices.Index(option.Values, value) + 1)
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, "-")
+}
+
+// availableVariantSKU returns sku, or when another variant already uses it, sku with the
+// first free number appended. Deactivated variants keep their SKU, so renaming an option
+// generates the same SKUs again.
+func availableVariantSKU(db sqlx.Ext, sku string) (string, error) {
+	candidate := sku
+	for n := 2; ; n++ {
+		var taken bool
+		err := sqlx.Get(db, &taken, `SELECT EXISTS (SELECT 1 FROM product_variants WHERE sku = $1)`, candidate)
+		if err != nil {
+			return "", fmt.Errorf("error checking sku: %w", err)
+		}
+		if !taken {
+			return candidate, nil
+		}
+		candidate = fmt.Sprintf("%s-%d", sku, n)
+	}
+}
+
+// recordVariantChange finishes a change to a product's variants: it updates the product's
+// stock, records the change and tells shoppers waiting for the product when it is back
+func (vrs *VariantService) recordVariantChange(tx *sqlx.Tx, vendorId, ip string, product *models.Product, before, after *models.ProductVariants) error {
+	synced, err := syncProductStock(tx, product.ProductId)
+	if err != nil {
+		return err
+	}
+
+	changes, err := helpers.AuditDiff(before, after)
+	if err != nil {
+		return fmt.Errorf("failed to compare variants: %w", err)
+	}
+	if len(changes) > 0 {
+		err = vrs.vs.auditProduct(tx, AuditProductVariantsUpdated, product.ProductId, vendorId, ip, changes)
+		if err != nil {
+			return err
+		}
+	}
+
+	if isBackInStock(product, synced) {
+		err = vrs.vs.ns.NotifyBackInStock(tx, synced)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetVariants lists a vendor's product options and all its variants, including the
+// inactive ones
+func (vrs *VariantService) GetVariants(vendorId, productId string) (*models.ProductVariants, error) {
+	var ownerId string
+	err := vrs.DB.Get(&ownerId, `SELECT vendorid FROM products WHERE productid = $1`, productId)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, models.ErrNotFound
+		}
+		return nil, fmt.Errorf("error fetching product: %w", err)
+	}
+	if ownerId != vendorId {
+		return nil, models.ErrNotFound
+	}
+
+	return loadProductVariants(vrs.DB, productId, false)
+}
+
+// ReplaceOptions sets a product's options and brings its variants in line with them.
+// Combinations that were already offered keep their SKU, price, stock and active flag,
+// new ones are created with a generated SKU and no stock. Variants whose combination is
+// gone are deleted, or deactivated when they were ordered, and are reactivated if their
+// combination comes back.
+func (vrs *VariantService) ReplaceOptions(vendorId, productId, ip string, request *models.ProductOptionsRequest) (_ *models.ProductVariants, err error) {
+	options, err := validateOptions(request.Options)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := vrs.DB.Beginx()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		} else if err != nil {
+			tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
+
+	product, err := lockOwnedProduct(tx, productId, vendorId)
+	if err != nil {
+		return nil, err
+	}
+
+	before, err := loadProductVariants(tx, productId, false)
+	if err != nil {
+		return nil, err
+	}
+
+	previousOptions := make([]*models.ProductOptionInput, 0, len(before.Options))
+	for _, option := range before.Options {
+		previousOptions = append(previousOptions, &models.ProductOptionInput{Name: option.Name, Values: option.Values})
+	}
+	offered := map[string]bool{}
+	for _, combination := range optionCombinations(previousOptions) {
+		key, err := json.Marshal(combination)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode variant options: %w", err)
+		}
+		offered[string(key)] = true
+	}
+
+	existing := map[string]*models.ProductVariant{}
+	for _, variant := range before.Variants {
+		key, err := variantKey(variant.Options)
+		if err != nil {
+			return nil, err
+		}
+		existing[key] = variant
+	}
+
+	combinations := optionCombinations(options)
+	wanted := map[string]map[string]string{}
+	for _, combination := range combinations {
+		key, err := json.Marshal(combination)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode variant options: %w", err)
+		}
+		wanted[string(key)] = combination
+	}
+
+	// Removed first, so a new variant can take over the SKU of one that was dropped
+	removed := []string{}
+	for key, variant := range existing {
+		if _, ok := wanted[key]; !ok {
+			removed = append(removed, variant.VariantId)
+		}
+	}
+	if len(removed) > 0 {
+		_, err = tx.Exec(`
+		DELETE FROM product_variants v
+		WHERE v.variantid = ANY($1)
+			AND NOT EXISTS (SELECT 1 FROM order_items oi WHERE oi.variantid = v.variantid)
+		`, pq.StringArray(removed))
+		if err != nil {
+			return nil, fmt.Errorf("error deleting variants: %w", err)
+		}
+
+		// What is left was ordered and stays for the order history
+		_, err = tx.Exec(`
+		UPDATE product_variants SET is_active = FALSE, updatedat = CURRENT_TIMESTAMP
+		WHERE variantid = ANY($1) AND is_active
+		`, pq.StringArray(removed))
+		if err != nil {
+			return nil, fmt.Errorf("error deactivating variants: %w", err)
+		}
+	}
+
+	_, err = tx.Exec(`DELETE FROM product_options WHERE productid = $1`, productId)
+	if err != nil {
+		return nil, fmt.Errorf("error deleting product options: %w", err)
+	}
+	for position, option := range options {
+		_, err = tx.Exec(`INSERT INTO product_options (productid, name, position, option_values) VALUES ($1, $2, $3, $4)`,
+			productId, option.Name, position, pq.StringArray(option.Values))
+		if err != nil {
+			return nil, fmt.Errorf("error adding product option: %w", err)
+		}
+	}
+
+	for _, combination := range combinations {
+		data, err := json.Marshal(combination)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode variant options: %w", err)
+		}
+		key := string(data)
+
+		variant, ok := existing[key]
+		switch {
+		case !ok:
+			sku, err := availableVariantSKU(tx, variantSKU(product.SKU, options, combination))
+			if err != nil {
+				return nil, err
+			}
+			_, err = tx.Exec(`INSERT INTO product_variants (productid, sku, options) VALUES ($1, $2, $3)`, productId, sku, key)
+			if err != nil {
+				return nil, fmt.Errorf("error adding variant: %w", err)
+			}
+		case !variant.IsActive && !offered[key]:
+			_, err = tx.Exec(`UPDATE product_variants SET is_active = TRUE, updatedat = CURRENT_TIMESTAMP WHERE variantid = $1`, variant.VariantId)
+			if err != nil {
+				return nil, fmt.Errorf("error reactivating variant: %w", err)
+			}
+		}
+	}
+
+	after, err := loadProductVariants(tx, productId, false)
+	if err != nil {
+		return nil, err
+	}
+
+	err = vrs.recordVariantChange(tx, vendorId, ip, product, before, after)
+	if err != nil {
+		return nil, err
+	}
+
+	return after, nil
+}
+
+// UpdateVariants changes the SKU, price, stock or active flag of several variants of a
+// product in one go, e.g. after a stock count
+func (vrs *VariantService) UpdateVariants(vendorId, productId, ip string, request *models.VariantsUpdateRequest) (_ *models.ProductVariants, err error) {
+	if len(request.Variants) == 0 {
+		return nil, fmt.Errorf("no variants to update")
+	}
+
+	tx, err := vrs.DB.Beginx()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		} else if err != nil {
+			tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
+
+	product, err := lockOwnedProduct(tx, productId, vendorId)
+	if err != nil {
+		return nil, err
+	}
+
+	before, err := loadProductVariants(tx, productId, false)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, update := range request.Variants {
+		if update.VariantId == "" {
+			return nil, fmt.Errorf("variantId is required")
+		}
+
+		setClauses := []string{}
+		args := []any{}
+		argsIndex := 1
+
+		if update.SKU != nil {
+			sku := strings.TrimSpace(*update.SKU)
+			if sku == "" {
+				return nil, fmt.Errorf("sku can't be empty")
+			}
+			if err := checkSKUAvailable(tx, sku, update.VariantId); err != nil {
+				return nil, err
+			}
+			setClauses = append(setClauses, fmt.Sprintf("sku = $%d", argsIndex))
+			args = append(args, sku)
+			argsIndex++
+		}
+
+		if update.ClearPrice {
+			setClauses = append(setClauses, "price = NULL")
+		} else if update.Price != nil {
+			if *update.Price < 0 {
+				return nil, fmt.Errorf("price can't be negative")
+			}
+			setClauses = append(setClauses, fmt.Sprintf("price = $%d", argsIndex))
+			args = append(args, *update.Price)
+			argsIndex++
+		}
+
+		if update.Stock != nil {
+			if *update.Stock < 0 {
+				return nil, fmt.Errorf("stock can't be negative")
+			}
+			setClauses = append(setClauses, fmt.Sprintf("stock = $%d", argsIndex))
+			args = append(args, *update.Stock)
+			argsIndex++
+		}
+
+		if update.IsActive != nil {
+			setClauses = append(setClauses, fmt.Sprintf("is_active = $%d", argsIndex))
+			args = append(args, *update.IsActive)
+			argsIndex++
+		}
+
+		if len(setClauses) == 0 {
+			return nil, fmt.Errorf("no fields to update for variant %s", update.VariantId)
+		}
+		setClauses = append(setClauses, "updatedat = CURRENT_TIMESTAMP")
+
+		query := fmt.Sprintf(`
+		UPDATE product_variants
+		SET %s
+		WHERE variantid = $%d AND productid = $%d
+		`, strings.Join(setClauses, ", "), argsIndex, argsIndex+1)
+
+		args = append(args, update.VariantId, productId)
+		res, err := tx.Exec(query, args...)
+		if err != nil {
+			return nil, fmt.Errorf("error updating variant: %w", err)
+		}
+		if rows, _ := res.RowsAffected(); rows == 0 {
+			return nil, fmt.Errorf("variant %s: %w", update.VariantId, models.ErrNotFound)
+		}
+	}
+
+	after, err := loadProductVariants(tx, productId, false)
+	if err != nil {
+		return nil, err
+	}
+
+	err = vrs.recordVariantChange(tx, vendorId, ip, product, before, after)
+	if err != nil {
+		return nil, err
+	}
+
+	return after, nil
+}
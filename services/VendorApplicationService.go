@@ -0,0 +1,249 @@
+package services
+
+import (
+	"database/sql"
+	"eCommerce/helpers"
+	"eCommerce/models"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	ApplicationPending  = "pending"
+	ApplicationApproved = "approved"
+	ApplicationRejected = "rejected"
+)
+
+type VendorApplicationService struct {
+	DB  *sqlx.DB
+	aus AuditService
+}
+
+func NewVendorApplicationService(db *sqlx.DB, aus AuditService) *VendorApplicationService {
+	return &VendorApplicationService{
+		db,
+		aus,
+	}
+}
+
+func (vas *VendorApplicationService) recordEvent(tx *sqlx.Tx, applicationId, status, actorId, note string) error {
+	insertQuery := `
+	INSERT INTO vendor_application_events (applicationid, status, actorid, note)
+	VALUES ($1, $2, $3, $4)
+	`
+	_, err := tx.Exec(insertQuery, applicationId, status, actorId, note)
+	if err != nil {
+		return fmt.Errorf("error recording application event: %w", err)
+	}
+	return nil
+}
+
+func (vas *VendorApplicationService) SubmitApplication(userId string, application *models.VendorApplication) (_ *models.VendorApplication, err error) {
+	if strings.TrimSpace(application.StoreName) == "" || strings.TrimSpace(application.TaxId) == "" {
+		return nil, fmt.Errorf("storeName and taxId are required")
+	}
+	if !helpers.IsValidEmail(application.ContactEmail) {
+		return nil, fmt.Errorf("invalid contact email format")
+	}
+	if application.ContactPhone == "" {
+		return nil, fmt.Errorf("contactPhone is required")
+	}
+
+	var role string
+	err = vas.DB.Get(&role, `SELECT role FROM users WHERE userid = $1`, userId)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching user: %w", err)
+	}
+	if role != helpers.RoleCustomer {
+		return nil, fmt.Errorf("only customers can apply to become vendors")
+	}
+
+	tx, err := vas.DB.Beginx()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		} else if err != nil {
+			tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
+
+	var pending int
+	err = tx.Get(&pending, `SELECT COUNT(*) FROM vendor_applications WHERE userid = $1 AND status = $2`, userId, ApplicationPending)
+	if err != nil {
+		return nil, fmt.Errorf("error checking existing applications: %w", err)
+	}
+	if pending != 0 {
+		err = fmt.Errorf("you already have a pending application")
+		return nil, err
+	}
+
+	var inserted models.VendorApplication
+	insertQuery := `
+	INSERT INTO vendor_applications (userid, store_name, tax_id, contact_email, contact_phone, status)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	RETURNING *
+	`
+	err = tx.Get(&inserted, insertQuery,
+		userId,
+		application.StoreName,
+		application.TaxId,
+		application.ContactEmail,
+		application.ContactPhone,
+		ApplicationPending,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error submitting application: %w", err)
+	}
+
+	err = vas.recordEvent(tx, inserted.ApplicationId, ApplicationPending, userId, "submitted")
+	if err != nil {
+		return nil, err
+	}
+
+	return &inserted, nil
+}
+
+// GetApplication returns the user's latest application. It has no side effects: once it
+// is approved, the client picks up the vendor role by refreshing its tokens.
+func (vas *VendorApplicationService) GetApplication(userId string) (*models.VendorApplication, error) {
+	var application models.VendorApplication
+	query := `SELECT * FROM vendor_applications WHERE userid = $1 ORDER BY createdat DESC LIMIT 1`
+	err := vas.DB.Get(&application, query, userId)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, models.ErrNotFound
+		}
+		return nil, fmt.Errorf("error fetching application: %w", err)
+	}
+
+	return &application, nil
+}
+
+func (vas *VendorApplicationService) GetApplications(status string) ([]*models.VendorApplication, error) {
+	var applications []*models.VendorApplication
+	query := `SELECT * FROM vendor_applications`
+	var args []any
+	if status != "" {
+		query += ` WHERE status = $1`
+		args = append(args, status)
+	}
+	query += ` ORDER BY createdat ASC`
+
+	err := vas.DB.Select(&applications, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching applications: %w", err)
+	}
+
+	return applications, nil
+}
+
+func (vas *VendorApplicationService) GetApplicationEvents(applicationId string) ([]*models.VendorApplicationEvent, error) {
+	var events []*models.VendorApplicationEvent
+	query := `SELECT * FROM vendor_application_events WHERE applicationid = $1 ORDER BY createdat ASC`
+	err := vas.DB.Select(&events, query, applicationId)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching application events: %w", err)
+	}
+
+	return events, nil
+}
+
+// ReviewApplication approves or rejects a pending application. Approval is the only
+// place a user becomes a vendor.
+func (vas *VendorApplicationService) ReviewApplication(reviewerId, applicationId, ip string, decision *models.ApplicationDecision) (_ *models.VendorApplication, err error) {
+	if decision.Status != ApplicationApproved && decision.Status != ApplicationRejected {
+		return nil, fmt.Errorf("decision must be approved or rejected")
+	}
+	if decision.Status == ApplicationRejected && strings.TrimSpace(decision.Reason) == "" {
+		return nil, fmt.Errorf("a reason is required when rejecting an application")
+	}
+
+	tx, err := vas.DB.Beginx()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		} else if err != nil {
+			tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
+
+	var application models.VendorApplication
+	err = tx.Get(&application, `SELECT * FROM vendor_applications WHERE applicationid = $1 FOR UPDATE`, applicationId)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, models.ErrNotFound
+		}
+		return nil, fmt.Errorf("error fetching application: %w", err)
+	}
+
+	if application.Status != ApplicationPending {
+		err = fmt.Errorf("application has already been %s", application.Status)
+		return nil, err
+	}
+
+	updateQuery := `
+	UPDATE vendor_applications
+	SET status = $1, reviewerid = $2, reviewedat = CURRENT_TIMESTAMP, rejection_reason = $3, updatedat = CURRENT_TIMESTAMP
+	WHERE applicationid = $4
+	RETURNING *
+	`
+	err = tx.Get(&application, updateQuery, decision.Status, reviewerId, decision.Reason, applicationId)
+	if err != nil {
+		return nil, fmt.Errorf("error updating application: %w", err)
+	}
+
+	if decision.Status == ApplicationApproved {
+		var res sql.Result
+		res, err = tx.Exec(`
+			UPDATE users SET role = $1, updatedat = CURRENT_TIMESTAMP
+			WHERE userid = $2 AND role = $3
+		`, helpers.RoleVendor, application.UserId, helpers.RoleCustomer)
+		if err != nil {
+			return nil, fmt.Errorf("error updating user role: %w", err)
+		}
+
+		var affectedRows int64
+		affectedRows, err = res.RowsAffected()
+		if err != nil {
+			return nil, fmt.Errorf("error checking updated rows: %w", err)
+		}
+		if affectedRows > 0 {
+			err = vas.aus.Record(tx, &models.AuditEntry{
+				Action:     AuditRoleChanged,
+				ActorId:    reviewerId,
+				IPAddress:  ip,
+				TargetType: auditTargetUser,
+				TargetId:   application.UserId.String(),
+				Changes:    map[string]models.AuditChange{"role": {From: helpers.RoleCustomer, To: helpers.RoleVendor}},
+				Metadata:   map[string]any{"applicationId": applicationId},
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	err = vas.recordEvent(tx, applicationId, decision.Status, reviewerId, decision.Reason)
+	if err != nil {
+		return nil, err
+	}
+
+	return &application, nil
+}
@@ -2,6 +2,7 @@ package services
 
 import (
 	"database/sql"
+	"eCommerce/helpers"
 	"eCommerce/models"
 	"errors"
 	"fmt"
@@ -11,31 +12,119 @@ import (
 )
 
 type VendorService struct {
-	DB *sqlx.DB
+	DB  *sqlx.DB
+	aus AuditService
+	ns  NotificationService
 }
 
-func NewVendorService(db *sqlx.DB) *VendorService {
+func NewVendorService(db *sqlx.DB, aus AuditService, ns NotificationService) *VendorService {
 	return &VendorService{
 		db,
+		aus,
+		ns,
 	}
 }
 
+// auditProduct records a product change. vendorId is kept in the metadata so the
+// vendor's history still includes products that were deleted since.
+func (vs *VendorService) auditProduct(db sqlx.Ext, action, productId, vendorId, ip string, changes map[string]models.AuditChange) error {
+	return vs.aus.Record(db, &models.AuditEntry{
+		Action:     action,
+		ActorId:    vendorId,
+		IPAddress:  ip,
+		TargetType: auditTargetProduct,
+		TargetId:   productId,
+		Changes:    changes,
+		Metadata:   map[string]any{"vendorId": vendorId},
+	})
+}
+
+// isBackInStock reports whether an update made a product purchasable that wasn't
+// before, either by restocking it or by reactivating it with stock left
+func isBackInStock(before, after *models.Product) bool {
+	purchasable := func(p *models.Product) bool {
+		return p.Stock != nil && *p.Stock > 0 && (p.IsActive == nil || *p.IsActive)
+	}
+	return !purchasable(before) && purchasable(after)
+}
+
+// lockOwnedProduct fetches the product for an update or delete and checks it belongs to
+// the vendor
+func lockOwnedProduct(tx *sqlx.Tx, productId, vendorId string) (*models.Product, error) {
+	var product models.Product
+	err := tx.Get(&product, `SELECT * FROM products WHERE productid = $1 FOR UPDATE`, productId)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("product not found")
+		}
+		return nil, fmt.Errorf("error checking product ownership: %w", err)
+	}
+
+	if product.VendorId != vendorId {
+		return nil, fmt.Errorf("unauthorized: you do not own this product")
+	}
+
+	return &product, nil
+}
+
+// productCategoryPath checks the category a vendor picked and returns its path. The
+// category is set by id; the path in category is derived from it.
+func productCategoryPath(tx *sqlx.Tx, product *models.Product) (string, error) {
+	if product.CategoryId == nil {
+		if product.Category != "" {
+			return "", fmt.Errorf("category is set with categoryId, see /catalog/categories")
+		}
+		return "", nil
+	}
+
+	err := lockCategories(tx, false)
+	if err != nil {
+		return "", err
+	}
+	return categoryPath(tx, *product.CategoryId)
+}
+
 // VENDOR ROUTES
 
-func (vs *VendorService) AddProduct(product *models.Product, vendorId string) (*models.Product, error) {
+func (vs *VendorService) AddProduct(product *models.Product, vendorId, ip string) (_ *models.Product, err error) {
+	if product.CategoryId == nil && product.Category == "" {
+		return nil, fmt.Errorf("categoryId is required")
+	}
+
+	tx, err := vs.DB.Beginx()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		} else if err != nil {
+			tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
+
+	path, err := productCategoryPath(tx, product)
+	if err != nil {
+		return nil, err
+	}
+
 	query := `
 	INSERT INTO products (
 	  vendorid, name, description, sku,
-		price, discount, stock, is_active, brand, category
+		price, discount, stock, is_active, brand, categoryid, category
 	) VALUES (
 		$1, $2, $3, $4, 
-	  $5, $6, $7, $8, $9, $10
+	  $5, $6, $7, $8, $9, $10, $11
 	)
 	RETURNING *
 	`
 	var inserted models.Product
 
-	err := vs.DB.Get(&inserted, query,
+	err = tx.Get(&inserted, query,
 		vendorId,
 		product.Name,
 		product.Description,
@@ -45,12 +134,29 @@ func (vs *VendorService) AddProduct(product *models.Product, vendorId string) (*
 		product.Stock,
 		product.IsActive,
 		product.Brand,
-		product.Category,
+		product.CategoryId,
+		path,
 	)
 	if err != nil {
 		return nil, fmt.Errorf("error adding new product: %w", err)
 	}
 
+	// Until the vendor adds options the product is sold as its default variant
+	_, err = tx.Exec(`INSERT INTO product_variants (productid, sku, stock) VALUES ($1, $2, $3)`,
+		inserted.ProductId, inserted.SKU, inserted.Stock)
+	if err != nil {
+		return nil, fmt.Errorf("error adding default variant: %w", err)
+	}
+
+	changes, err := helpers.AuditDiff(models.Product{}, inserted, "ProductId", "vendorId", "createdAt", "updatedAt")
+	if err != nil {
+		return nil, fmt.Errorf("failed to compare product: %w", err)
+	}
+	err = vs.auditProduct(tx, AuditProductCreated, inserted.ProductId, vendorId, ip, changes)
+	if err != nil {
+		return nil, err
+	}
+
 	return &inserted, nil
 }
 
@@ -78,40 +184,66 @@ func (vs *VendorService) GetVendorProducts(vendorId string) ([]*models.Product,
 	return products, nil
 }
 
-func (vs *VendorService) DeleteProduct(productId, vendorId string) error {
-	var ownerId string
-	err := vs.DB.QueryRow(`SELECT vendorid FROM products WHERE productid = $1`, productId).Scan(&ownerId)
+func (vs *VendorService) DeleteProduct(productId, vendorId, ip string) (err error) {
+	tx, err := vs.DB.Beginx()
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return fmt.Errorf("product not found")
-		}
-		return fmt.Errorf("error checking product ownership: %w", err)
+		return fmt.Errorf("failed to begin transaction: %w", err)
 	}
 
-	if ownerId != vendorId {
-		return fmt.Errorf("unauthorized: you do not own this product")
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		} else if err != nil {
+			tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
+
+	product, err := lockOwnedProduct(tx, productId, vendorId)
+	if err != nil {
+		return err
 	}
 
-	_, err = vs.DB.Exec(`DELETE FROM products WHERE productid = $1`, productId)
+	_, err = tx.Exec(`DELETE FROM products WHERE productid = $1`, productId)
 	if err != nil {
 		return fmt.Errorf("error deleting product: %w", err)
 	}
 
+	// The last state of the product is kept so the deletion can be reviewed
+	changes, err := helpers.AuditDiff(product, models.Product{}, "ProductId", "vendorId", "createdAt", "updatedAt")
+	if err != nil {
+		return fmt.Errorf("failed to compare product: %w", err)
+	}
+	err = vs.auditProduct(tx, AuditProductDeleted, productId, vendorId, ip, changes)
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
 
-func (vs *VendorService) UpdateProduct(product *models.Product, vendorId string) (*models.Product, error) {
-	var ownerId string
-	err := vs.DB.QueryRow(`SELECT vendorid FROM products WHERE productid = $1`, product.ProductId).Scan(&ownerId)
+func (vs *VendorService) UpdateProduct(product *models.Product, vendorId, ip string) (_ *models.Product, err error) {
+	tx, err := vs.DB.Beginx()
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return nil, fmt.Errorf("product not found")
-		}
-		return nil, fmt.Errorf("error checking product ownership: %w", err)
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
 	}
 
-	if ownerId != vendorId {
-		return nil, fmt.Errorf("unauthorized: you do not own this product")
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		} else if err != nil {
+			tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
+
+	before, err := lockOwnedProduct(tx, product.ProductId, vendorId)
+	if err != nil {
+		return nil, err
 	}
 
 	setClauses := []string{}
@@ -130,10 +262,25 @@ func (vs *VendorService) UpdateProduct(product *models.Product, vendorId string)
 		argsIndex++
 	}
 
+	// Without options the SKU and stock are the default variant's. With options each
+	// variant has its own, and the product's stock is their sum.
+	var hasOptions bool
+	err = tx.Get(&hasOptions, `SELECT EXISTS (SELECT 1 FROM product_options WHERE productid = $1)`, product.ProductId)
+	if err != nil {
+		return nil, fmt.Errorf("error checking product options: %w", err)
+	}
+
 	if product.SKU != "" {
 		setClauses = append(setClauses, fmt.Sprintf("sku = $%d", argsIndex))
 		args = append(args, product.SKU)
 		argsIndex++
+
+		if !hasOptions {
+			_, err = tx.Exec(`UPDATE product_variants SET sku = $1, updatedat = CURRENT_TIMESTAMP WHERE productid = $2 AND options = '{}'`, product.SKU, product.ProductId)
+			if err != nil {
+				return nil, fmt.Errorf("error updating variant sku: %w", err)
+			}
+		}
 	}
 
 	if product.Price != nil {
@@ -149,9 +296,14 @@ func (vs *VendorService) UpdateProduct(product *models.Product, vendorId string)
 	}
 
 	if product.Stock != nil {
-		setClauses = append(setClauses, fmt.Sprintf("stock = $%d", argsIndex))
-		args = append(args, *product.Stock)
-		argsIndex++
+		if hasOptions {
+			return nil, fmt.Errorf("this product has options, set the stock of its variants instead")
+		}
+		_, err = tx.Exec(`UPDATE product_variants SET stock = $1, updatedat = CURRENT_TIMESTAMP WHERE productid = $2 AND options = '{}'`, *product.Stock, product.ProductId)
+		if err != nil {
+			return nil, fmt.Errorf("error updating variant stock: %w", err)
+		}
+		setClauses = append(setClauses, "stock = "+activeVariantStock)
 	}
 
 	if product.IsActive != nil {
@@ -165,10 +317,14 @@ func (vs *VendorService) UpdateProduct(product *models.Product, vendorId string)
 		argsIndex++
 	}
 
-	if product.Category != "" {
-		setClauses = append(setClauses, fmt.Sprintf("category = $%d", argsIndex))
-		args = append(args, product.Category)
-		argsIndex++
+	path, err := productCategoryPath(tx, product)
+	if err != nil {
+		return nil, err
+	}
+	if product.CategoryId != nil {
+		setClauses = append(setClauses, fmt.Sprintf("categoryid = $%d, category = $%d", argsIndex, argsIndex+1))
+		args = append(args, *product.CategoryId, path)
+		argsIndex += 2
 	}
 
 	setClauses = append(setClauses, "updatedat = CURRENT_TIMESTAMP")
@@ -185,10 +341,28 @@ func (vs *VendorService) UpdateProduct(product *models.Product, vendorId string)
 	`, strings.Join(setClauses, ", "), argsIndex)
 
 	args = append(args, product.ProductId)
-	err = vs.DB.Get(product, query, args...)
+	err = tx.Get(product, query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("error updating product: %w", err)
 	}
 
+	changes, err := helpers.AuditDiff(before, product, "updatedAt")
+	if err != nil {
+		return nil, fmt.Errorf("failed to compare product: %w", err)
+	}
+	if len(changes) > 0 {
+		err = vs.auditProduct(tx, AuditProductUpdated, product.ProductId, vendorId, ip, changes)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if isBackInStock(before, product) {
+		err = vs.ns.NotifyBackInStock(tx, product)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	return product, nil
 }
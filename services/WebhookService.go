@@ -0,0 +1,416 @@
+package services
+
+import (
+	"bytes"
+	"database/sql"
+	"eCommerce/helpers"
+	"eCommerce/models"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// Webhook event types vendors can subscribe to
+const (
+	WebhookOrderCreated       = "order.created"
+	WebhookOrderStatusChanged = "order.status_changed"
+	WebhookReviewCreated      = "review.created"
+	WebhookProductLowStock    = "product.low_stock"
+	// sent by SendTestEvent only, endpoints can't subscribe to it
+	WebhookTest = "webhook.test"
+)
+
+var WebhookEventTypes = []string{
+	WebhookOrderCreated,
+	WebhookOrderStatusChanged,
+	WebhookReviewCreated,
+	WebhookProductLowStock,
+}
+
+const (
+	OutboxKindWebhook = "webhook"
+
+	maxWebhookEndpoints = 10
+	// at most this much of the receiver's response is kept in the delivery log
+	webhookResponseLimit = 2048
+	// product.low_stock fires when a purchase takes the stock below this
+	lowStockThreshold = 5
+)
+
+// webhookMessage is the outbox payload of one delivery. Body is the exact JSON that is
+// signed and sent, so every retry sends the same bytes.
+type webhookMessage struct {
+	EndpointId string          `json:"endpointId"`
+	EventId    string          `json:"eventId"`
+	EventType  string          `json:"eventType"`
+	Body       json.RawMessage `json:"body"`
+}
+
+// WebhookService lets vendors register endpoints that receive their events. Publish
+// queues one outbox message per subscribed endpoint in the caller's transaction; the
+// outbox dispatcher delivers them and retries with backoff, and every attempt is logged
+// in webhook_deliveries.
+type WebhookService struct {
+	DB            *sqlx.DB
+	obs           OutboxService
+	client        *http.Client
+	allowInsecure bool
+}
+
+func NewWebhookService(db *sqlx.DB, obs OutboxService, allowInsecure bool) *WebhookService {
+	ws := &WebhookService{
+		DB:            db,
+		obs:           obs,
+		client:        helpers.NewWebhookClient(allowInsecure),
+		allowInsecure: allowInsecure,
+	}
+
+	obs.Register(OutboxKindWebhook, ws.deliverMessage)
+
+	return ws
+}
+
+func validateEventTypes(eventTypes []string) error {
+	if len(eventTypes) == 0 {
+		return fmt.Errorf("at least one event type is required")
+	}
+	for _, eventType := range eventTypes {
+		if !slices.Contains(WebhookEventTypes, eventType) {
+			return fmt.Errorf("unknown event type %q, use one of %s", eventType, strings.Join(WebhookEventTypes, ", "))
+		}
+	}
+	return nil
+}
+
+// CreateEndpoint registers an endpoint and returns its signing secret. The secret is
+// only returned here.
+func (ws *WebhookService) CreateEndpoint(vendorId string, request *models.WebhookEndpointRequest) (*models.WebhookEndpoint, string, error) {
+	if request.URL == nil {
+		return nil, "", fmt.Errorf("url is required")
+	}
+	if err := helpers.ValidateWebhookURL(*request.URL, ws.allowInsecure); err != nil {
+		return nil, "", err
+	}
+	if err := validateEventTypes(request.EventTypes); err != nil {
+		return nil, "", err
+	}
+
+	var count int
+	err := ws.DB.Get(&count, `SELECT COUNT(*) FROM webhook_endpoints WHERE vendorid = $1`, vendorId)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to count webhook endpoints: %w", err)
+	}
+	if count >= maxWebhookEndpoints {
+		return nil, "", fmt.Errorf("a vendor can have at most %d webhook endpoints", maxWebhookEndpoints)
+	}
+
+	secret, err := helpers.GenerateWebhookSecret()
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to generate webhook secret: %w", err)
+	}
+
+	isActive := true
+	if request.IsActive != nil {
+		isActive = *request.IsActive
+	}
+
+	insertQuery := `
+	INSERT INTO webhook_endpoints (vendorid, url, secret, event_types, is_active)
+	VALUES ($1, $2, $3, $4, $5)
+	RETURNING *
+	`
+	var endpoint models.WebhookEndpoint
+	err = ws.DB.Get(&endpoint, insertQuery, vendorId, *request.URL, secret, pq.StringArray(request.EventTypes), isActive)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to create webhook endpoint: %w", err)
+	}
+
+	return &endpoint, secret, nil
+}
+
+func (ws *WebhookService) GetEndpoints(vendorId string) ([]*models.WebhookEndpoint, error) {
+	endpoints := []*models.WebhookEndpoint{}
+	query := `SELECT * FROM webhook_endpoints WHERE vendorid = $1 ORDER BY createdat`
+	err := ws.DB.Select(&endpoints, query, vendorId)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching webhook endpoints: %w", err)
+	}
+	return endpoints, nil
+}
+
+func (ws *WebhookService) getEndpoint(vendorId, endpointId string) (*models.WebhookEndpoint, error) {
+	var endpoint models.WebhookEndpoint
+	query := `SELECT * FROM webhook_endpoints WHERE endpointid = $1 AND vendorid = $2`
+	err := ws.DB.Get(&endpoint, query, endpointId, vendorId)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, models.ErrNotFound
+		}
+		return nil, fmt.Errorf("error fetching webhook endpoint: %w", err)
+	}
+	return &endpoint, nil
+}
+
+func (ws *WebhookService) UpdateEndpoint(vendorId, endpointId string, request *models.WebhookEndpointRequest) (*models.WebhookEndpoint, error) {
+	setClauses := []string{}
+	args := []any{}
+	argsIndex := 1
+
+	if request.URL != nil {
+		if err := helpers.ValidateWebhookURL(*request.URL, ws.allowInsecure); err != nil {
+			return nil, err
+		}
+		setClauses = append(setClauses, fmt.Sprintf("url = $%d", argsIndex))
+		args = append(args, *request.URL)
+		argsIndex++
+	}
+
+	if request.EventTypes != nil {
+		if err := validateEventTypes(request.EventTypes); err != nil {
+			return nil, err
+		}
+		setClauses = append(setClauses, fmt.Sprintf("event_types = $%d", argsIndex))
+		args = append(args, pq.StringArray(request.EventTypes))
+		argsIndex++
+	}
+
+	if request.IsActive != nil {
+		setClauses = append(setClauses, fmt.Sprintf("is_active = $%d", argsIndex))
+		args = append(args, *request.IsActive)
+		argsIndex++
+	}
+
+	if len(setClauses) == 0 {
+		return nil, fmt.Errorf("no fields to update")
+	}
+	setClauses = append(setClauses, "updatedat = CURRENT_TIMESTAMP")
+
+	query := fmt.Sprintf(`
+	UPDATE webhook_endpoints
+	SET %s
+	WHERE endpointid = $%d AND vendorid = $%d
+	RETURNING *
+	`, strings.Join(setClauses, ", "), argsIndex, argsIndex+1)
+	args = append(args, endpointId, vendorId)
+
+	var endpoint models.WebhookEndpoint
+	err := ws.DB.Get(&endpoint, query, args...)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, models.ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to update webhook endpoint: %w", err)
+	}
+
+	return &endpoint, nil
+}
+
+// DeleteEndpoint removes the endpoint and its delivery log. Deliveries still queued for
+// it are dropped by the dispatcher.
+func (ws *WebhookService) DeleteEndpoint(vendorId, endpointId string) error {
+	res, err := ws.DB.Exec(`DELETE FROM webhook_endpoints WHERE endpointid = $1 AND vendorid = $2`, endpointId, vendorId)
+	if err != nil {
+		return fmt.Errorf("failed to delete webhook endpoint: %w", err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to delete webhook endpoint: %w", err)
+	}
+	if affected == 0 {
+		return models.ErrNotFound
+	}
+	return nil
+}
+
+// GetDeliveries returns the logged attempts for one of the vendor's endpoints, newest
+// first, paginated with limit and offset
+func (ws *WebhookService) GetDeliveries(vendorId, endpointId string, filters map[string]string) ([]*models.WebhookDelivery, error) {
+	if _, err := ws.getEndpoint(vendorId, endpointId); err != nil {
+		return nil, err
+	}
+
+	limit, offset := pageFilters(filters)
+	query := `
+	SELECT * FROM webhook_deliveries
+	WHERE endpointid = $1
+	ORDER BY createdat DESC, deliveryid DESC
+	LIMIT $2 OFFSET $3
+	`
+	deliveries := []*models.WebhookDelivery{}
+	err := ws.DB.Select(&deliveries, query, endpointId, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching webhook deliveries: %w", err)
+	}
+
+	return deliveries, nil
+}
+
+func newWebhookBody(eventType string, data any) (string, []byte, error) {
+	event := models.WebhookEvent{
+		EventId:   uuid.NewString(),
+		Type:      eventType,
+		CreatedAt: time.Now().UTC(),
+		Data:      data,
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to encode webhook event: %w", err)
+	}
+	return event.EventId, body, nil
+}
+
+// Publish queues an event for each of the vendor's active endpoints subscribed to it.
+// db is the caller's transaction, so the event is only sent if the change is committed.
+func (ws *WebhookService) Publish(db sqlx.Ext, vendorId, eventType string, data any) error {
+	var endpointIds []string
+	query := `
+	SELECT endpointid FROM webhook_endpoints
+	WHERE vendorid = $1 AND is_active AND $2 = ANY(event_types)
+	`
+	err := sqlx.Select(db, &endpointIds, query, vendorId, eventType)
+	if err != nil {
+		return fmt.Errorf("failed to fetch webhook endpoints: %w", err)
+	}
+	if len(endpointIds) == 0 {
+		return nil
+	}
+
+	eventId, body, err := newWebhookBody(eventType, data)
+	if err != nil {
+		return err
+	}
+
+	for _, endpointId := range endpointIds {
+		message := webhookMessage{EndpointId: endpointId, EventId: eventId, EventType: eventType, Body: body}
+		if err := ws.obs.Enqueue(db, OutboxKindWebhook, message); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// deliverMessage is the outbox handler for webhook messages
+func (ws *WebhookService) deliverMessage(message *models.OutboxMessage) error {
+	var webhook webhookMessage
+	if err := json.Unmarshal(message.Payload, &webhook); err != nil {
+		return fmt.Errorf("invalid webhook payload: %w", err)
+	}
+
+	var endpoint models.WebhookEndpoint
+	err := ws.DB.Get(&endpoint, `SELECT * FROM webhook_endpoints WHERE endpointid = $1`, webhook.EndpointId)
+	if err != nil {
+		// The endpoint was deleted since the event was queued
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		return fmt.Errorf("error fetching webhook endpoint: %w", err)
+	}
+	if !endpoint.IsActive {
+		return nil
+	}
+
+	_, err = ws.deliver(&endpoint, &webhook, message.Attempts)
+	return err
+}
+
+// deliver POSTs the event, logs the attempt and returns an error unless the endpoint
+// answered with a 2xx status
+func (ws *WebhookService) deliver(endpoint *models.WebhookEndpoint, webhook *webhookMessage, attempt int) (*models.WebhookDelivery, error) {
+	delivery := &models.WebhookDelivery{
+		EndpointId: endpoint.EndpointId,
+		EventId:    webhook.EventId,
+		EventType:  webhook.EventType,
+		Attempt:    attempt,
+	}
+
+	started := time.Now()
+	deliveryErr := func() error {
+		request, err := http.NewRequest(http.MethodPost, endpoint.URL, bytes.NewReader(webhook.Body))
+		if err != nil {
+			return fmt.Errorf("invalid webhook request: %w", err)
+		}
+		request.Header.Set("Content-Type", "application/json")
+		request.Header.Set("User-Agent", "eCommerce-Webhooks/1.0")
+		request.Header.Set("X-Webhook-Id", webhook.EventId)
+		request.Header.Set("X-Webhook-Event", webhook.EventType)
+		request.Header.Set(helpers.WebhookSignatureHeader, helpers.SignWebhook(endpoint.Secret, time.Now(), webhook.Body))
+
+		response, err := ws.client.Do(request)
+		if err != nil {
+			return err
+		}
+		defer response.Body.Close()
+
+		statusCode := response.StatusCode
+		delivery.StatusCode = &statusCode
+		responseBody, _ := io.ReadAll(io.LimitReader(response.Body, webhookResponseLimit))
+		delivery.ResponseBody = strings.ToValidUTF8(string(responseBody), "")
+
+		if statusCode < 200 || statusCode > 299 {
+			return fmt.Errorf("endpoint responded with status %d", statusCode)
+		}
+		return nil
+	}()
+	delivery.DurationMs = time.Since(started).Milliseconds()
+	delivery.Succeeded = deliveryErr == nil
+	if deliveryErr != nil {
+		delivery.Error = deliveryErr.Error()
+	}
+
+	insertQuery := `
+	INSERT INTO webhook_deliveries (endpointid, eventid, event_type, attempt, status_code, response_body, error, duration_ms, succeeded)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	RETURNING deliveryid, createdat
+	`
+	err := ws.DB.QueryRowx(insertQuery,
+		delivery.EndpointId,
+		delivery.EventId,
+		delivery.EventType,
+		delivery.Attempt,
+		delivery.StatusCode,
+		delivery.ResponseBody,
+		delivery.Error,
+		delivery.DurationMs,
+		delivery.Succeeded,
+	).Scan(&delivery.DeliveryId, &delivery.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to log webhook delivery: %w", err)
+	}
+
+	if deliveryErr != nil {
+		return delivery, fmt.Errorf("webhook delivery failed: %w", deliveryErr)
+	}
+	return delivery, nil
+}
+
+// SendTestEvent sends a webhook.test event to the endpoint right away, even if it is
+// disabled, and returns the logged attempt. It is not retried.
+func (ws *WebhookService) SendTestEvent(vendorId, endpointId string) (*models.WebhookDelivery, error) {
+	endpoint, err := ws.getEndpoint(vendorId, endpointId)
+	if err != nil {
+		return nil, err
+	}
+
+	eventId, body, err := newWebhookBody(WebhookTest, map[string]string{"message": "This is a test event."})
+	if err != nil {
+		return nil, err
+	}
+
+	webhook := &webhookMessage{EndpointId: endpoint.EndpointId, EventId: eventId, EventType: WebhookTest, Body: body}
+	delivery, err := ws.deliver(endpoint, webhook, 1)
+	if delivery != nil {
+		// A failed delivery is a result to show the vendor, not an error
+		return delivery, nil
+	}
+	return nil, err
+}
@@ -48,19 +48,32 @@ func (ws *WishlistService) CreateWishlist(userId string, info *models.WishlistIn
 	return nil
 }
 
+func (ws *WishlistService) GetWishlists(userId string) ([]*models.Wishlist, error) {
+	var wishlists []*models.Wishlist
+	err := ws.DB.Select(&wishlists, `SELECT * FROM wishlists WHERE userid = $1 ORDER BY createdat ASC`, userId)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching wishlists: %w", err)
+	}
+
+	return wishlists, nil
+}
+
 func (ws *WishlistService) GetWishlistItems(userId, wishlistId string) (*models.ReturnedWishlist, error) {
 	var items []*models.ItemData
 	query := `SELECT
 	p.productid,
+	v.variantid,
 	p.name,
 	p.description,
-	p.sku,
-	p.price,
+	v.sku,
+	v.options,
+	COALESCE(v.price, p.price) AS price,
 	p.discount,
 	p.brand,
 	p.category
 	FROM products p 
 	JOIN wishlist_items wi ON wi.productid = p.productid
+	JOIN product_variants v ON v.variantid = wi.variantid
 	JOIN wishlists w on w.wishlistid = wi.wishlistid
 	WHERE w.userid = $1 AND wi.wishlistid = $2
 	`
@@ -163,12 +176,12 @@ func (ws *WishlistService) MoveToCart(userId, wishlistId string) error {
 	}
 
 	_, err = tx.Exec(`
-		INSERT INTO cart_items (cartid, productid, quantity)
-		SELECT c.cartid, w.productid, 1
+		INSERT INTO cart_items (cartid, productid, variantid, quantity)
+		SELECT c.cartid, w.productid, w.variantid, 1
 		FROM carts c
 		JOIN wishlist_items w ON w.wishlistid = $2
 		WHERE c.userid = $1
-		ON CONFLICT (cartid, productid)
+		ON CONFLICT (cartid, variantid)
 		DO UPDATE SET quantity = cart_items.quantity + 1
 	`, userId, wishlistId)
 	if err != nil {
@@ -189,7 +202,9 @@ func (ws *WishlistService) MoveToCart(userId, wishlistId string) error {
 	return nil
 }
 
-func (ws *WishlistService) AddToWishlist(userId, wishlistId, productId string) (*models.ReturnedWishlist, error) {
+// AddToWishlist saves a variant of a product. Like in the cart, variantId can be left out
+// for products without options.
+func (ws *WishlistService) AddToWishlist(userId, wishlistId, productId, variantId string) (*models.ReturnedWishlist, error) {
 	if err := ws.checkOwnership(userId, wishlistId); err != nil {
 		return nil, err
 	}
@@ -206,8 +221,14 @@ func (ws *WishlistService) AddToWishlist(userId, wishlistId, productId string) (
 		}
 	}()
 
-	insertQuery := `INSERT INTO wishlist_items (wishlistid, productid) VALUES ($1, $2)`
-	_, err = tx.Exec(insertQuery, wishlistId, productId)
+	variant, err := resolveVariant(tx, productId, variantId)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	insertQuery := `INSERT INTO wishlist_items (wishlistid, productid, variantid) VALUES ($1, $2, $3)`
+	_, err = tx.Exec(insertQuery, wishlistId, variant.ProductId, variant.VariantId)
 	if err != nil {
 		tx.Rollback()
 		return nil, fmt.Errorf("error adding item to wishlist: %w", err)
@@ -217,15 +238,18 @@ func (ws *WishlistService) AddToWishlist(userId, wishlistId, productId string) (
 	selectQuery := `
 	SELECT
 		p.productid,
+		v.variantid,
 		p.name,
 		p.description,
-		p.sku,
-		p.price,
+		v.sku,
+		v.options,
+		COALESCE(v.price, p.price) AS price,
 		p.discount,
 		p.brand,
 		p.category
 	FROM products p
 	JOIN wishlist_items wi ON wi.productid = p.productid
+	JOIN product_variants v ON v.variantid = wi.variantid
 	JOIN wishlists w ON w.wishlistid = wi.wishlistid
 	WHERE w.userid = $1 AND wi.wishlistid = $2
 	`
@@ -255,14 +279,14 @@ func (ws *WishlistService) MoveItemToCart(userId, wishlistId, wishlistItemId str
 	}
 
 	_, err = tx.Exec(`
-		INSERT INTO cart_items (cartid, productid, quantity)
-		SELECT c.cartid, w.productid, 1
+		INSERT INTO cart_items (cartid, productid, variantid, quantity)
+		SELECT c.cartid, w.productid, w.variantid, 1
 		FROM carts c
 		JOIN wishlist_items w ON w.wishlistid = $2
-		WHERE c.userid = $1 AND w.wishlistid = $2
-		ON CONFLICT (cartid, productid)
+		WHERE c.userid = $1 AND w.wishlistid = $2 AND w.wishlistitemid = $3
+		ON CONFLICT (cartid, variantid)
 		DO UPDATE SET quantity = cart_items.quantity + 1
-	`, userId, wishlistId)
+	`, userId, wishlistId, wishlistItemId)
 	if err != nil {
 		tx.Rollback()
 		return fmt.Errorf("error inserting into cart_items: %w", err)